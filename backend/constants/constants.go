@@ -1,5 +1,7 @@
 package constants
 
+import "time"
+
 const (
 	DateLayout                string = "2006-01-02"
 	DateLayoutRFC3339         string = "2006-01-02T15:04:05.000Z"
@@ -10,4 +12,34 @@ const (
 	ProductURL                string = "https://kqxty15mpg.execute-api.us-east-1.amazonaws.com/products"
 	TransactionsURL           string = "https://kqxty15mpg.execute-api.us-east-1.amazonaws.com/transactions"
 	MaxProductRecommendations int    = 10
+	MaxConcurrentLoads        int    = 4
+	MaxRangeLoadDays          int    = 366
+	MaxConcurrentJobs         int    = 1
+	DefaultJobsDir            string = "jobs"
+	DgraphStorage             string = "dgraph"
+	MemoryStorage             string = "memory"
+	SqliteStorage             string = "sqlite"
+	HttpDataSource            string = "http"
+	DirectoryDataSource       string = "directory"
+	TransactionsBatchSize     int    = 1000
+	MaxRejectedRecords        int    = 1000
+	DefaultQuarantineDir      string = "quarantine"
+	DefaultSyncDir            string = "sync"
+	DefaultArchiveDir         string = "archive"
+	UpsertBatchSize           int    = 250
+	UpstreamMaxRetries        int    = 3
+	UpstreamBreakerThreshold  int    = 5
+	GraphQLMaxDepth           int    = 8
+	GraphQLMaxCost            int    = 1000
+	DefaultPageSize           int    = 20
+	MaxPageSize               int    = 100
 )
+
+const (
+	UpstreamTimeout         time.Duration = 30 * time.Second
+	UpstreamRetryBaseDelay  time.Duration = 500 * time.Millisecond
+	UpstreamBreakerCooldown time.Duration = time.Minute
+)
+
+// Devices the upstream reports transactions from
+var KnownDevices []string = []string{"android", "ios", "linux", "mac", "windows"}
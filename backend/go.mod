@@ -1,11 +1,25 @@
 module module
 
-go 1.16
+go 1.18
 
 require (
 	github.com/dgraph-io/dgo/v2 v2.2.0
 	github.com/go-chi/chi/v5 v5.0.3
+	github.com/graph-gophers/graphql-go v1.3.0
 	github.com/joho/godotenv v1.3.0
+	github.com/mattn/go-sqlite3 v1.14.8
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/shopspring/decimal v1.2.0
 	google.golang.org/grpc v1.39.0
 )
+
+require (
+	github.com/golang/protobuf v1.4.3 // indirect
+	github.com/opentracing/opentracing-go v1.2.0 // indirect
+	github.com/pkg/errors v0.8.1 // indirect
+	golang.org/x/net v0.0.0-20200822124328-c89045814202 // indirect
+	golang.org/x/sys v0.0.0-20200323222414-85ca7c5b95cd // indirect
+	golang.org/x/text v0.3.2 // indirect
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013 // indirect
+	google.golang.org/protobuf v1.25.0 // indirect
+)
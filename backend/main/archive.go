@@ -0,0 +1,399 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	p "module/productfeed"
+	f "module/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	Metadata of a raw feed response kept in the archive. File is the
+	gzip compressed response, next to this metadata, and Sha256 the
+	checksum of the uncompressed response.
+*/
+type ArchivedFeed struct {
+	Date      string
+	Feed      string
+	FetchedAt time.Time
+	Size      int64
+	Sha256    string
+	File      string
+}
+
+/*
+	Keeps every raw feed response in a directory holding one
+	subdirectory per date, e.g. <dir>/2020-08-17/buyers-<fetch time>.gz
+	along with buyers-<fetch time>.json.
+*/
+type feedArchive struct {
+	dir string
+}
+
+/*
+	Archives every feed fetched from the wrapped DataSource as it's
+	read.
+*/
+type archivingDataSource struct {
+	source  DataSource
+	archive *feedArchive
+}
+
+/*
+	Replays the feeds from the archive, serving the latest response
+	archived for each date and feed.
+*/
+type archiveDataSource struct {
+	archive *feedArchive
+}
+
+/*
+	Copies a feed to a temporary archive file as it's read. The file
+	is kept once the feed is closed, if it was read to the end.
+*/
+type archivingFeed struct {
+	feed       io.ReadCloser
+	archive    *feedArchive
+	record     ArchivedFeed
+	tmpPath    string
+	file       *os.File
+	compressor *gzip.Writer
+	hash       hash.Hash
+	failed     bool
+	// Whether the whole feed was read
+	complete bool
+}
+
+/*
+	Reads an archived feed, failing at the end of it if its content
+	doesn't match the archived checksum.
+*/
+type archivedFeedReader struct {
+	file         *os.File
+	decompressor *gzip.Reader
+	hash         hash.Hash
+	record       ArchivedFeed
+}
+
+func newFeedArchive(dir string) (*feedArchive, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating feed archive directory '%s' | %w", dir, err)
+	}
+
+	return &feedArchive{dir: dir}, nil
+}
+
+func newArchivingDataSource(source DataSource, archive *feedArchive) *archivingDataSource {
+	return &archivingDataSource{source: source, archive: archive}
+}
+
+func (source *archivingDataSource) FetchBuyers(date string) (io.ReadCloser, error) {
+	return source.archive.wrap(date, "buyers", source.source.FetchBuyers)
+}
+
+func (source *archivingDataSource) FetchProducts(date string) (io.ReadCloser, error) {
+	return source.archive.wrap(date, "products", source.source.FetchProducts)
+}
+
+func (source *archivingDataSource) FetchTransactions(date string) (io.ReadCloser, error) {
+	return source.archive.wrap(date, "transactions", source.source.FetchTransactions)
+}
+
+/*
+	Fetches the @feed of @date with @fetch, returning it wrapped in an
+	archivingFeed. A feed that can't be archived is still returned,
+	since the archive mustn't make loads fail.
+*/
+func (archive *feedArchive) wrap(date string, feed string, fetch func(date string) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	fetchedAt := time.Now().UTC()
+
+	body, err := fetch(date)
+	if err != nil {
+		return nil, err
+	}
+
+	archivedFeed, err := archive.create(ArchivedFeed{Date: date, Feed: feed, FetchedAt: fetchedAt}, body)
+	if err != nil {
+		fmt.Printf("The %s feed of '%s' won't be archived | %v\n", feed, date, err)
+		return body, nil
+	}
+
+	return archivedFeed, nil
+}
+
+func (archive *feedArchive) create(record ArchivedFeed, body io.ReadCloser) (*archivingFeed, error) {
+	dateDir := filepath.Join(archive.dir, record.Date)
+	err := os.MkdirAll(dateDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	record.File = fmt.Sprintf("%s-%s.gz", record.Feed, record.FetchedAt.Format("20060102T150405.000000000Z"))
+	tmpPath := filepath.Join(dateDir, record.File+".tmp")
+
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &archivingFeed{
+		feed:       body,
+		archive:    archive,
+		record:     record,
+		tmpPath:    tmpPath,
+		file:       file,
+		compressor: gzip.NewWriter(file),
+		hash:       sha256.New(),
+	}, nil
+}
+
+func (feed *archivingFeed) Read(bytes []byte) (int, error) {
+	n, err := feed.feed.Read(bytes)
+	feed.copy(bytes[:n])
+	if err == io.EOF {
+		feed.complete = true
+	}
+
+	return n, err
+}
+
+/*
+	Archives @bytes, giving up on the archive if they can't be written.
+*/
+func (feed *archivingFeed) copy(bytes []byte) {
+	if feed.failed || len(bytes) == 0 {
+		return
+	}
+
+	feed.hash.Write(bytes)
+	feed.record.Size += int64(len(bytes))
+
+	_, err := feed.compressor.Write(bytes)
+	if err != nil {
+		fmt.Printf("The %s feed of '%s' won't be archived | %v\n", feed.record.Feed, feed.record.Date, err)
+		feed.failed = true
+	}
+}
+
+/*
+	Closes the feed, keeping its archive if the whole feed was read
+	and written to it. A feed closed early, e.g. by a canceled load,
+	isn't read to the end, so its archive is dropped.
+*/
+func (feed *archivingFeed) Close() error {
+	closeErr := feed.feed.Close()
+
+	err := feed.compressor.Close()
+	if err == nil {
+		err = feed.file.Close()
+	} else {
+		feed.file.Close()
+	}
+
+	if err == nil && !feed.complete {
+		err = fmt.Errorf("the feed was closed before reading all of it")
+	}
+	if err == nil && !feed.failed {
+		feed.record.Sha256 = hex.EncodeToString(feed.hash.Sum(nil))
+		err = feed.archive.save(feed.record, feed.tmpPath)
+	}
+	if err != nil || feed.failed {
+		os.Remove(feed.tmpPath)
+	}
+	if err != nil {
+		fmt.Printf("The %s feed of '%s' won't be archived | %v\n", feed.record.Feed, feed.record.Date, err)
+	}
+
+	return closeErr
+}
+
+/*
+	Moves the archive file at @tmpPath in place and writes the
+	metadata of @record next to it.
+*/
+func (archive *feedArchive) save(record ArchivedFeed, tmpPath string) error {
+	dateDir := filepath.Join(archive.dir, record.Date)
+
+	err := os.Rename(tmpPath, filepath.Join(dateDir, record.File))
+	if err != nil {
+		return err
+	}
+
+	jsonRecord, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dateDir, strings.TrimSuffix(record.File, ".gz")+".json"), jsonRecord, 0644)
+}
+
+func (source *archiveDataSource) FetchBuyers(date string) (io.ReadCloser, error) {
+	return source.archive.open(date, "buyers")
+}
+
+func (source *archiveDataSource) FetchProducts(date string) (io.ReadCloser, error) {
+	return source.archive.open(date, "products")
+}
+
+func (source *archiveDataSource) FetchTransactions(date string) (io.ReadCloser, error) {
+	return source.archive.open(date, "transactions")
+}
+
+/*
+	Returns the metadata of the latest response archived for the
+	@feed of @date.
+*/
+func (archive *feedArchive) findLatest(date string, feed string) (ArchivedFeed, error) {
+	paths, err := filepath.Glob(filepath.Join(archive.dir, date, feed+"-*.json"))
+	if err != nil {
+		return ArchivedFeed{}, err
+	}
+
+	if len(paths) == 0 {
+		return ArchivedFeed{}, fmt.Errorf("no archived %s feed for '%s'", feed, date)
+	}
+
+	// The fetch time in the file names sorts them chronologically
+	sort.Strings(paths)
+	latestPath := paths[len(paths)-1]
+
+	jsonRecord, err := os.ReadFile(latestPath)
+	if err != nil {
+		return ArchivedFeed{}, fmt.Errorf("error while reading archive metadata '%s' | %w", latestPath, err)
+	}
+
+	var record ArchivedFeed
+	err = json.Unmarshal(jsonRecord, &record)
+	if err != nil {
+		return ArchivedFeed{}, fmt.Errorf("error while unmarshalling archive metadata '%s' | %w", latestPath, err)
+	}
+
+	return record, nil
+}
+
+func (archive *feedArchive) open(date string, feed string) (io.ReadCloser, error) {
+	err := isDateParamValid(date)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := archive.findLatest(date, feed)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filepath.Join(archive.dir, date, record.File))
+	if err != nil {
+		return nil, fmt.Errorf("error while opening archived %s feed of '%s' | %w", feed, date, err)
+	}
+
+	decompressor, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error while decompressing archived %s feed of '%s' | %w", feed, date, err)
+	}
+
+	return &archivedFeedReader{file: file, decompressor: decompressor, hash: sha256.New(), record: record}, nil
+}
+
+func (reader *archivedFeedReader) Read(bytes []byte) (int, error) {
+	n, err := reader.decompressor.Read(bytes)
+	reader.hash.Write(bytes[:n])
+
+	if err == io.EOF && hex.EncodeToString(reader.hash.Sum(nil)) != reader.record.Sha256 {
+		return n, fmt.Errorf("archived %s feed of '%s' doesn't match its checksum", reader.record.Feed, reader.record.Date)
+	}
+
+	return n, err
+}
+
+func (reader *archivedFeedReader) Close() error {
+	reader.decompressor.Close()
+	return reader.file.Close()
+}
+
+/*
+	Parses and persists the archived feeds of a date, or of every
+	date of a range, again, without fetching anything from the
+	upstream: replay [-force] <date> [<to>]
+*/
+func runReplayCommand(store Store, args []string) error {
+	force := len(args) > 0 && args[0] == "-force"
+	if force {
+		args = args[1:]
+	}
+
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: replay [-force] <date> [<to>]")
+	}
+
+	dates := []string{args[0]}
+	if len(args) == 2 {
+		var err error
+		dates, err = getDateRange(args[0], args[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	archive, err := newArchive(f.GoDotEnvVariable("ARCHIVE_DIR"))
+	if err != nil {
+		return err
+	}
+
+	productFeedMode, err := p.ParseMode(f.GoDotEnvVariable("PRODUCT_FEED_MODE"))
+	if err != nil {
+		return err
+	}
+
+	quarantine, err := newQuarantine(f.GoDotEnvVariable("QUARANTINE_DIR"))
+	if err != nil {
+		return err
+	}
+
+	migrator, ok := store.(Migrator)
+	if ok {
+		err = migrator.Migrate()
+		if err != nil {
+			return err
+		}
+	}
+
+	service := &RestaurantService{
+		store:           store,
+		source:          &archiveDataSource{archive: archive},
+		productFeedMode: productFeedMode,
+		quarantine:      quarantine,
+	}
+
+	failed := 0
+	for _, date := range dates {
+		loadResponse, err := service.loadDate(date, force, nil)
+		if err != nil {
+			fmt.Printf("%s: %v\n", date, err)
+			failed++
+			continue
+		}
+
+		counts := loadResponse.counts()
+		fmt.Printf("%s: %d buyers, %d products and %d transactions saved, %d records quarantined\n",
+			date, counts.Buyers, counts.Products, counts.Transactions, counts.Quarantined)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dates couldn't be replayed", failed, len(dates))
+	}
+
+	return nil
+}
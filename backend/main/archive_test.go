@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+	Archives @content as the @feed of testDate fetched at @fetchedAt,
+	reading it to the end.
+*/
+func archiveTestFeed(t *testing.T, archive *feedArchive, feed string, content string, fetchedAt time.Time) {
+	t.Helper()
+
+	archived, err := archive.create(ArchivedFeed{Date: testDate, Feed: feed, FetchedAt: fetchedAt}, io.NopCloser(strings.NewReader(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = io.ReadAll(archived)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = archived.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveKeepsFeedReadToTheEnd(t *testing.T) {
+	archive, err := newFeedArchive(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feed, err := archive.wrap(testDate, "buyers", newFakeDataSource().FetchBuyers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = feed.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != testBuyersFeed {
+		t.Errorf("got feed %q through the archive, want %q", content, testBuyersFeed)
+	}
+
+	record, err := archive.findLatest(testDate, "buyers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checksum := sha256.Sum256([]byte(testBuyersFeed))
+	if record.Size != int64(len(testBuyersFeed)) || record.Sha256 != hex.EncodeToString(checksum[:]) {
+		t.Errorf("got size %d and sha256 %s, want %d and %x", record.Size, record.Sha256, len(testBuyersFeed), checksum)
+	}
+
+	tmpFiles, err := filepath.Glob(filepath.Join(archive.dir, testDate, "*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tmpFiles) != 0 {
+		t.Errorf("got temporary files %v left in the archive", tmpFiles)
+	}
+}
+
+/*
+	A feed that isn't read to the end, e.g. by a canceled load, leaves
+	nothing in the archive.
+*/
+func TestArchiveDropsFeedClosedEarly(t *testing.T) {
+	archive, err := newFeedArchive(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feed, err := archive.wrap(testDate, "buyers", newFakeDataSource().FetchBuyers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = feed.Read(make([]byte, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = feed.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := os.ReadDir(filepath.Join(archive.dir, testDate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got %d files in the archive, want none", len(files))
+	}
+
+	_, err = archive.open(testDate, "buyers")
+	if err == nil {
+		t.Errorf("opened the archive of a feed closed early")
+	}
+}
+
+/*
+	A replay loads the latest response archived for each feed, without
+	fetching anything.
+*/
+func TestReplayReadsLatestArchive(t *testing.T) {
+	archive, err := newFeedArchive(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetchedAt := time.Date(2020, 8, 17, 10, 0, 0, 0, time.UTC)
+	archiveTestFeed(t, archive, "buyers", `[{"id":"b1","name":"Old","age":30}]`, fetchedAt)
+	archiveTestFeed(t, archive, "buyers", testBuyersFeed, fetchedAt.Add(time.Minute))
+	archiveTestFeed(t, archive, "products", testProductsFeed, fetchedAt)
+	archiveTestFeed(t, archive, "transactions", testTransactionsFeed, fetchedAt)
+
+	store := newMemoryStore()
+	service := &RestaurantService{store: store, source: &archiveDataSource{archive: archive}}
+
+	loaded, err := service.loadDate(testDate, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := loaded.counts()
+	if counts.Buyers != 2 || counts.Products != 2 || counts.Transactions != 2 {
+		t.Errorf("got counts %+v, want 2 of each from the latest archives", counts)
+	}
+	if !isTestDateSynchronized(t, store) {
+		t.Errorf("date not synchronized after the replay")
+	}
+
+	buyers, err := store.Buyers().FindBuyers(PageRequest{First: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(buyerIdsOf(buyers), []string{"b1", "b2"}) || buyers.Buyers[0].Name != "Ann" {
+		t.Errorf("got buyers %+v, want b1 and b2 of the latest buyers feed", buyers.Buyers)
+	}
+}
@@ -3,17 +3,16 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	c "module/constants"
+	p "module/productfeed"
+	t "module/transactionfeed"
 	f "module/utils"
-	"net/http"
-	"strings"
 	"sync"
 	"time"
 
-	"github.com/dgraph-io/dgo/v2"
-	"github.com/dgraph-io/dgo/v2/protos/api"
 	d "github.com/shopspring/decimal"
 )
 
@@ -21,6 +20,7 @@ type Buyer struct {
 	BuyerId string
 	Age     int
 	Name    string
+	Date    string `json:",omitempty"`
 	Type    string `json:"dgraph.type,omitempty"`
 }
 
@@ -32,6 +32,7 @@ type BuyerUnmarshall struct {
 	BuyerId string `json:"id,omitempty"`
 	Age     int
 	Name    string
+	Date    string `json:"-"`
 	Type    string `json:"dgraph.type,omitempty"`
 }
 
@@ -43,6 +44,7 @@ type Product struct {
 	ProductId string
 	Name      string
 	Price     d.Decimal
+	Date      string `json:",omitempty"`
 	Type      string `json:"dgraph.type,omitempty"`
 }
 
@@ -57,555 +59,655 @@ type Transaction struct {
 }
 
 type DataLoader struct {
-	dateStr string
-	txn     *dgo.Txn
+	dateStr  string
+	store    Store
+	source   DataSource
+	txn      Txn
+	progress progressFunc
+	// Canceled when a stage of the load fails, to stop the others
+	ctx context.Context
+	// How malformed lines of the products feed are handled
+	productFeedMode p.Mode
+	quarantine      QuarantineRepository
+	validation      *loadValidation
+	// Released once the buyers and products of the feeds are saved,
+	// so that the transactions can be validated against them
+	idsReady sync.WaitGroup
+	// Whether each id checked so far is saved
+	knownBuyerIds   map[string]bool
+	knownProductIds map[string]bool
+	// Discards the txn instead of committing it, leaving the quarantine untouched
+	dryRun bool
+	// Records of the feeds that were saved already, and so left out
+	existing LoadCounts
 }
 
+/*
+	Outcome of a load. The transactions aren't kept, since a day can
+	have too many of them, only how many were saved.
+*/
 type LoadResponse struct {
-	Buyers       []Buyer
-	Products     []Product
-	Transactions []Transaction
+	Buyers          []Buyer
+	Products        []Product
+	TransactionsQty int
+	Validation      ValidationReport
 }
 
-func (dataLoader *DataLoader) loadRestaurantData() ([]byte, error) {
-
-	waitGroup := sync.WaitGroup{}
-	errorChan := make(chan error)
-	wgDone := make(chan bool)
-	productsChan := make(chan []Product, 1)
-	transactionsChan := make(chan []Transaction, 1)
-	buyersChan := make(chan []Buyer, 1)
-
-	waitGroup.Add(3)
-	go dataLoader.loadProducts(errorChan, productsChan, &waitGroup)
-	go dataLoader.loadBuyers(errorChan, buyersChan, &waitGroup)
-	go dataLoader.loadTransactions(errorChan, transactionsChan, &waitGroup)
+type LoadCounts struct {
+	Buyers       int
+	Products     int
+	Transactions int
+	Quarantined  int `json:",omitempty"`
+}
 
-	go func() {
-		waitGroup.Wait()
-		close(wgDone)
-	}()
+/*
+	Outcome of a dry run: what a load of Date would save, and how
+	many of the records of its feeds are saved already. The issues of
+	the validation report have no QuarantineId, since nothing is
+	quarantined.
+*/
+type DryRunResponse struct {
+	Date     string
+	New      LoadCounts
+	Existing LoadCounts
+	*LoadResponse
+}
 
-	select {
-	case err := <-errorChan:
-		return nil, err
+func (loadResponse *LoadResponse) counts() LoadCounts {
+	return LoadCounts{
+		Buyers:       len(loadResponse.Buyers),
+		Products:     len(loadResponse.Products),
+		Transactions: loadResponse.TransactionsQty,
+		Quarantined:  loadResponse.Validation.Quarantined,
+	}
+}
 
-	case <-wgDone:
-		dataLoader.txn.Commit(context.Background())
+/*
+	Runs the products, buyers and transactions stages concurrently in
+	the loader's txn, which is committed only if all of them succeed.
+	The first stage to fail cancels the others and the txn is
+	discarded, so a failed load leaves nothing behind. A failed commit
+	is returned as the error of the load. Dry runs always discard the
+	txn.
+*/
+func (dataLoader *DataLoader) loadRestaurantData() (*LoadResponse, error) {
+	loadCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dataLoader.ctx = loadCtx
+	dataLoader.validation = newLoadValidation(dataLoader.dateStr)
+	// Created before any stage runs, since the transactions stage
+	// checks them even if the buyers or products stages fail
+	dataLoader.knownBuyerIds = map[string]bool{}
+	dataLoader.knownProductIds = map[string]bool{}
+	dataLoader.idsReady.Add(2)
+
+	dataLoaded := &LoadResponse{}
+	var loadErr error
+	var failOnce sync.Once
+	waitGroup := sync.WaitGroup{}
 
-		dataLoaded := &LoadResponse{
-			Buyers:       <-buyersChan,
-			Products:     <-productsChan,
-			Transactions: <-transactionsChan,
+	runStage := func(stage string, providesIds bool, load func() error) {
+		defer waitGroup.Done()
+		// Released once the failure, if any, is recorded, so that the
+		// transactions stage sees the load canceled when it wakes up
+		if providesIds {
+			defer dataLoader.idsReady.Done()
 		}
 
-		jsonData, err := json.Marshal(dataLoaded)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal loaded restaurant data: %w", err)
+		err := load()
+		if err == nil {
+			return
 		}
 
-		return jsonData, nil
-	}
-}
-
-func (dataLoader *DataLoader) loadProducts(errChan chan<- error, productsChan chan<- []Product, waitGroup *sync.WaitGroup) {
-	defer waitGroup.Done()
-	fmt.Println("Loading products...")
+		// The stages stopped by a failure don't hide the error that caused it
+		if errors.Is(err, context.Canceled) && loadCtx.Err() != nil {
+			return
+		}
 
-	rawProductsLines, err := dataLoader.fetchProductsFromAWS()
-	if err != nil {
-		errChan <- err
-		return
+		failOnce.Do(func() {
+			loadErr = err
+			cancel()
+		})
+		dataLoader.fail(stage, err)
 	}
 
-	products, err := dataLoader.parseProducts(rawProductsLines)
-	if err != nil {
-		errChan <- fmt.Errorf("error while parsing products | %w", err)
-		return
-	}
+	waitGroup.Add(3)
+	go runStage(ProductsStage, true, func() (err error) {
+		dataLoaded.Products, err = dataLoader.loadProducts()
+		return err
+	})
+	go runStage(BuyersStage, true, func() (err error) {
+		dataLoaded.Buyers, err = dataLoader.loadBuyers()
+		return err
+	})
+	go runStage(TransactionsStage, false, func() (err error) {
+		dataLoaded.TransactionsQty, err = dataLoader.loadTransactions()
+		return err
+	})
+	waitGroup.Wait()
 
-	jsonProducts, err := json.Marshal(products)
-	if err != nil {
-		fmt.Printf("Error while marshalling products for database upload | %v\n", err)
-		errChan <- err
-		return
+	if loadErr != nil {
+		dataLoader.txn.Discard()
+		return nil, loadErr
 	}
 
-	err = dataLoader.persistProducts(jsonProducts)
-	if err != nil {
-		errChan <- err
-		return
+	if dataLoader.dryRun {
+		dataLoader.txn.Discard()
+		dataLoaded.Validation = dataLoader.validation.report
+		dataLoaded.Validation.Issues = withoutQuarantineIds(dataLoaded.Validation.Issues)
+		return dataLoaded, nil
 	}
 
-	productsChan <- products
-	close(productsChan)
-}
-
-func (dataLoader *DataLoader) fetchProductsFromAWS() ([]string, error) {
-	req, err := http.NewRequest("GET", c.ProductURL, nil)
+	err := dataLoader.txn.Commit()
 	if err != nil {
-		fmt.Println(err)
+		err = fmt.Errorf("error while committing the data of '%s' | %w", dataLoader.dateStr, err)
+		dataLoader.fail(CommitStage, err)
 		return nil, err
 	}
+	dataLoader.report(CommitStage, CommittedStep, 0)
+
+	dataLoaded.Validation = dataLoader.validation.report
 
-	q := req.URL.Query()
-	timestamp, err := f.DateStringToTimestamp(dataLoader.dateStr)
+	err = dataLoader.quarantineRejected()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("data loaded, but the rejected records couldn't be quarantined | %w", err)
 	}
 
-	var dateAsTimestamp string = fmt.Sprint(timestamp)
-	q.Add("date", dateAsTimestamp)
+	return dataLoaded, nil
+}
 
-	req.URL.RawQuery = q.Encode()
-	requestUrl := req.URL.String()
+func (dataLoader *DataLoader) loadProducts() ([]Product, error) {
+	fmt.Println("Loading products...")
 
-	resp, err := http.Get(requestUrl)
+	parsed, err := dataLoader.fetchProducts()
 	if err != nil {
-		fmt.Printf("Error in response for GET request: '%s' | %v\n", requestUrl, err)
 		return nil, err
 	}
+	dataLoader.report(ProductsStage, FetchedStep, parsed.Lines)
+	dataLoader.report(ProductsStage, ParsedStep, len(parsed.Products))
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("Error while reading response body for request '%s' | %v\n", requestUrl, err)
-		return nil, err
-	}
+	products := dataLoader.dedupeProducts(parsed.Products)
+	dataLoader.report(ProductsStage, DeduplicatedStep, len(products))
 
-	defer func() {
-		err = resp.Body.Close()
-	}()
+	err = dataLoader.ctx.Err()
 	if err != nil {
 		return nil, err
 	}
 
-	rawProductsLines := strings.Split(string(body), "\n")
-	return rawProductsLines, nil
-}
-
-func (dataLoader *DataLoader) parseProducts(rawProductsLines []string) ([]Product, error) {
-	addedProductIds, err := dataLoader.getPersistedProductsIds()
+	deduplicated := len(products)
+	products, err = dataLoader.store.Products().SaveProducts(dataLoader.txn, products)
 	if err != nil {
 		return nil, err
 	}
+	dataLoader.existing.Products = deduplicated - len(products)
+	dataLoader.report(ProductsStage, PersistedStep, len(products))
 
-	var products []Product
-
-	for _, line := range rawProductsLines {
-		// c89db54f'Campbell's minestrone italian style slow simmered soup'8841
-		lineSections := strings.Split(line, "'")
-
-		if len(lineSections) < 3 {
-			continue
-		}
-
-		var id, name string
-		var price d.Decimal
-		var priceErr error
-
-		/*
-			In this case the splitting have to rules change because if
-			they don't, a string such as:
-			<6621fd74'"Campbell's soup chicken & sausage"'3625> would
-			have <s soup chicken & sausage> as its third element after the
-			split, leading to an error, because the code expects the third
-			element to be the price of the product.
-		*/
-		if strings.Contains(line, `"`) {
-			id = lineSections[0]
-			firstQuotePos := strings.Index(line, `"`)
-			lastQuotePos := strings.LastIndex(line, `"`)
-			name = strings.ReplaceAll(line[firstQuotePos+1:lastQuotePos], "&quot;", "'")
-			price, err = d.NewFromString(lineSections[len(lineSections)-1])
-		} else {
-			id = lineSections[0]
-			name = strings.ReplaceAll(lineSections[1], "&quot;", "'")
-			price, err = d.NewFromString(lineSections[2])
-		}
-
+	for _, rejected := range parsed.Rejected {
+		err = dataLoader.validation.reject(ProductsFeed, rejected.Text, rejected.Line,
+			[]ValidationIssue{{Code: MalformedLineIssue, Reason: rejected.Reason}})
 		if err != nil {
-			fmt.Printf("parseProducts: Error while casting products prices from string to decimal.Decimal | %v\n", priceErr)
 			return nil, err
 		}
+	}
 
-		newProduct := Product{
-			ProductId: id,
-			Name:      name,
-			Price:     price,
-			Type:      c.ProductType,
-		}
-
-		if !f.ArrayContains(addedProductIds, id) && name != "" && name != "null" {
-			products = append(products, newProduct)
-			addedProductIds = append(addedProductIds, id)
-		}
+	if len(parsed.Rejected) > 0 {
+		fmt.Printf("%d product lines of '%s' were quarantined\n", len(parsed.Rejected), dataLoader.dateStr)
 	}
 
+	fmt.Println("Products loaded.")
 	return products, nil
 }
 
-func (dataLoader *DataLoader) getPersistedProductsIds() ([]string, error) {
-	var addedProductIds []string
-
-	query := `{
-		products(func: type(Product)){
-			  expand(_all_){}
-		}
-	  }`
-
-	res, err := dataLoader.txn.Query(ctx, query)
+func (dataLoader *DataLoader) fetchProducts() (p.Result, error) {
+	feed, err := dataLoader.openFeed(dataLoader.source.FetchProducts)
 	if err != nil {
-		return nil, fmt.Errorf("error while retrieving products from database | %w", err)
+		return p.Result{}, err
 	}
+	defer feed.Close()
 
-	var productHolder ProductHolder
-	err = json.Unmarshal(res.Json, &productHolder)
+	parsed, err := p.Parse(feed, dataLoader.productFeedMode)
 	if err != nil {
-		return nil, fmt.Errorf("error while unmarshalling products retrieved from database | %w", err)
+		return p.Result{}, fmt.Errorf("error while parsing products | %w", err)
 	}
 
-	for _, product := range productHolder.Products {
-		addedProductIds = append(addedProductIds, product.ProductId)
-	}
-
-	return addedProductIds, nil
+	return parsed, nil
 }
 
-func (dataLoader *DataLoader) persistProducts(jsonProducts []byte) error {
-	mutation := &api.Mutation{
-		SetJson: jsonProducts,
-	}
-
-	req := &api.Request{
-		Mutations: []*api.Mutation{mutation},
-	}
-
-	_, err := dataLoader.txn.Do(context.Background(), req)
+/*
+	Converts @parsedProducts to Products, leaving out the ones that
+	appear more than once in the feed. The ones already saved are
+	left out when they are upserted.
+*/
+func (dataLoader *DataLoader) dedupeProducts(parsedProducts []p.Product) []Product {
+	var products []Product
+	for _, parsedProduct := range parsedProducts {
+		if dataLoader.knownProductIds[parsedProduct.Id] {
+			continue
+		}
 
-	if err != nil {
-		fmt.Printf("Error while persisting new products | %v\n", err)
-		return err
+		products = append(products, Product{
+			ProductId: parsedProduct.Id,
+			Name:      parsedProduct.Name,
+			Price:     parsedProduct.Price,
+			Date:      dataLoader.dateStr,
+			Type:      c.ProductType,
+		})
+		dataLoader.knownProductIds[parsedProduct.Id] = true
 	}
 
-	fmt.Println("Products loaded.")
-	return nil
+	return products
 }
 
-func (dataLoader *DataLoader) loadBuyers(errChan chan<- error, buyersChan chan<- []Buyer, waitGroup *sync.WaitGroup) {
-	defer waitGroup.Done()
+func (dataLoader *DataLoader) loadBuyers() ([]Buyer, error) {
 	fmt.Println("Loading buyers...")
 
-	unfilteredBuyers, err := dataLoader.fetchBuyersFromAWS()
+	unfilteredBuyers, err := dataLoader.fetchBuyers()
 	if err != nil {
-		errChan <- err
-		return
+		return nil, err
 	}
+	dataLoader.report(BuyersStage, FetchedStep, len(unfilteredBuyers))
+	dataLoader.report(BuyersStage, ParsedStep, len(unfilteredBuyers))
 
+	// The ones already saved are left out when they are upserted
 	var buyers []BuyerUnmarshall
 
-	addedBuyerIds, err := dataLoader.getPersistedBuyersIds()
-	if err != nil {
-		errChan <- err
-		return
-	}
-
 	for _, b := range unfilteredBuyers {
-		if !f.ArrayContains(addedBuyerIds, b.BuyerId) {
+		if !dataLoader.knownBuyerIds[b.BuyerId] {
 			buyers = append(buyers, b)
-			addedBuyerIds = append(addedBuyerIds, b.BuyerId)
+			dataLoader.knownBuyerIds[b.BuyerId] = true
 		}
 	}
 
-	jsonBuyers, err := dataLoader.marshalBuyers(&buyers)
-	if err != nil {
-		fmt.Printf("Error while marshalling buyers object for database persistence |%v\n", err)
-		errChan <- err
-		return
-	}
+	buyersRes := dataLoader.toBuyers(buyers)
+	dataLoader.report(BuyersStage, DeduplicatedStep, len(buyersRes))
 
-	err = dataLoader.persistBuyers(jsonBuyers)
+	err = dataLoader.ctx.Err()
 	if err != nil {
-		errChan <- fmt.Errorf("error while persisting buyers | %w", err)
-		return
+		return nil, err
 	}
 
-	var buyersRes []Buyer
-	err = json.Unmarshal(jsonBuyers, &buyersRes)
+	deduplicated := len(buyersRes)
+	buyersRes, err = dataLoader.store.Buyers().SaveBuyers(dataLoader.txn, buyersRes)
 	if err != nil {
-		errChan <- err
-		return
+		return nil, fmt.Errorf("error while persisting buyers | %w", err)
 	}
+	dataLoader.existing.Buyers = deduplicated - len(buyersRes)
+	dataLoader.report(BuyersStage, PersistedStep, len(buyersRes))
 
-	buyersChan <- buyersRes
-	close(buyersChan)
-
+	fmt.Println("Buyers loaded.")
+	return buyersRes, nil
 }
 
-func (dataLoader *DataLoader) fetchBuyersFromAWS() ([]BuyerUnmarshall, error) {
-	//Form request URL
-	req, err := http.NewRequest("GET", c.BuyersURL, nil)
+func (dataLoader *DataLoader) fetchBuyers() ([]BuyerUnmarshall, error) {
+	body, err := dataLoader.readFeed(dataLoader.source.FetchBuyers)
 	if err != nil {
-		fmt.Printf("Error while forming GET request '%s' | %v\n", c.BuyersURL, err)
 		return nil, err
 	}
 
-	q := req.URL.Query()
-	timestamp, err := f.DateStringToTimestamp(dataLoader.dateStr)
+	var unfilteredBuyers []BuyerUnmarshall
+	err = json.Unmarshal(body, &unfilteredBuyers)
 	if err != nil {
+		fmt.Printf("Error while unmarshalling buyers of '%s' | %v\n", dataLoader.dateStr, err)
 		return nil, err
 	}
 
-	var dateAsTimestamp string = fmt.Sprint(timestamp)
-	q.Add("date", dateAsTimestamp)
+	return unfilteredBuyers, nil
+}
 
-	req.URL.RawQuery = q.Encode()
-	requestUrl := req.URL.String()
+/*
+	Convert BuyerUnmarshall to Buyer
+*/
+func (dataLoader *DataLoader) toBuyers(buyers []BuyerUnmarshall) []Buyer {
+	var a []Buyer = []Buyer{}
+	for _, e := range buyers {
+		e.Type = c.BuyerType
+		e.Date = dataLoader.dateStr
+		a = append(a, Buyer(e))
+	}
 
-	// Make GET request
-	resp, err := http.Get(requestUrl)
+	return a
+}
+
+func (dataLoader *DataLoader) loadTransactions() (int, error) {
+	fmt.Println("Loading transactions...")
+
+	feed, err := dataLoader.openFeed(dataLoader.source.FetchTransactions)
 	if err != nil {
-		fmt.Printf("Error in response for GET request '%s' | %v\n", requestUrl, err)
-		return nil, err
+		return 0, err
 	}
+	defer feed.Close()
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	persisted, err := dataLoader.persistTransactions(t.NewDecoder(feed))
 	if err != nil {
-		fmt.Printf("Error reading body of response for GET request '%s' | %v\n", requestUrl, err)
-		return nil, err
+		return 0, fmt.Errorf("failed to persist transactions | %w", err)
+	}
+	dataLoader.report(TransactionsStage, PersistedStep, persisted)
+
+	return persisted, nil
+}
+
+/*
+	Opens the feed returned by @fetch for the loader's date. Reading
+	it fails once the load is canceled.
+*/
+func (dataLoader *DataLoader) openFeed(fetch func(date string) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	feed, err := fetch(dataLoader.dateStr)
+	if err != nil {
+		return nil, newUpstreamError("error while fetching the feeds", err)
 	}
-	defer func() {
-		err = resp.Body.Close()
-	}()
+
+	return &cancelableFeed{ctx: dataLoader.ctx, feed: feed}, nil
+}
+
+/*
+	Reads the whole feed returned by @fetch for the loader's date.
+*/
+func (dataLoader *DataLoader) readFeed(fetch func(date string) (io.ReadCloser, error)) ([]byte, error) {
+	feed, err := dataLoader.openFeed(fetch)
 	if err != nil {
 		return nil, err
 	}
+	defer feed.Close()
 
-	var unfilteredBuyers []BuyerUnmarshall
-	err = json.Unmarshal(body, &unfilteredBuyers)
+	body, err := io.ReadAll(feed)
 	if err != nil {
-		fmt.Printf("Error while unmarshalling buyers object obtained from response for GET request '%s'\n| %v", requestUrl, err)
+		fmt.Printf("Error while reading feed of '%s' | %v\n", dataLoader.dateStr, err)
 		return nil, err
 	}
 
-	return unfilteredBuyers, nil
+	return body, nil
 }
 
-func (dataLoader *DataLoader) getPersistedBuyersIds() ([]string, error) {
-	var addedIds []string
-	query := `{
-		buyers(func: type(Buyer)){
-			  expand(_all_){}
-		}
-	  }`
+/*
+	Decodes the transactions feed and saves its valid records in
+	batches of c.TransactionsBatchSize, so that only one batch is in
+	memory at a time. Records that can't be decoded or don't pass
+	validation are quarantined. Returns the number of transactions
+	saved.
+*/
+func (dataLoader *DataLoader) persistTransactions(decoder *t.Decoder) (int, error) {
+	defer f.TimeTrack(time.Now(), "persistTransactions")
 
-	res, err := dataLoader.txn.Query(ctx, query)
+	// The buyers and products of the feeds have to be saved first
+	dataLoader.idsReady.Wait()
+	err := dataLoader.ctx.Err()
 	if err != nil {
-		return nil, fmt.Errorf("error while fetching buyers from database %w", err)
+		return 0, err
 	}
 
-	var buyerHolder BuyerHolder
-	err = json.Unmarshal(res.Json, &buyerHolder)
-	if err != nil {
-		return nil, fmt.Errorf("error while unmarshalling buyers retrieved from database | %w", err)
+	validator := newTransactionValidator(dataLoader.isKnownBuyer, dataLoader.isKnownProduct)
+
+	batch := make([]Transaction, 0, c.TransactionsBatchSize)
+	persisted := 0
+	decoded := 0
+
+	save := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		err := dataLoader.ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		saved, err := dataLoader.store.Transactions().SaveTransactions(dataLoader.txn, batch)
+		if err != nil {
+			return err
+		}
+
+		persisted += saved
+		dataLoader.existing.Transactions += len(batch) - saved
+		batch = batch[:0]
+		return nil
 	}
 
-	for _, buyer := range buyerHolder.Buyers {
-		addedIds = append(addedIds, buyer.BuyerId)
+	for {
+		record, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+
+		var recordErr *t.RecordError
+		if errors.As(err, &recordErr) {
+			err = dataLoader.validation.reject(TransactionsFeed, recordErr.Text, recordErr.Number,
+				[]ValidationIssue{{Code: MalformedRecordIssue, Reason: recordErr.Reason}})
+			if err != nil {
+				return persisted, err
+			}
+			continue
+		}
+		if err != nil {
+			return persisted, err
+		}
+
+		// A failed stage leaves the known ids incomplete
+		err = dataLoader.ctx.Err()
+		if err != nil {
+			return persisted, err
+		}
+
+		decoded++
+		transaction := dataLoader.toTransaction(record)
+
+		issues, err := validator.validate(transaction)
+		if err != nil {
+			return persisted, err
+		}
+		if len(issues) > 0 {
+			err = dataLoader.validation.reject(TransactionsFeed, record.String(), record.Number, issues)
+			if err != nil {
+				return persisted, err
+			}
+			continue
+		}
+
+		batch = append(batch, transaction)
+
+		if len(batch) == c.TransactionsBatchSize {
+			err = save()
+			if err != nil {
+				return persisted, err
+			}
+			dataLoader.report(TransactionsStage, ParsedStep, decoded)
+		}
 	}
 
-	return addedIds, nil
-}
+	err = save()
+	if err != nil {
+		return persisted, err
+	}
+	dataLoader.report(TransactionsStage, ParsedStep, decoded)
 
-/*
-	Convert BuyerUnmarshall to Buyer
-*/
-func (dataLoader *DataLoader) marshalBuyers(buyers *[]BuyerUnmarshall) ([]byte, error) {
-	var a []Buyer = []Buyer{}
-	for _, e := range *buyers {
-		e.Type = c.BuyerType
-		a = append(a, Buyer(e))
+	rejected := dataLoader.validation.rejected(TransactionsFeed)
+	if rejected > 0 {
+		fmt.Printf("%d transaction records of '%s' were quarantined\n", rejected, dataLoader.dateStr)
 	}
 
-	return json.Marshal(&a)
+	return persisted, nil
 }
 
-func (dataLoader *DataLoader) persistBuyers(jsonBuyers []byte) error {
-	mutation := &api.Mutation{
-		SetJson: jsonBuyers,
+func (dataLoader *DataLoader) toTransaction(record t.Record) Transaction {
+	return Transaction{
+		TransactionId: record.Id,
+		BuyerId:       record.BuyerId,
+		Ip:            record.Ip,
+		Device:        record.Device,
+		Products:      record.Products,
+		Date:          dataLoader.dateStr,
+		Type:          c.TransactionType,
 	}
+}
 
-	req := &api.Request{
-		Mutations: []*api.Mutation{mutation},
+/*
+	Reports whether the buyer @buyerId is saved. The buyers of the
+	feed are, and the rest are looked up once.
+*/
+func (dataLoader *DataLoader) isKnownBuyer(buyerId string) (bool, error) {
+	known, checked := dataLoader.knownBuyerIds[buyerId]
+	if checked {
+		return known, nil
 	}
 
-	_, err := dataLoader.txn.Do(context.Background(), req)
-
+	buyers, err := dataLoader.store.Buyers().FindBuyersByIds([]string{buyerId}, "", PageRequest{First: 1})
 	if err != nil {
-		fmt.Printf("Error while persisting buyers to database: %v", err)
-		return err
+		return false, err
 	}
 
-	fmt.Println("Buyers loaded.")
-	return nil
+	dataLoader.knownBuyerIds[buyerId] = len(buyers.Buyers) > 0
+	return dataLoader.knownBuyerIds[buyerId], nil
 }
 
-func (dataLoader *DataLoader) loadTransactions(errChan chan<- error, transactionsChan chan<- []Transaction, waitGroup *sync.WaitGroup) {
-	defer waitGroup.Done()
-	fmt.Println("Loading transactions...")
-
-	rawTransactions, err := dataLoader.fetchTransactionsFromAWS()
-	if err != nil {
-		errChan <- err
-		return
+func (dataLoader *DataLoader) isKnownProduct(productId string) (bool, error) {
+	known, checked := dataLoader.knownProductIds[productId]
+	if checked {
+		return known, nil
 	}
 
-	var transactions []Transaction = dataLoader.parseTransactions(rawTransactions)
-	jsonTransactions, err := json.Marshal(transactions)
-
+	products, err := dataLoader.store.Products().FindProductsByIds([]string{productId})
 	if err != nil {
-		fmt.Printf("Error while marshalling transactions for database persistence | %v\n", err)
-		errChan <- err
-		return
+		return false, err
 	}
 
-	err = dataLoader.persistTransactions(jsonTransactions)
+	dataLoader.knownProductIds[productId] = len(products) > 0
+	return dataLoader.knownProductIds[productId], nil
+}
 
-	if err != nil {
-		errChan <- fmt.Errorf("failed to persist transactions | %w", err)
-		return
+func withoutQuarantineIds(issues []ValidationIssue) []ValidationIssue {
+	var cleared []ValidationIssue
+	for _, issue := range issues {
+		issue.QuarantineId = ""
+		cleared = append(cleared, issue)
 	}
 
-	transactionsChan <- transactions
-	close(transactionsChan)
+	return cleared
 }
 
-func (dataLoader *DataLoader) fetchTransactionsFromAWS() ([]string, error) {
-	req, err := http.NewRequest("GET", c.TransactionsURL, nil)
+/*
+	Replaces the quarantined records of the loader's date with the
+	ones rejected by this load. Called once the load is committed, so
+	that a failed load leaves the quarantine untouched.
+*/
+func (dataLoader *DataLoader) quarantineRejected() error {
+	if dataLoader.quarantine == nil {
+		return nil
+	}
 
+	_, err := dataLoader.quarantine.DeleteRecordsOfDate(dataLoader.dateStr)
 	if err != nil {
-		fmt.Printf("Error in GET request '%s' | %v \n", c.TransactionsURL, err)
-		return nil, err
+		return err
 	}
 
-	q := req.URL.Query()
-	timestamp, err := f.DateStringToTimestamp(dataLoader.dateStr)
+	createdAt := time.Now().UTC()
+	records := dataLoader.validation.quarantined
+	for i := range records {
+		records[i].CreatedAt = createdAt
+	}
+
+	return dataLoader.quarantine.SaveRecords(records)
+}
+
+/*
+	Determines if the database has information about the
+	requested node based on the date queried by the client.
+	In that case, a request to AWS is not necessary.
+*/
+func (dataLoader *DataLoader) isDateRequestable() (bool, error) {
+	synchronized, err := dataLoader.store.Transactions().IsDateSynchronized(dataLoader.txn, dataLoader.dateStr)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	var dateAsTimestamp string = fmt.Sprint(timestamp)
-	q.Add("date", dateAsTimestamp)
+	return !synchronized, nil
+}
 
-	req.URL.RawQuery = q.Encode()
-	query := req.URL.String()
+/*
+	Deletes, within the loader's txn, the transactions of the loader's
+	date along with the buyers and products that only they referenced.
+*/
+func (dataLoader *DataLoader) purgeDate() (*PurgeResponse, error) {
+	purged := &PurgeResponse{Date: dataLoader.dateStr}
 
-	resp, err := http.Get(query)
+	var err error
+	purged.Transactions, err = dataLoader.store.Transactions().DeleteTransactionsOfDate(dataLoader.txn, dataLoader.dateStr)
 	if err != nil {
-		fmt.Printf("Error in response for GET request '%s' | %v\n", query, err)
 		return nil, err
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	purged.Buyers, err = dataLoader.store.Buyers().DeleteOrphanBuyers(dataLoader.txn, dataLoader.dateStr)
 	if err != nil {
-		fmt.Printf("Error while reading response body of GET request '%s' | %v\n", query, err)
 		return nil, err
 	}
-	defer func() {
-		err = resp.Body.Close()
-	}()
+
+	purged.Products, err = dataLoader.store.Products().DeleteOrphanProducts(dataLoader.txn, dataLoader.dateStr)
 	if err != nil {
 		return nil, err
 	}
 
-	//Replace null characters with '||'
-	bodyWithBars := strings.ReplaceAll(string(body), "\x00", "|")
-	rawTransactions := strings.Split(bodyWithBars, "||")
-	return rawTransactions, nil
+	dataLoader.report(PurgeStage, PurgedStep, purged.Transactions)
+	return purged, nil
 }
 
-func (dataLoader *DataLoader) parseTransactions(rawTransactions []string) []Transaction {
-	var transactions []Transaction
-	transactionsQty := len(rawTransactions)
-
-	for i := 0; i < transactionsQty; i++ {
-		transactionString := rawTransactions[i]
-		size := len(transactionString)
-		transactionStringArr := strings.Split(transactionString, "|")
-
-		if size == 0 {
-			continue
-		}
-
-		transactionId := string(transactionStringArr[0])[1:]
-		buyerId := transactionStringArr[1]
-		ip := transactionStringArr[2]
-		device := transactionStringArr[3]
-		products := string(transactionStringArr[4])[1 : len(transactionStringArr[4])-1]
-
-		newTransaction := Transaction{
-			BuyerId:       buyerId,
-			TransactionId: transactionId,
-			Ip:            ip,
-			Device:        device,
-			Products:      strings.Split(products, ","),
-			Date:          dataLoader.dateStr,
-			Type:          c.TransactionType,
-		}
-
-		transactions = append(transactions, newTransaction)
+/*
+	Notifies the loader's progress listener, if any, that @stage
+	finished @step handling @count records.
+*/
+func (dataLoader *DataLoader) report(stage string, step string, count int) {
+	if dataLoader.progress == nil {
+		return
 	}
 
-	return transactions
+	dataLoader.progress(ProgressEvent{
+		Date:  dataLoader.dateStr,
+		Stage: stage,
+		Step:  step,
+		Count: count,
+		Time:  time.Now().UTC(),
+	})
 }
 
-func (dataLoader *DataLoader) persistTransactions(jsonTransactions []byte) error {
-	defer f.TimeTrack(time.Now(), "persistTransactions")
-	mutation := &api.Mutation{
-		SetJson: jsonTransactions,
-	}
-
-	_, err := dataLoader.txn.Mutate(context.Background(), mutation)
-
-	if err != nil {
-		fmt.Printf("Error while persisting transactions: %v\n", err)
-		return err
+/*
+	Reports that @stage failed with @err.
+*/
+func (dataLoader *DataLoader) fail(stage string, err error) {
+	if dataLoader.progress == nil {
+		return
 	}
 
-	return nil
+	dataLoader.progress(ProgressEvent{
+		Date:  dataLoader.dateStr,
+		Stage: stage,
+		Step:  FailedStep,
+		Error: err.Error(),
+		Time:  time.Now().UTC(),
+	})
 }
 
 /*
-	Determines if the database has information about the
-	requested node based on the date queried by the client.
-	In that case, a request to AWS is not necessary.
+	Feed that stops being readable once @ctx is canceled, so that
+	the stages of a failed load stop reading their feeds. Errors
+	reading the feed are upstream errors.
 */
-func (dataLoader *DataLoader) isDateRequestable() (bool, error) {
-	//Parse the date to the format the database uses for dates: RFC3339
-	t, err := time.Parse(c.DateLayout, dataLoader.dateStr)
+type cancelableFeed struct {
+	ctx  context.Context
+	feed io.ReadCloser
+}
+
+func (feed *cancelableFeed) Read(buffer []byte) (int, error) {
+	err := feed.ctx.Err()
 	if err != nil {
-		fmt.Printf("Error while parsing string '%s' to date | %v\n", dataLoader.dateStr, err)
-		return false, err
+		return 0, err
 	}
 
-	date := t.Format(c.DateLayoutRFC3339)
-
-	query := fmt.Sprintf(`{
-		q(func: eq(Date, "%s")){
-				  uid
-			  }
-	  }`, date)
-
-	res, err := dataLoader.txn.Query(ctx, query)
-	if err != nil {
-		return false, err
+	n, err := feed.feed.Read(buffer)
+	if err != nil && err != io.EOF {
+		return n, newUpstreamError("error while reading the feeds", err)
 	}
 
-	resultSize := res.Metrics.NumUids["uid"]
+	return n, err
+}
 
-	if resultSize > 0 {
-		return false, nil
-	} else {
-		return true, nil
-	}
+func (feed *cancelableFeed) Close() error {
+	return feed.feed.Close()
 }
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testDate string = "2020-08-17"
+
+const (
+	testBuyersFeed       string = `[{"id":"b1","name":"Ann","age":30},{"id":"b2","name":"Bob","age":41}]`
+	testProductsFeed     string = "p1'Rice'10\np2'\"Baker's bread\"'3.5\n"
+	testTransactionsFeed string = "#t1\x00b1\x001.1.1.1\x00mac\x00(p1,p2)\x00\x00" +
+		"#t2\x00b2\x001.1.1.1\x00linux\x00(p2)\x00\x00"
+)
+
+/*
+	Data source serving fixed feeds, any of which can be made to fail
+	when it's fetched or partway through reading it.
+*/
+type fakeDataSource struct {
+	feeds      map[string]string
+	fetchFails map[string]bool
+	readFails  map[string]bool
+}
+
+/*
+	Feed that returns an error after the first byte.
+*/
+type failingFeed struct {
+	read bool
+}
+
+func newFakeDataSource() *fakeDataSource {
+	return &fakeDataSource{
+		feeds: map[string]string{
+			BuyersStage:       testBuyersFeed,
+			ProductsStage:     testProductsFeed,
+			TransactionsStage: testTransactionsFeed,
+		},
+		fetchFails: map[string]bool{},
+		readFails:  map[string]bool{},
+	}
+}
+
+func (source *fakeDataSource) FetchBuyers(date string) (io.ReadCloser, error) {
+	return source.open(BuyersStage)
+}
+
+func (source *fakeDataSource) FetchProducts(date string) (io.ReadCloser, error) {
+	return source.open(ProductsStage)
+}
+
+func (source *fakeDataSource) FetchTransactions(date string) (io.ReadCloser, error) {
+	return source.open(TransactionsStage)
+}
+
+func (source *fakeDataSource) open(feed string) (io.ReadCloser, error) {
+	if source.fetchFails[feed] {
+		return nil, errors.New("upstream unavailable")
+	}
+	if source.readFails[feed] {
+		return &failingFeed{}, nil
+	}
+
+	return io.NopCloser(strings.NewReader(source.feeds[feed])), nil
+}
+
+func (feed *failingFeed) Read(buffer []byte) (int, error) {
+	if !feed.read && len(buffer) > 0 {
+		feed.read = true
+		buffer[0] = '['
+		return 1, nil
+	}
+
+	return 0, errors.New("connection reset")
+}
+
+func (feed *failingFeed) Close() error {
+	return nil
+}
+
+/*
+	Loads testDate from @source into @store, returning the progress
+	events reported along the way.
+*/
+func loadTestDate(store Store, source DataSource) (*LoadResponse, []ProgressEvent, error) {
+	return loadTestDateQuarantining(store, source, nil)
+}
+
+/*
+	Loads testDate as loadTestDate does, keeping the rejected records
+	in @quarantine.
+*/
+func loadTestDateQuarantining(store Store, source DataSource, quarantine QuarantineRepository) (*LoadResponse, []ProgressEvent, error) {
+	var mutex sync.Mutex
+	var events []ProgressEvent
+
+	txn, err := store.NewTxn()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataLoader := &DataLoader{
+		dateStr:    testDate,
+		store:      store,
+		source:     source,
+		txn:        txn,
+		quarantine: quarantine,
+		progress: func(event ProgressEvent) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			events = append(events, event)
+		},
+	}
+
+	loaded, err := dataLoader.loadRestaurantData()
+	return loaded, events, err
+}
+
+func isTestDateSynchronized(t *testing.T, store Store) bool {
+	t.Helper()
+
+	txn, err := store.NewTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Discard()
+
+	synchronized, err := store.Transactions().IsDateSynchronized(txn, testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return synchronized
+}
+
+func TestLoadRestaurantData(t *testing.T) {
+	store := newMemoryStore()
+
+	loaded, _, err := loadTestDate(store, newFakeDataSource())
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	if len(loaded.Buyers) != 2 || len(loaded.Products) != 2 || loaded.TransactionsQty != 2 {
+		t.Errorf("loaded %d buyers, %d products and %d transactions, want 2 of each",
+			len(loaded.Buyers), len(loaded.Products), loaded.TransactionsQty)
+	}
+
+	if !isTestDateSynchronized(t, store) {
+		t.Errorf("date not synchronized after the load")
+	}
+}
+
+/*
+	A failure in any stage has to fail the whole load, report the
+	stage that failed and leave nothing in the store, whatever the
+	other stages got to do.
+*/
+func TestLoadRestaurantDataStageFailures(t *testing.T) {
+	stages := []string{ProductsStage, BuyersStage, TransactionsStage}
+
+	for _, stage := range stages {
+		for _, failure := range []string{"fetch", "read"} {
+			t.Run(stage+"/"+failure, func(t *testing.T) {
+				source := newFakeDataSource()
+				if failure == "fetch" {
+					source.fetchFails[stage] = true
+				} else {
+					source.readFails[stage] = true
+				}
+
+				store := newMemoryStore()
+				loaded, events, err := loadTestDate(store, source)
+				if err == nil {
+					t.Fatalf("load succeeded with a failing %s feed: %+v", stage, loaded)
+				}
+				if errorKind(err) != UpstreamError {
+					t.Errorf("got error %v, want an upstream error", err)
+				}
+
+				assertStageFailed(t, events, stage)
+				assertStoreEmpty(t, store)
+			})
+		}
+	}
+}
+
+func assertStageFailed(t *testing.T, events []ProgressEvent, stage string) {
+	t.Helper()
+
+	failed := map[string]bool{}
+	for _, event := range events {
+		if event.Step == FailedStep {
+			failed[event.Stage] = true
+		}
+		if event.Step == PersistedStep && event.Stage == TransactionsStage {
+			t.Errorf("transactions persisted after the %s stage failed", stage)
+		}
+	}
+
+	if !failed[stage] || len(failed) != 1 {
+		t.Errorf("got failed stages %v, want only %s", failed, stage)
+	}
+}
+
+func assertStoreEmpty(t *testing.T, store Store) {
+	t.Helper()
+
+	buyers, err := store.Buyers().FindBuyers(PageRequest{First: 10})
+	if err != nil || buyers.TotalCount != 0 {
+		t.Errorf("got %d buyers after a failed load (%v), want none", buyers.TotalCount, err)
+	}
+
+	products, err := store.Products().FindProductsByIds([]string{"p1", "p2"})
+	if err != nil || len(products) != 0 {
+		t.Errorf("got %d products after a failed load (%v), want none", len(products), err)
+	}
+
+	if isTestDateSynchronized(t, store) {
+		t.Errorf("date synchronized after a failed load")
+	}
+}
+
+/*
+	Returns a record of the transactions feed with @fields.
+*/
+func transactionRecord(fields ...string) string {
+	return strings.Join(fields, "\x00") + "\x00\x00"
+}
+
+/*
+	Every kind of invalid transaction is left out of the load and
+	quarantined with its issue, and the valid ones are saved.
+*/
+func TestLoadQuarantinesInvalidTransactions(t *testing.T) {
+	source := newFakeDataSource()
+	source.feeds[TransactionsStage] = transactionRecord("#t1", "b1", "1.1.1.1", "mac", "(p1,p2)") +
+		transactionRecord("#t1", "b2", "1.1.1.1", "mac", "(p2)") +
+		transactionRecord("#t3", "b9", "1.1.1.1", "mac", "(p1)") +
+		transactionRecord("#t4", "b1", "1.1.1.1", "mac", "(p9)") +
+		transactionRecord("#t5", "b1", "999.1", "mac", "(p1)") +
+		transactionRecord("#t6", "b2", "2.2.2.2", "toaster", "(p2)") +
+		transactionRecord("t7", "b1", "1.1.1.1", "mac", "(p1)")
+
+	quarantine, err := newFileQuarantineRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newMemoryStore()
+	loaded, _, err := loadTestDateQuarantining(store, source, quarantine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.TransactionsQty != 1 {
+		t.Errorf("saved %d transactions, want only t1", loaded.TransactionsQty)
+	}
+
+	wantCounts := map[string]int{
+		DuplicateTransactionIssue: 1,
+		UnknownBuyerIssue:         1,
+		UnknownProductIssue:       1,
+		MalformedIpIssue:          1,
+		UnknownDeviceIssue:        1,
+		MalformedRecordIssue:      1,
+	}
+	if !reflect.DeepEqual(loaded.Validation.IssueCounts, wantCounts) {
+		t.Errorf("got issue counts %v, want %v", loaded.Validation.IssueCounts, wantCounts)
+	}
+	if loaded.Validation.Quarantined != 6 {
+		t.Errorf("quarantined %d records, want 6", loaded.Validation.Quarantined)
+	}
+
+	records, err := quarantine.FindRecords(testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 6 {
+		t.Fatalf("got %d quarantined records, want 6", len(records))
+	}
+
+	quarantined := map[string]bool{}
+	for _, record := range records {
+		if record.Feed != TransactionsFeed || len(record.Issues) == 0 {
+			t.Errorf("got quarantined record %+v, want a transaction with its issues", record)
+		}
+		quarantined[record.Record] = true
+	}
+	duplicate := "#t1\x00b2\x001.1.1.1\x00mac\x00(p2)"
+	if !quarantined[duplicate] {
+		t.Errorf("the duplicate of t1 isn't quarantined as it came in the feed")
+	}
+
+	for _, issue := range loaded.Validation.Issues {
+		if issue.QuarantineId == "" || issue.Position == 0 {
+			t.Errorf("got issue %+v, want its position and quarantined record", issue)
+		}
+	}
+
+	history, err := store.Transactions().FindTransactionHistoryPage("b1", PageRequest{First: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Transactions) != 1 || history.Transactions[0].TransactionId != "t1" {
+		t.Errorf("got transactions %+v of b1, want only t1", history.Transactions)
+	}
+}
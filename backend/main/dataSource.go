@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	c "module/constants"
+	f "module/utils"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+	Upstream the DataLoader reads the raw buyers, products and
+	transactions feeds of a date from. Each feed is returned as-is,
+	in the format the upstream endpoints publish it, and must be
+	closed by the caller.
+*/
+type DataSource interface {
+	FetchBuyers(date string) (io.ReadCloser, error)
+	FetchProducts(date string) (io.ReadCloser, error)
+	FetchTransactions(date string) (io.ReadCloser, error)
+}
+
+/*
+	Fetches the feeds from the upstream HTTP endpoints.
+*/
+type httpDataSource struct {
+	upstream        *upstreamClient
+	buyersURL       string
+	productsURL     string
+	transactionsURL string
+}
+
+/*
+	Reads the feeds from a directory holding one subdirectory per
+	date, e.g. <dir>/2020-08-17/{buyers,products,transactions}.
+*/
+type directoryDataSource struct {
+	dir string
+}
+
+/*
+	Returns the upstream endpoints data source. When @baseURL is set,
+	the feeds are fetched from <baseURL>/buyers, <baseURL>/products
+	and <baseURL>/transactions instead of the default endpoints.
+	Every fetch goes through @upstream.
+*/
+func newHttpDataSource(baseURL string, upstream *upstreamClient) *httpDataSource {
+	if baseURL == "" {
+		return &httpDataSource{
+			upstream:        upstream,
+			buyersURL:       c.BuyersURL,
+			productsURL:     c.ProductURL,
+			transactionsURL: c.TransactionsURL,
+		}
+	}
+
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	return &httpDataSource{
+		upstream:        upstream,
+		buyersURL:       baseURL + "/buyers",
+		productsURL:     baseURL + "/products",
+		transactionsURL: baseURL + "/transactions",
+	}
+}
+
+func (source *httpDataSource) FetchBuyers(date string) (io.ReadCloser, error) {
+	return source.fetch(source.buyersURL, date)
+}
+
+func (source *httpDataSource) FetchProducts(date string) (io.ReadCloser, error) {
+	return source.fetch(source.productsURL, date)
+}
+
+func (source *httpDataSource) FetchTransactions(date string) (io.ReadCloser, error) {
+	return source.fetch(source.transactionsURL, date)
+}
+
+func (source *httpDataSource) fetch(url string, date string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		fmt.Printf("Error while forming GET request '%s' | %v\n", url, err)
+		return nil, err
+	}
+
+	q := req.URL.Query()
+	timestamp, err := f.DateStringToTimestamp(date)
+	if err != nil {
+		return nil, err
+	}
+
+	var dateAsTimestamp string = fmt.Sprint(timestamp)
+	q.Add("date", dateAsTimestamp)
+
+	req.URL.RawQuery = q.Encode()
+	requestUrl := req.URL.String()
+
+	body, err := source.upstream.get(requestUrl)
+	if err != nil {
+		fmt.Printf("Error in response for GET request '%s' | %v\n", requestUrl, err)
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func newDirectoryDataSource(dir string) *directoryDataSource {
+	return &directoryDataSource{dir: dir}
+}
+
+func (source *directoryDataSource) FetchBuyers(date string) (io.ReadCloser, error) {
+	return source.open(date, "buyers")
+}
+
+func (source *directoryDataSource) FetchProducts(date string) (io.ReadCloser, error) {
+	return source.open(date, "products")
+}
+
+func (source *directoryDataSource) FetchTransactions(date string) (io.ReadCloser, error) {
+	return source.open(date, "transactions")
+}
+
+func (source *directoryDataSource) open(date string, feed string) (io.ReadCloser, error) {
+	err := isDateParamValid(date)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filepath.Join(source.dir, date, feed))
+	if err != nil {
+		return nil, fmt.Errorf("error while opening %s feed for '%s' | %w", feed, date, err)
+	}
+
+	return file, nil
+}
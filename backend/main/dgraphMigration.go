@@ -0,0 +1,377 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	c "module/constants"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/dgo/v2/protos/api"
+)
+
+//go:embed migrations/dgraph/*.dql
+var dgraphMigrations embed.FS
+
+/*
+	Schema of the nodes that record which migrations were applied.
+	Migrate alters it before looking for pending migrations, so it
+	doesn't belong to any of them.
+*/
+const migrationSchema string = `
+type SchemaMigration {
+  MigrationVersion
+  MigrationName
+  MigrationAppliedAt
+}
+
+MigrationVersion: int @index(int) .
+MigrationName: string .
+MigrationAppliedAt: datetime .
+`
+
+/*
+	Backfills run right after the schema of the migration with the
+	same version is altered. They must be safe to run again, since a
+	failure leaves the migration pending.
+*/
+var dgraphBackfills map[int]func(store *dgraphStore) error = map[int]func(store *dgraphStore) error{
+	2: (*dgraphStore).backfillTransactionEdges,
+}
+
+/*
+	Returns the migrations in migrations/dgraph, with the time the
+	ones recorded in the database were applied. The version of a
+	migration is the number its file name starts with. It doesn't
+	alter the database, so none is applied while the schema of the
+	migrations is missing.
+*/
+func (store *dgraphStore) Migrations() ([]MigrationStatus, error) {
+	appliedAt, err := store.findAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	fileNames, err := fs.Glob(dgraphMigrations, "migrations/dgraph/*.dql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(fileNames)
+
+	var statuses []MigrationStatus
+	for _, fileName := range fileNames {
+		baseName := strings.TrimSuffix(fileName[strings.LastIndex(fileName, "/")+1:], ".dql")
+		versionName := strings.SplitN(baseName, "_", 2)
+
+		version, err := strconv.Atoi(versionName[0])
+		if err != nil || len(versionName) != 2 {
+			return nil, fmt.Errorf("invalid migration file name '%s'", fileName)
+		}
+
+		status := MigrationStatus{Version: version, Name: versionName[1], file: fileName}
+		if applied, found := appliedAt[version]; found {
+			status.AppliedAt = &applied
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+/*
+	Applies, in order, every migration that isn't recorded in the
+	database yet: its schema is altered, its backfill run, and then
+	it's recorded.
+*/
+func (store *dgraphStore) Migrate() error {
+	err := store.client.Alter(ctx, &api.Operation{Schema: migrationSchema})
+	if err != nil {
+		return fmt.Errorf("error while altering the schema of the migrations | %w", err)
+	}
+
+	statuses, err := store.Migrations()
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			continue
+		}
+
+		schema, err := dgraphMigrations.ReadFile(status.file)
+		if err != nil {
+			return err
+		}
+
+		err = store.client.Alter(ctx, &api.Operation{Schema: string(schema)})
+		if err != nil {
+			return fmt.Errorf("error while altering the schema of migration '%s' | %w", status.file, err)
+		}
+
+		backfill, found := dgraphBackfills[status.Version]
+		if found {
+			err = backfill(store)
+			if err != nil {
+				return fmt.Errorf("error while backfilling migration '%s' | %w", status.file, err)
+			}
+		}
+
+		err = store.recordMigration(status)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Applied migration %s\n", status.file)
+	}
+
+	return nil
+}
+
+func (store *dgraphStore) findAppliedMigrations() (map[int]time.Time, error) {
+	txn := store.client.NewReadOnlyTxn()
+	defer txn.Discard(ctx)
+
+	res, err := txn.Query(ctx, `schema(type: SchemaMigration) {}`)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching the schema of the migrations | %w", err)
+	}
+
+	var schema struct {
+		Types []struct {
+			Name string
+		}
+	}
+	err = json.Unmarshal(res.Json, &schema)
+	if err != nil {
+		return nil, err
+	}
+
+	// Migrate hasn't run on this database yet
+	if len(schema.Types) == 0 {
+		return map[int]time.Time{}, nil
+	}
+
+	res, err = txn.Query(ctx, `{
+		migrations(func: type(SchemaMigration)) {
+			MigrationVersion
+			MigrationAppliedAt
+		}
+	}`)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching the applied migrations | %w", err)
+	}
+
+	var applied struct {
+		Migrations []struct {
+			MigrationVersion   int
+			MigrationAppliedAt time.Time
+		}
+	}
+	err = json.Unmarshal(res.Json, &applied)
+	if err != nil {
+		return nil, err
+	}
+
+	appliedAt := map[int]time.Time{}
+	for _, migration := range applied.Migrations {
+		appliedAt[migration.MigrationVersion] = migration.MigrationAppliedAt
+	}
+
+	return appliedAt, nil
+}
+
+func (store *dgraphStore) recordMigration(migration MigrationStatus) error {
+	jsonMigration, err := json.Marshal(map[string]interface{}{
+		"MigrationVersion":   migration.Version,
+		"MigrationName":      migration.Name,
+		"MigrationAppliedAt": time.Now().UTC(),
+		"dgraph.type":        "SchemaMigration",
+	})
+	if err != nil {
+		return err
+	}
+
+	txn := store.client.NewTxn()
+	defer txn.Discard(ctx)
+
+	_, err = txn.Mutate(ctx, &api.Mutation{SetJson: jsonMigration, CommitNow: true})
+	if err != nil {
+		return fmt.Errorf("error while recording migration '%s' | %w", migration.file, err)
+	}
+
+	return nil
+}
+
+/*
+	Links the transactions saved before migration 2 to their buyer
+	and products.
+*/
+func (store *dgraphStore) backfillTransactionEdges() error {
+	linked, unlinked, err := store.linkTransactions()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d transactions linked to their buyers and products, %d couldn't be linked\n", linked, unlinked)
+	return nil
+}
+
+/*
+	Transaction saved before the BoughtBy and Items edges existed,
+	which references its buyer and products by id.
+*/
+type unlinkedTransaction struct {
+	Uid      string `json:"uid"`
+	BuyerId  string
+	Products []string
+}
+
+type transactionEdges struct {
+	Uid      string `json:"uid"`
+	BoughtBy *dgraphNode
+	Items    []dgraphNode
+}
+
+/*
+	Replaces the BuyerId and Products ids of the transactions saved
+	before the BoughtBy and Items edges existed with edges to their
+	buyer and products. Transactions are migrated in batches of
+	c.TransactionsBatchSize, each in its own transaction, so running
+	it again picks up where it stopped. Transactions whose buyer or
+	products aren't in the database are left as they are and counted
+	in the second return value.
+*/
+func (store *dgraphStore) linkTransactions() (int, int, error) {
+	linked := 0
+	unlinked := 0
+	after := "0x0"
+
+	for {
+		batch, err := store.findUnlinkedTransactions(after)
+		if err != nil {
+			return linked, unlinked, err
+		}
+
+		if len(batch) == 0 {
+			return linked, unlinked, nil
+		}
+		after = batch[len(batch)-1].Uid
+
+		batchLinked, err := store.linkTransactionBatch(batch)
+		if err != nil {
+			return linked, unlinked, err
+		}
+
+		linked += batchLinked
+		unlinked += len(batch) - batchLinked
+	}
+}
+
+/*
+	Returns the next batch of transactions with a BuyerId or Products
+	ids, starting after the uid @after.
+*/
+func (store *dgraphStore) findUnlinkedTransactions(after string) ([]unlinkedTransaction, error) {
+	txn := store.client.NewReadOnlyTxn()
+	defer txn.Discard(ctx)
+
+	res, err := newDqlQuery().
+		withInt("first", c.TransactionsBatchSize).
+		withString("after", after).
+		run(txn, `{
+		transactions(func: type(Transaction), first: $first, after: $after)
+			@filter(has(BuyerId) or has(Products)) {
+			uid
+			BuyerId
+			Products
+		}
+	}`)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching transactions without edges | %w", err)
+	}
+
+	var unlinked struct {
+		Transactions []unlinkedTransaction
+	}
+	err = json.Unmarshal(res.Json, &unlinked)
+	if err != nil {
+		return nil, err
+	}
+
+	return unlinked.Transactions, nil
+}
+
+/*
+	Adds the edges of the transactions of @batch and deletes their
+	ids, returning how many of them were linked.
+*/
+func (store *dgraphStore) linkTransactionBatch(batch []unlinkedTransaction) (int, error) {
+	txn := store.client.NewTxn()
+	defer txn.Discard(ctx)
+
+	var buyerIds, productIds []string
+	for _, transaction := range batch {
+		buyerIds = append(buyerIds, transaction.BuyerId)
+		productIds = append(productIds, transaction.Products...)
+	}
+
+	buyerUids, productUids, err := findNodeUids(txn, buyerIds, productIds)
+	if err != nil {
+		return 0, err
+	}
+
+	var edges []transactionEdges
+	var ids []map[string]interface{}
+	for _, transaction := range batch {
+		linked, err := toDgraphTransaction(Transaction{
+			TransactionId: transaction.Uid,
+			BuyerId:       transaction.BuyerId,
+			Products:      transaction.Products,
+		}, buyerUids, productUids)
+		if err != nil {
+			fmt.Printf("Transaction %s can't be linked | %v\n", transaction.Uid, err)
+			continue
+		}
+
+		edges = append(edges, transactionEdges{
+			Uid:      transaction.Uid,
+			BoughtBy: linked.BoughtBy,
+			Items:    linked.Items,
+		})
+		ids = append(ids, map[string]interface{}{
+			"uid":      transaction.Uid,
+			"BuyerId":  nil,
+			"Products": nil,
+		})
+	}
+
+	if len(edges) == 0 {
+		return 0, nil
+	}
+
+	jsonEdges, err := json.Marshal(edges)
+	if err != nil {
+		return 0, err
+	}
+
+	jsonIds, err := json.Marshal(ids)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = txn.Do(ctx, &api.Request{
+		Mutations: []*api.Mutation{{SetJson: jsonEdges, DeleteJson: jsonIds}},
+		CommitNow: true,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error while linking transactions | %w", err)
+	}
+
+	return len(edges), nil
+}
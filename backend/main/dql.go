@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/dgo/v2"
+	"github.com/dgraph-io/dgo/v2/protos/api"
+)
+
+/*
+	Builds DQL queries whose values are sent as query variables
+	instead of being interpolated into the query text, so that a
+	malformed or hostile id can never change what a query does.
+	A variable declared with withString("buyerId", ...) is referenced
+	in the query body as $buyerId.
+*/
+type dqlQuery struct {
+	types     map[string]string
+	variables map[string]string
+}
+
+func newDqlQuery() *dqlQuery {
+	return &dqlQuery{
+		types:     map[string]string{},
+		variables: map[string]string{},
+	}
+}
+
+func (query *dqlQuery) with(name string, varType string, value string) *dqlQuery {
+	query.types["$"+name] = varType
+	query.variables["$"+name] = value
+	return query
+}
+
+func (query *dqlQuery) withString(name string, value string) *dqlQuery {
+	return query.with(name, "string", value)
+}
+
+func (query *dqlQuery) withInt(name string, value int) *dqlQuery {
+	return query.with(name, "int", strconv.Itoa(value))
+}
+
+/*
+	Declares a variable holding @values as a space separated list,
+	to be used with term functions such as anyofterms.
+*/
+func (query *dqlQuery) withTerms(name string, values []string) *dqlQuery {
+	return query.with(name, "string", strings.Join(values, " "))
+}
+
+/*
+	Returns @body prefixed with the declaration of the query variables.
+*/
+func (query *dqlQuery) build(body string) string {
+	if len(query.types) == 0 {
+		return body
+	}
+
+	var names []string
+	for name := range query.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var declarations []string
+	for _, name := range names {
+		declarations = append(declarations, fmt.Sprintf("%s: %s", name, query.types[name]))
+	}
+
+	return fmt.Sprintf("query q(%s) %s", strings.Join(declarations, ", "), strings.TrimSpace(body))
+}
+
+func (query *dqlQuery) run(txn *dgo.Txn, body string) (*api.Response, error) {
+	return txn.QueryWithVars(ctx, query.build(body), query.variables)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	ValidationError string = "validation"
+	NotFoundError   string = "not-found"
+	ConflictError   string = "conflict"
+	UpstreamError   string = "upstream"
+	StorageError    string = "storage"
+)
+
+/*
+	Machine readable codes of the error responses, so that clients
+	can tell errors apart without parsing their messages.
+*/
+const (
+	InvalidRequestCode   string = "invalid_request"
+	InvalidDateCode      string = "invalid_date"
+	InvalidPageCode      string = "invalid_page"
+	InvalidIdCode        string = "invalid_id"
+	DateSynchronizedCode string = "date_already_synchronized"
+	BuyerNotFoundCode    string = "buyer_not_found"
+	JobNotFoundCode      string = "job_not_found"
+	RecordNotFoundCode   string = "record_not_found"
+	UpstreamFailureCode  string = "upstream_failure"
+	StorageFailureCode   string = "storage_failure"
+	InternalErrorCode    string = "internal_error"
+)
+
+/*
+	Error of the service layer. Kind tells how it's answered: the
+	message of validation, not found and conflict errors is meant for
+	the client, while Err, the cause of upstream and storage errors,
+	is only logged.
+*/
+type ServiceError struct {
+	Kind    string
+	Code    string
+	Message string
+	Err     error
+}
+
+/*
+	Problem details (RFC 7807) of an error response.
+*/
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Code   string `json:"code"`
+}
+
+var (
+	errInvalidDate = newValidationError(InvalidDateCode, "invalid date")
+	errInvalidPage = newValidationError(InvalidPageCode, "invalid page parameters")
+)
+
+var errBuyerNotFound = newNotFoundError(BuyerNotFoundCode, "buyer not found")
+
+func (err *ServiceError) Error() string {
+	if err.Err == nil {
+		return err.Message
+	}
+
+	return fmt.Sprintf("%s | %v", err.Message, err.Err)
+}
+
+func (err *ServiceError) Unwrap() error {
+	return err.Err
+}
+
+func newValidationError(code string, message string) *ServiceError {
+	return &ServiceError{Kind: ValidationError, Code: code, Message: message}
+}
+
+func newNotFoundError(code string, message string) *ServiceError {
+	return &ServiceError{Kind: NotFoundError, Code: code, Message: message}
+}
+
+func newConflictError(code string, message string) *ServiceError {
+	return &ServiceError{Kind: ConflictError, Code: code, Message: message}
+}
+
+func newUpstreamError(message string, err error) *ServiceError {
+	return &ServiceError{Kind: UpstreamError, Code: UpstreamFailureCode, Message: message, Err: err}
+}
+
+func newDateSynchronizedError(date string) *ServiceError {
+	return newConflictError(DateSynchronizedCode, fmt.Sprintf("date already synchronized: '%s'", date))
+}
+
+/*
+	Returns @err as a storage error, unless it's a ServiceError
+	already, which keeps its kind.
+*/
+func newStorageError(message string, err error) error {
+	var serviceErr *ServiceError
+	if errors.As(err, &serviceErr) {
+		return err
+	}
+
+	return &ServiceError{Kind: StorageError, Code: StorageFailureCode, Message: message, Err: err}
+}
+
+/*
+	Returns the kind of @err, or "" if it isn't a ServiceError.
+*/
+func errorKind(err error) string {
+	var serviceErr *ServiceError
+	if errors.As(err, &serviceErr) {
+		return serviceErr.Kind
+	}
+
+	return ""
+}
+
+/*
+	Writes @err as a problem details response. Errors that aren't
+	ServiceErrors are answered as internal errors, and the causes of
+	the server side errors are logged instead of sent.
+*/
+func writeError(writter http.ResponseWriter, err error) {
+	problem := Problem{Type: "about:blank", Code: InternalErrorCode, Status: http.StatusInternalServerError}
+
+	var serviceErr *ServiceError
+	if errors.As(err, &serviceErr) {
+		problem.Code = serviceErr.Code
+		problem.Detail = serviceErr.Message
+
+		switch serviceErr.Kind {
+		case ValidationError:
+			problem.Status = http.StatusBadRequest
+		case NotFoundError:
+			problem.Status = http.StatusNotFound
+		case ConflictError:
+			problem.Status = http.StatusConflict
+		case UpstreamError:
+			problem.Status = http.StatusBadGateway
+		}
+	}
+
+	if problem.Status >= http.StatusInternalServerError {
+		fmt.Printf("error while processing request | %v\n", err)
+	}
+
+	problem.Title = http.StatusText(problem.Status)
+
+	jsonProblem, err := json.Marshal(problem)
+	if err != nil {
+		http.Error(writter, problem.Title, problem.Status)
+		return
+	}
+
+	writter.Header().Set("Content-Type", "application/problem+json")
+	writter.WriteHeader(problem.Status)
+	writter.Write(jsonProblem)
+}
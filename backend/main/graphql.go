@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	c "module/constants"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+)
+
+//go:embed schema.graphql
+var graphqlSchema string
+
+const queryCostKey key = "queryCost"
+
+/*
+	Resolves the fields of the Query type of schema.graphql.
+*/
+type graphqlResolver struct {
+	service *RestaurantService
+}
+
+/*
+	Resolves a Buyer. The IPs and products of its transactions are
+	fetched once, the first time a field needs them, since both the
+	buyers with the same IP and the recommendations are derived from
+	them.
+*/
+type buyerResolver struct {
+	service      *RestaurantService
+	buyer        Buyer
+	activityOnce sync.Once
+	activity     BuyerActivity
+	activityErr  error
+}
+
+type buyerPageResolver struct {
+	service *RestaurantService
+	buyers  BuyerCollection
+}
+
+/*
+	Resolves a page of transactions. The products of all of them are
+	fetched at once, the first time a transaction needs its products.
+*/
+type transactionPageResolver struct {
+	service      *RestaurantService
+	transactions TransactionCollection
+	productsOnce sync.Once
+	products     map[string]Product
+	productsErr  error
+}
+
+type transactionResolver struct {
+	page        *transactionPageResolver
+	transaction Transaction
+}
+
+type productResolver struct {
+	product Product
+}
+
+type pageInfoResolver struct {
+	pageInfo PageInfo
+}
+
+/*
+	Body of the requests to /graphql, as the relay handler reads it.
+*/
+type GraphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+type pageArgs struct {
+	First int32
+	After *string
+}
+
+/*
+	Cost left to a query. Every field reading the store is charged
+	the number of items it can return, so nested connections can't
+	fan out to thousands of store calls within c.GraphQLMaxDepth.
+*/
+type queryCost struct {
+	mutex     sync.Mutex
+	remaining int
+}
+
+/*
+	Returns the handler executing the GraphQL queries POSTed to it.
+	Queries nested deeper than c.GraphQLMaxDepth are rejected, and
+	each query may cost up to c.GraphQLMaxCost.
+*/
+func newGraphqlHandler(service *RestaurantService) (http.Handler, error) {
+	schema, err := graphql.ParseSchema(graphqlSchema, &graphqlResolver{service: service},
+		graphql.MaxDepth(c.GraphQLMaxDepth))
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing the GraphQL schema | %w", err)
+	}
+
+	handler := &relay.Handler{Schema: schema}
+	return http.HandlerFunc(func(writter http.ResponseWriter, request *http.Request) {
+		ctx := context.WithValue(request.Context(), queryCostKey, &queryCost{remaining: c.GraphQLMaxCost})
+		handler.ServeHTTP(writter, request.WithContext(ctx))
+	}), nil
+}
+
+/*
+	Charges @cost to the query run with @ctx, failing without charging
+	it when the query can't afford it.
+*/
+func chargeQueryCost(ctx context.Context, cost int) error {
+	queryCost, ok := ctx.Value(queryCostKey).(*queryCost)
+	if !ok {
+		return nil
+	}
+
+	queryCost.mutex.Lock()
+	defer queryCost.mutex.Unlock()
+
+	if cost > queryCost.remaining {
+		return fmt.Errorf("the query exceeds the maximum cost of %d, request fewer or less nested items", c.GraphQLMaxCost)
+	}
+
+	queryCost.remaining -= cost
+	return nil
+}
+
+func (resolver *graphqlResolver) Buyer(ctx context.Context, args struct{ ID graphql.ID }) (*buyerResolver, error) {
+	buyerId := string(args.ID)
+	if !isBuyerIdParamValid(buyerId) {
+		return nil, fmt.Errorf("invalid buyer id '%s'", buyerId)
+	}
+
+	err := chargeQueryCost(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	buyer, err := resolver.service.findBuyer(buyerId)
+	if err != nil || buyer == nil {
+		return nil, err
+	}
+
+	return &buyerResolver{service: resolver.service, buyer: *buyer}, nil
+}
+
+func (resolver *graphqlResolver) Buyers(ctx context.Context, args pageArgs) (*buyerPageResolver, error) {
+	page, err := args.toPageRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	buyers, err := resolver.service.store.Buyers().FindBuyers(page)
+	if err != nil {
+		return nil, err
+	}
+
+	return &buyerPageResolver{service: resolver.service, buyers: buyers}, nil
+}
+
+func (resolver *graphqlResolver) Products(ctx context.Context, args struct{ Ids []graphql.ID }) ([]*productResolver, error) {
+	var productIds []string
+	for _, id := range args.Ids {
+		productIds = append(productIds, string(id))
+	}
+
+	if len(productIds) == 0 {
+		return []*productResolver{}, nil
+	}
+
+	err := chargeQueryCost(ctx, len(productIds))
+	if err != nil {
+		return nil, err
+	}
+
+	products, err := resolver.service.store.Products().FindProductsByIds(productIds)
+	if err != nil {
+		return nil, err
+	}
+
+	return toProductResolvers(products), nil
+}
+
+func (resolver *buyerResolver) ID() graphql.ID {
+	return graphql.ID(resolver.buyer.BuyerId)
+}
+
+func (resolver *buyerResolver) Name() string {
+	return resolver.buyer.Name
+}
+
+func (resolver *buyerResolver) Age() int32 {
+	return int32(resolver.buyer.Age)
+}
+
+func (resolver *buyerResolver) Date() *string {
+	return optionalString(resolver.buyer.Date)
+}
+
+func (resolver *buyerResolver) Transactions(ctx context.Context, args pageArgs) (*transactionPageResolver, error) {
+	page, err := args.toPageRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions, err := resolver.service.store.Transactions().FindTransactionHistoryPage(resolver.buyer.BuyerId, page)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transactionPageResolver{service: resolver.service, transactions: transactions}, nil
+}
+
+func (resolver *buyerResolver) BuyersWithSameIp(ctx context.Context, args pageArgs) (*buyerPageResolver, error) {
+	page, err := args.toPageRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	activity, err := resolver.findActivity()
+	if err != nil {
+		return nil, err
+	}
+
+	buyers, err := resolver.service.store.Buyers().FindBuyersByIps(activity.Ips, resolver.buyer.BuyerId, page)
+	if err != nil {
+		return nil, err
+	}
+
+	return &buyerPageResolver{service: resolver.service, buyers: buyers}, nil
+}
+
+func (resolver *buyerResolver) RecommendedProducts(ctx context.Context) ([]*productResolver, error) {
+	err := chargeQueryCost(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	activity, err := resolver.findActivity()
+	if err != nil {
+		return nil, err
+	}
+
+	products, err := resolver.service.fetchProductRecommendations(activity.ProductIds)
+	if err != nil {
+		return nil, err
+	}
+
+	return toProductResolvers(products), nil
+}
+
+func (resolver *buyerResolver) findActivity() (BuyerActivity, error) {
+	resolver.activityOnce.Do(func() {
+		resolver.activity, resolver.activityErr = resolver.service.store.Transactions().FindBuyerActivity(resolver.buyer.BuyerId)
+	})
+
+	return resolver.activity, resolver.activityErr
+}
+
+func (resolver *buyerPageResolver) Buyers() []*buyerResolver {
+	resolvers := []*buyerResolver{}
+	for _, buyer := range resolver.buyers.Buyers {
+		resolvers = append(resolvers, &buyerResolver{service: resolver.service, buyer: buyer})
+	}
+
+	return resolvers
+}
+
+func (resolver *buyerPageResolver) TotalCount() int32 {
+	return int32(resolver.buyers.TotalCount)
+}
+
+func (resolver *buyerPageResolver) PageInfo() *pageInfoResolver {
+	return &pageInfoResolver{pageInfo: resolver.buyers.PageInfo}
+}
+
+func (resolver *transactionPageResolver) Transactions() []*transactionResolver {
+	resolvers := []*transactionResolver{}
+	for _, transaction := range resolver.transactions.Transactions {
+		resolvers = append(resolvers, &transactionResolver{page: resolver, transaction: transaction})
+	}
+
+	return resolvers
+}
+
+func (resolver *transactionPageResolver) TotalCount() int32 {
+	return int32(resolver.transactions.TotalCount)
+}
+
+func (resolver *transactionPageResolver) PageInfo() *pageInfoResolver {
+	return &pageInfoResolver{pageInfo: resolver.transactions.PageInfo}
+}
+
+func (resolver *transactionPageResolver) findProducts() (map[string]Product, error) {
+	resolver.productsOnce.Do(func() {
+		var productIds []string
+		for _, transaction := range resolver.transactions.Transactions {
+			productIds = append(productIds, transaction.Products...)
+		}
+
+		resolver.products = map[string]Product{}
+		if len(productIds) == 0 {
+			return
+		}
+
+		var products []Product
+		products, resolver.productsErr = resolver.service.store.Products().FindProductsByIds(productIds)
+		for _, product := range products {
+			resolver.products[product.ProductId] = product
+		}
+	})
+
+	return resolver.products, resolver.productsErr
+}
+
+func (resolver *transactionResolver) ID() graphql.ID {
+	return graphql.ID(resolver.transaction.TransactionId)
+}
+
+func (resolver *transactionResolver) Ip() string {
+	return resolver.transaction.Ip
+}
+
+func (resolver *transactionResolver) Device() string {
+	return resolver.transaction.Device
+}
+
+func (resolver *transactionResolver) Date() string {
+	return resolver.transaction.Date
+}
+
+func (resolver *transactionResolver) Buyer(ctx context.Context) (*buyerResolver, error) {
+	err := chargeQueryCost(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	buyer, err := resolver.page.service.findBuyer(resolver.transaction.BuyerId)
+	if err != nil || buyer == nil {
+		return nil, err
+	}
+
+	return &buyerResolver{service: resolver.page.service, buyer: *buyer}, nil
+}
+
+/*
+	Returns the products of the transaction in its order, leaving out
+	the ones that aren't saved.
+*/
+func (resolver *transactionResolver) Products() ([]*productResolver, error) {
+	products, err := resolver.page.findProducts()
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := []*productResolver{}
+	for _, productId := range resolver.transaction.Products {
+		product, ok := products[productId]
+		if ok {
+			resolvers = append(resolvers, &productResolver{product: product})
+		}
+	}
+
+	return resolvers, nil
+}
+
+func (resolver *productResolver) ID() graphql.ID {
+	return graphql.ID(resolver.product.ProductId)
+}
+
+func (resolver *productResolver) Name() string {
+	return resolver.product.Name
+}
+
+func (resolver *productResolver) Price() string {
+	return resolver.product.Price.String()
+}
+
+func (resolver *productResolver) Date() *string {
+	return optionalString(resolver.product.Date)
+}
+
+func (resolver *pageInfoResolver) EndCursor() *string {
+	return optionalString(resolver.pageInfo.EndCursor)
+}
+
+func (resolver *pageInfoResolver) HasNextPage() bool {
+	return resolver.pageInfo.HasNextPage
+}
+
+func toProductResolvers(products []Product) []*productResolver {
+	resolvers := []*productResolver{}
+	for _, product := range products {
+		resolvers = append(resolvers, &productResolver{product: product})
+	}
+
+	return resolvers
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+
+	return &value
+}
+
+/*
+	Returns the page requested by @args, which are validated as the
+	'first' and 'after' parameters of the REST endpoints are, charging
+	its size to the query run with @ctx.
+*/
+func (args pageArgs) toPageRequest(ctx context.Context) (PageRequest, error) {
+	after := ""
+	if args.After != nil {
+		after = *args.After
+	}
+
+	page, err := newPageRequest(strconv.Itoa(int(args.First)), after)
+	if err != nil {
+		return page, err
+	}
+
+	return page, chargeQueryCost(ctx, page.First)
+}
+
+/*
+	Serves GraphiQL, to explore the schema and run queries from the
+	browser.
+*/
+func graphqlPlayground(writter http.ResponseWriter, request *http.Request) {
+	writter.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writter.Write([]byte(graphqlPlaygroundPage))
+}
+
+const graphqlPlaygroundPage string = `<!DOCTYPE html>
+<html>
+<head>
+	<title>GraphQL playground</title>
+	<link rel="stylesheet" href="https://unpkg.com/graphiql@1.4.2/graphiql.min.css" />
+</head>
+<body style="margin: 0;">
+	<div id="graphiql" style="height: 100vh;"></div>
+	<script crossorigin src="https://unpkg.com/react@17/umd/react.production.min.js"></script>
+	<script crossorigin src="https://unpkg.com/react-dom@17/umd/react-dom.production.min.js"></script>
+	<script crossorigin src="https://unpkg.com/graphiql@1.4.2/graphiql.min.js"></script>
+	<script>
+		const url = window.location.pathname.replace(/\/playground\/?$/, "");
+		const fetcher = (params) => fetch(url, {
+			method: "POST",
+			headers: { "Content-Type": "application/json" },
+			body: JSON.stringify(params),
+		}).then((response) => response.json());
+		ReactDOM.render(React.createElement(GraphiQL, { fetcher: fetcher }), document.getElementById("graphiql"));
+	</script>
+</body>
+</html>
+`
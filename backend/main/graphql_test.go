@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type graphqlTestResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+/*
+	POSTs @query to /graphql over a memory store holding the test
+	data, returning the response.
+*/
+func postTestQuery(t *testing.T, query string) graphqlTestResponse {
+	t.Helper()
+
+	store := newMemoryStore()
+	saveTestData(t, store)
+
+	body, err := json.Marshal(GraphqlRequest{Query: query})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	newTestRouter(t, store).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response graphqlTestResponse
+	err = json.Unmarshal(recorder.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("invalid GraphQL response %q | %v", recorder.Body.String(), err)
+	}
+
+	return response
+}
+
+func TestGraphqlBuyerQuery(t *testing.T) {
+	response := postTestQuery(t, `{
+		buyer(id: "b1") {
+			name
+			transactions(first: 20) { totalCount transactions { id products { id } } }
+			buyersWithSameIp(first: 20) { buyers { id } pageInfo { hasNextPage } }
+			recommendedProducts { id }
+		}
+	}`)
+	if len(response.Errors) != 0 {
+		t.Fatalf("got errors %+v", response.Errors)
+	}
+
+	var data struct {
+		Buyer struct {
+			Name         string
+			Transactions struct{ TotalCount int }
+		}
+	}
+	err := json.Unmarshal(response.Data, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Buyer.Name != "Buyer b1" || data.Buyer.Transactions.TotalCount != 2 {
+		t.Errorf("got %s", response.Data)
+	}
+}
+
+/*
+	Nested connections are charged their size, so a query fanning out
+	to thousands of resolvers fails instead of reaching the store.
+*/
+func TestGraphqlRejectsExpensiveQueries(t *testing.T) {
+	response := postTestQuery(t, `{
+		buyers(first: 100) { buyers {
+			buyersWithSameIp(first: 100) { buyers {
+				buyersWithSameIp(first: 100) { buyers {
+					transactions(first: 100) { totalCount }
+				} }
+			} }
+		} }
+	}`)
+
+	if len(response.Errors) == 0 || !strings.Contains(response.Errors[0].Message, "maximum cost") {
+		t.Errorf("got errors %+v, want the query to exceed the maximum cost", response.Errors)
+	}
+}
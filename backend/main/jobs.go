@@ -0,0 +1,367 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	c "module/constants"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JobQueued    string = "queued"
+	JobRunning   string = "running"
+	JobSucceeded string = "succeeded"
+	JobFailed    string = "failed"
+)
+
+var errJobNotFound = newNotFoundError(JobNotFoundCode, "job not found")
+
+/*
+	Asynchronous load of the restaurant data of a date, or of every
+	date between From and To. Validation holds the issues found in
+	the feeds of Date, whose records were quarantined.
+*/
+type Job struct {
+	Id         string
+	State      string
+	Date       string `json:",omitempty"`
+	From       string `json:",omitempty"`
+	To         string `json:",omitempty"`
+	Force      bool   `json:",omitempty"`
+	Counts     LoadCounts
+	Dates      []DateLoadSummary `json:",omitempty"`
+	Errors     []string          `json:",omitempty"`
+	Validation *ValidationReport `json:",omitempty"`
+	CreatedAt  time.Time
+	StartedAt  *time.Time `json:",omitempty"`
+	FinishedAt *time.Time `json:",omitempty"`
+	Duration   string     `json:",omitempty"`
+}
+
+type JobRepository interface {
+	SaveJob(job Job) error
+	// Returns errJobNotFound if there's no job with @id.
+	FindJob(id string) (Job, error)
+	// Returns all the jobs, most recent first.
+	FindJobs() ([]Job, error)
+}
+
+/*
+	Keeps each job as a JSON file in a directory, so that the job
+	records survive a restart regardless of the storage backend.
+*/
+type fileJobRepository struct {
+	dir   string
+	mutex sync.RWMutex
+}
+
+/*
+	Runs load jobs in the background, at most c.MaxConcurrentJobs at
+	the same time, recording their progress in a JobRepository.
+*/
+type JobManager struct {
+	repository JobRepository
+	service    *RestaurantService
+	slots      chan bool
+	progress   *progressBroker
+}
+
+func newFileJobRepository(dir string) (*fileJobRepository, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating jobs directory '%s' | %w", dir, err)
+	}
+
+	return &fileJobRepository{dir: dir}, nil
+}
+
+func (repository *fileJobRepository) SaveJob(job Job) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	jsonJob, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a crash can't leave a half written job
+	tmpPath := filepath.Join(repository.dir, job.Id+".json.tmp")
+	err = os.WriteFile(tmpPath, jsonJob, 0644)
+	if err != nil {
+		return fmt.Errorf("error while saving job '%s' | %w", job.Id, err)
+	}
+
+	return os.Rename(tmpPath, filepath.Join(repository.dir, job.Id+".json"))
+}
+
+func (repository *fileJobRepository) FindJob(id string) (Job, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+
+	return repository.readJob(filepath.Join(repository.dir, id+".json"))
+}
+
+func (repository *fileJobRepository) FindJobs() ([]Job, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+
+	paths, err := filepath.Glob(filepath.Join(repository.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []Job{}
+	for _, path := range paths {
+		job, err := repository.readJob(path)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+
+	return jobs, nil
+}
+
+func (repository *fileJobRepository) readJob(path string) (Job, error) {
+	jsonJob, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Job{}, errJobNotFound
+	}
+	if err != nil {
+		return Job{}, fmt.Errorf("error while reading job file '%s' | %w", path, err)
+	}
+
+	var job Job
+	err = json.Unmarshal(jsonJob, &job)
+	if err != nil {
+		return Job{}, fmt.Errorf("error while unmarshalling job file '%s' | %w", path, err)
+	}
+
+	return job, nil
+}
+
+/*
+	Returns a JobManager for @repository. Jobs that were still queued
+	or running when the server stopped are marked as failed, since
+	nothing is going to finish them.
+*/
+func newJobManager(repository JobRepository, service *RestaurantService) (*JobManager, error) {
+	jobs, err := repository.FindJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, job := range jobs {
+		if job.State == JobQueued || job.State == JobRunning {
+			job.Errors = append(job.Errors, "interrupted by a server restart")
+			finishJob(&job, JobFailed)
+
+			err = repository.SaveJob(job)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &JobManager{
+		repository: repository,
+		service:    service,
+		slots:      make(chan bool, c.MaxConcurrentJobs),
+		progress:   newProgressBroker(),
+	}, nil
+}
+
+/*
+	Creates a job that loads the data of @date and starts it in the
+	background. With @force, a synchronized date is loaded again.
+*/
+func (manager *JobManager) submitDateJob(date string, force bool) (Job, error) {
+	return manager.submit(Job{Date: date, Force: force}, func(job *Job) {
+		loadResponse, err := manager.service.loadDate(date, force, manager.progressOf(job.Id))
+		if err != nil {
+			job.Errors = append(job.Errors, err.Error())
+			return
+		}
+
+		job.Counts = loadResponse.counts()
+		job.Validation = &loadResponse.Validation
+	})
+}
+
+/*
+	Creates a job that loads the data of every date of @dates, from
+	@from to @to, and starts it in the background. With @force, the
+	synchronized dates are loaded again.
+*/
+func (manager *JobManager) submitRangeJob(from string, to string, dates []string, force bool) (Job, error) {
+	return manager.submit(Job{From: from, To: to, Force: force}, func(job *Job) {
+		var mutex sync.Mutex
+
+		manager.service.loadDateRange(dates, force, manager.progressOf(job.Id), func(summary DateLoadSummary) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			job.Dates = append(job.Dates, summary)
+			if summary.Counts != nil {
+				job.Counts.Buyers += summary.Counts.Buyers
+				job.Counts.Products += summary.Counts.Products
+				job.Counts.Transactions += summary.Counts.Transactions
+				job.Counts.Quarantined += summary.Counts.Quarantined
+			}
+			if summary.Status == DateFailed {
+				job.Errors = append(job.Errors, fmt.Sprintf("%s: %s", summary.Date, summary.Error))
+			}
+
+			manager.save(*job)
+		})
+
+		sort.Slice(job.Dates, func(i, j int) bool {
+			return job.Dates[i].Date < job.Dates[j].Date
+		})
+	})
+}
+
+/*
+	Saves @job as queued and runs @load in the background once a slot
+	is free. The job fails if @load records any error in it.
+*/
+func (manager *JobManager) submit(job Job, load func(job *Job)) (Job, error) {
+	id, err := newId()
+	if err != nil {
+		return Job{}, err
+	}
+
+	job.Id = id
+	job.State = JobQueued
+	job.CreatedAt = time.Now().UTC()
+
+	err = manager.repository.SaveJob(job)
+	if err != nil {
+		return Job{}, err
+	}
+
+	queuedJob := job
+
+	go func() {
+		manager.slots <- true
+		defer func() { <-manager.slots }()
+
+		startedAt := time.Now().UTC()
+		job.StartedAt = &startedAt
+		job.State = JobRunning
+		manager.save(job)
+		manager.publishState(job)
+
+		load(&job)
+
+		if len(job.Errors) > 0 {
+			finishJob(&job, JobFailed)
+		} else {
+			finishJob(&job, JobSucceeded)
+		}
+		manager.save(job)
+		manager.publishState(job)
+		manager.progress.finish(job.Id)
+	}()
+
+	return queuedJob, nil
+}
+
+func (manager *JobManager) save(job Job) {
+	err := manager.repository.SaveJob(job)
+	if err != nil {
+		fmt.Printf("Error while saving job '%s' | %v\n", job.Id, err)
+	}
+}
+
+/*
+	Returns a progressFunc publishing the load events of the job @jobId.
+*/
+func (manager *JobManager) progressOf(jobId string) progressFunc {
+	return func(event ProgressEvent) {
+		event.JobId = jobId
+		manager.progress.publish(event)
+	}
+}
+
+func (manager *JobManager) publishState(job Job) {
+	event := ProgressEvent{
+		JobId: job.Id,
+		Stage: JobStage,
+		Step:  job.State,
+		Time:  time.Now().UTC(),
+	}
+	if job.State == JobFailed {
+		event.Error = strings.Join(job.Errors, "; ")
+	}
+
+	manager.progress.publish(event)
+}
+
+/*
+	Returns the events of the job @jobId, see progressBroker.subscribe.
+*/
+func (manager *JobManager) subscribe(jobId string) (<-chan ProgressEvent, func()) {
+	return manager.progress.subscribe(jobId)
+}
+
+func (manager *JobManager) findJob(id string) (Job, error) {
+	return manager.repository.FindJob(id)
+}
+
+func (manager *JobManager) findJobs() ([]Job, error) {
+	return manager.repository.FindJobs()
+}
+
+func finishJob(job *Job, state string) {
+	finishedAt := time.Now().UTC()
+	job.State = state
+	job.FinishedAt = &finishedAt
+
+	if job.StartedAt != nil {
+		job.Duration = finishedAt.Sub(*job.StartedAt).String()
+	}
+}
+
+/*
+	Returns a random id for a job or a quarantined record.
+*/
+func newId() (string, error) {
+	bytes := make([]byte, 8)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", fmt.Errorf("error while generating id | %w", err)
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
+
+/*
+	Validates that @id has the format of the ids generated by newId.
+*/
+func isIdParamValid(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+
+	for _, char := range id {
+		if !strings.ContainsRune("0123456789abcdef", char) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+	Data source whose feeds aren't served until release is closed, so
+	that a job can be seen running.
+*/
+type blockingDataSource struct {
+	*fakeDataSource
+	release chan bool
+}
+
+func newBlockingDataSource() *blockingDataSource {
+	return &blockingDataSource{fakeDataSource: newFakeDataSource(), release: make(chan bool)}
+}
+
+func (source *blockingDataSource) FetchBuyers(date string) (io.ReadCloser, error) {
+	<-source.release
+	return source.fakeDataSource.FetchBuyers(date)
+}
+
+func (source *blockingDataSource) FetchProducts(date string) (io.ReadCloser, error) {
+	<-source.release
+	return source.fakeDataSource.FetchProducts(date)
+}
+
+func (source *blockingDataSource) FetchTransactions(date string) (io.ReadCloser, error) {
+	<-source.release
+	return source.fakeDataSource.FetchTransactions(date)
+}
+
+/*
+	Returns a JobManager loading from @source into a memory store,
+	keeping its jobs in @dir.
+*/
+func newTestJobManager(t *testing.T, dir string, source DataSource) *JobManager {
+	t.Helper()
+
+	manager, err := newJobs(dir, &RestaurantService{store: newMemoryStore(), source: source})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return manager
+}
+
+/*
+	Waits for the job @id to reach @state, failing the test if it
+	doesn't within a few seconds.
+*/
+func waitForJobState(t *testing.T, manager *JobManager, id string, state string) Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := manager.findJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.State == state {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", id, job.State, state)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	source := newBlockingDataSource()
+	manager := newTestJobManager(t, t.TempDir(), source)
+
+	job, err := manager.submitDateJob(testDate, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != JobQueued || !isIdParamValid(job.Id) {
+		t.Errorf("got submitted job %+v, want a queued job with an id", job)
+	}
+
+	running := waitForJobState(t, manager, job.Id, JobRunning)
+	if running.StartedAt == nil || running.FinishedAt != nil {
+		t.Errorf("got running job %+v, want it started and not finished", running)
+	}
+
+	close(source.release)
+
+	succeeded := waitForJobState(t, manager, job.Id, JobSucceeded)
+	if succeeded.FinishedAt == nil || succeeded.Duration == "" || len(succeeded.Errors) != 0 {
+		t.Errorf("got succeeded job %+v, want it finished without errors", succeeded)
+	}
+	if succeeded.Counts.Buyers != 2 || succeeded.Counts.Products != 2 || succeeded.Counts.Transactions != 2 {
+		t.Errorf("got counts %+v, want 2 of each", succeeded.Counts)
+	}
+}
+
+func TestFailedJob(t *testing.T) {
+	source := newFakeDataSource()
+	source.fetchFails[TransactionsStage] = true
+	manager := newTestJobManager(t, t.TempDir(), source)
+
+	job, err := manager.submitDateJob(testDate, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := waitForJobState(t, manager, job.Id, JobFailed)
+	if len(failed.Errors) == 0 || failed.FinishedAt == nil {
+		t.Errorf("got failed job %+v, want its error and finish time", failed)
+	}
+}
+
+/*
+	Jobs left queued or running by a stopped server are failed when
+	the jobs directory is opened again, and the others are untouched.
+*/
+func TestJobsInterruptedByRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	repository, err := newFileJobRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	startedAt := time.Now().UTC()
+	jobs := []Job{
+		{Id: "0000000000000001", State: JobQueued, Date: testDate, CreatedAt: startedAt},
+		{Id: "0000000000000002", State: JobRunning, Date: testDate, CreatedAt: startedAt, StartedAt: &startedAt},
+		{Id: "0000000000000003", State: JobSucceeded, Date: testDate, CreatedAt: startedAt},
+	}
+	for _, job := range jobs {
+		err = repository.SaveJob(job)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manager := newTestJobManager(t, dir, newFakeDataSource())
+
+	for _, id := range []string{"0000000000000001", "0000000000000002"} {
+		job, err := manager.findJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.State != JobFailed || job.FinishedAt == nil || !strings.Contains(strings.Join(job.Errors, ";"), "restart") {
+			t.Errorf("got job %+v after a restart, want it failed by the restart", job)
+		}
+	}
+
+	job, err := manager.findJob("0000000000000003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != JobSucceeded || len(job.Errors) != 0 {
+		t.Errorf("got finished job %+v after a restart, want it untouched", job)
+	}
+}
+
+/*
+	A range job adds up the counts of its dates, quarantined records
+	included.
+*/
+func TestRangeJobCounts(t *testing.T) {
+	source := newFakeDataSource()
+	source.feeds[TransactionsStage] += transactionRecord("#t3", "b1", "1.1.1.1", "toaster", "(p1)")
+	manager := newTestJobManager(t, t.TempDir(), source)
+
+	dates := []string{"2020-08-18", "2020-08-19"}
+	job, err := manager.submitRangeJob(dates[0], dates[1], dates, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	succeeded := waitForJobState(t, manager, job.Id, JobSucceeded)
+	if len(succeeded.Dates) != 2 || succeeded.Counts.Quarantined != 2 {
+		t.Errorf("got %d dates and counts %+v, want 2 dates with 1 quarantined record each", len(succeeded.Dates), succeeded.Counts)
+	}
+}
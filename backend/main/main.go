@@ -6,19 +6,35 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 
+	c "module/constants"
+	p "module/productfeed"
 	f "module/utils"
 
 	"github.com/dgraph-io/dgo/v2"
 	"github.com/dgraph-io/dgo/v2/protos/api"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/graph-gophers/graphql-go"
 
 	"google.golang.org/grpc"
 )
 
 type RequestBody struct {
 	Date string `json:"date,omitempty"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Loads synchronized dates again instead of rejecting or skipping them
+	Force bool `json:"force,omitempty"`
+	// Runs the load of 'date' without saving anything, responding with what it would save
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+type QuarantineFixBody struct {
+	// Fixed content of the record, in the format of its feed
+	Record string `json:"record"`
 }
 
 type APIDescriptor struct {
@@ -30,67 +46,520 @@ type APIDescriptor struct {
 }
 
 var ctx context.Context = context.Background()
-var dgraphClient = newDGraphClient()
-var descriptor []APIDescriptor = []APIDescriptor{
-	{
-		Method:      http.MethodPost,
-		Endpoint:    "/restaurant-data",
-		Description: "Loads all restaurant related data of the specified date to the database.",
-		Body:        "'date' in yyyy-MM-DD format",
-	},
-	{
-		Method:      http.MethodGet,
-		Endpoint:    "/buyer/all",
-		Description: "Returns all the buyers currently saved on the database.",
-	},
-	{
-		Method:      http.MethodGet,
-		Endpoint:    "/buyer/{buyerId}",
-		Description: "Returns the buyer with the id 'buyerId'.",
-	},
-}
+var apiRoutes []route
+var openAPIJson []byte
 
 var port string = f.GoDotEnvVariable("BACKEND_PORT")
 
 func main() {
+	store, err := newStore(f.GoDotEnvVariable("STORAGE_BACKEND"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(os.Args) > 1 {
+		err = runCommand(store, os.Args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// The schema is kept up to date on startup, so "migrate up" is only needed to migrate ahead of a deploy
+	migrator, ok := store.(Migrator)
+	if ok {
+		err = migrator.Migrate()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	upstream := newUpstreamClient()
+
+	source, err := newDataSource(f.GoDotEnvVariable("DATA_SOURCE"), upstream)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	archive, err := newArchive(f.GoDotEnvVariable("ARCHIVE_DIR"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	productFeedMode, err := p.ParseMode(f.GoDotEnvVariable("PRODUCT_FEED_MODE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	quarantine, err := newQuarantine(f.GoDotEnvVariable("QUARANTINE_DIR"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	service := &RestaurantService{
+		store:           store,
+		source:          newArchivingDataSource(source, archive),
+		productFeedMode: productFeedMode,
+		quarantine:      quarantine,
+	}
+
+	jobs, err := newJobs(f.GoDotEnvVariable("JOBS_DIR"), service)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scheduler, err := newScheduler(f.GoDotEnvVariable("SYNC_SCHEDULE"), f.GoDotEnvVariable("SYNC_DIR"), service, jobs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	scheduler.start()
+
+	controller := &RestaurantController{
+		service:   service,
+		jobs:      jobs,
+		upstream:  upstream,
+		scheduler: scheduler,
+	}
+
+	graphqlHandler, err := newGraphqlHandler(service)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	router, err := newRouter(controller, graphqlHandler)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Server listening on port %s\n", port)
+
+	err = http.ListenAndServe(":"+port, router)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+/*
+	Runs the command named by the first of @args instead of the
+	server.
+*/
+func runCommand(store Store, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrateCommand(store, args[1:])
+	case "replay":
+		return runReplayCommand(store, args[1:])
+	default:
+		return fmt.Errorf("unknown command '%s', expected migrate or replay", args[0])
+	}
+}
+
+/*
+	Returns the router serving the routes of the API, after
+	generating their OpenAPI document.
+*/
+func newRouter(controller *RestaurantController, graphqlHandler http.Handler) (chi.Router, error) {
+	apiRoutes = newRoutes(controller, graphqlHandler)
+
+	document, err := newOpenAPIDocument(apiRoutes)
+	if err != nil {
+		return nil, err
+	}
+
+	openAPIJson, err = json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
 
 	router := chi.NewRouter()
 	router.Use(middleware.Logger)
 	router.Use(middleware.Recoverer)
+	router.Use(middleware.StripSlashes)
 	router.Use(corsMiddleware)
 
-	router.Route("/", func(router chi.Router) {
-		router.Get("/", describeAPI)
-	})
+	registerRoutes(router, apiRoutes)
+
+	return router, nil
+}
+
+/*
+	Returns the routes of the API, which are registered on the router
+	and documented by GET / and GET /openapi.json.
+*/
+func newRoutes(controller *RestaurantController, graphqlHandler http.Handler) []route {
+	internalError := errorResponse(http.StatusInternalServerError, "The request couldn't be processed.")
+	pageSize := fmt.Sprintf("defaults to %d, at most %d", c.DefaultPageSize, c.MaxPageSize)
+
+	return []route{
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/",
+			Description: "Describes the endpoints of the API.",
+			Handler:     describeAPI,
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "The endpoints of the API.", []APIDescriptor{}),
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/openapi.json",
+			Description: "Returns the OpenAPI 3 document of the API.",
+			Handler:     serveOpenAPI,
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "The OpenAPI document.", map[string]interface{}{}),
+			},
+		},
+		{
+			Method:      http.MethodPost,
+			Pattern:     "/restaurant-data",
+			Description: "Starts a job that loads all restaurant related data of the specified date, or of every date of the specified range, to the database.",
+			Middlewares: []func(http.Handler) http.Handler{restaurantCtx},
+			Handler:     controller.loadRestaurantData,
+			Body: &routeBody{
+				Description: "'date', or 'from' and 'to', in yyyy-MM-DD format. 'force': true reloads dates that are already synchronized. 'dryRun': true loads 'date' without saving anything and responds with the new and already saved records of its feeds, and the validation issues found in them.",
+				Value:       RequestBody{},
+			},
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "What the load of 'date' would save, for dry runs.", DryRunResponse{}),
+				jsonResponse(http.StatusAccepted, "The queued load job, which can be followed at the Location header.", Job{}),
+				errorResponse(http.StatusBadRequest, "The body or the dates are invalid."),
+				errorResponse(http.StatusConflict, "'date' is already synchronized, with the code 'date_already_synchronized'."),
+				errorResponse(http.StatusBadGateway, "The feeds of a dry run couldn't be fetched."),
+				internalError,
+			},
+		},
+		{
+			Method:      http.MethodDelete,
+			Pattern:     "/restaurant-data/{date}",
+			Description: "Deletes the transactions of the date, in yyyy-MM-DD format, and the buyers and products only that date introduced.",
+			Middlewares: []func(http.Handler) http.Handler{purgeCtx},
+			Handler:     controller.purgeRestaurantData,
+			Params: []routeParam{
+				pathParam(string(dateKey), "Date to purge, in yyyy-MM-DD format."),
+			},
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "How many records were deleted.", PurgeResponse{}),
+				errorResponse(http.StatusBadRequest, "The date is invalid."),
+				internalError,
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/jobs",
+			Description: "Returns all the data load jobs, most recent first.",
+			Handler:     controller.getJobs,
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "The jobs.", []Job{}),
+				internalError,
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/jobs/{jobId}",
+			Description: "Returns the state, counts, errors and timings of the job with the id 'jobId'.",
+			Middlewares: []func(http.Handler) http.Handler{jobCtx},
+			Handler:     controller.getJob,
+			Params: []routeParam{
+				pathParam(string(jobIdKey), "Id of the job."),
+			},
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "The job.", Job{}),
+				errorResponse(http.StatusBadRequest, "The job id is invalid."),
+				errorResponse(http.StatusNotFound, "There's no job with the id."),
+				internalError,
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/jobs/{jobId}/events",
+			Description: "Streams the progress of the job with the id 'jobId' as Server-Sent Events, ending with a 'done' event holding the finished job.",
+			Middlewares: []func(http.Handler) http.Handler{jobCtx},
+			Handler:     controller.getJobEvents,
+			Params: []routeParam{
+				pathParam(string(jobIdKey), "Id of the job."),
+			},
+			Responses: []routeResponse{
+				{
+					Status:      http.StatusOK,
+					Description: "'progress' events holding a ProgressEvent, then a 'done' event holding the Job.",
+					ContentType: "text/event-stream",
+					Value:       ProgressEvent{},
+				},
+				errorResponse(http.StatusBadRequest, "The job id is invalid."),
+				errorResponse(http.StatusNotFound, "There's no job with the id."),
+				internalError,
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/quarantine",
+			Description: "Returns the feed records that were left out of the loads, with the issues found in them.",
+			Handler:     controller.getQuarantinedRecords,
+			Params: []routeParam{
+				queryParam(string(dateKey), "string", "Date, in yyyy-MM-DD format, to only return the records of that date.", false),
+			},
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "The quarantined records.", []QuarantinedRecord{}),
+				errorResponse(http.StatusBadRequest, "The date is invalid."),
+				internalError,
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/quarantine/{recordId}",
+			Description: "Returns the quarantined record with the id 'recordId'.",
+			Middlewares: []func(http.Handler) http.Handler{quarantineCtx},
+			Handler:     controller.getQuarantinedRecord,
+			Params: []routeParam{
+				pathParam(string(recordIdKey), "Id of the quarantined record."),
+			},
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "The quarantined record.", QuarantinedRecord{}),
+				errorResponse(http.StatusBadRequest, "The record id is invalid."),
+				errorResponse(http.StatusNotFound, "There's no quarantined record with the id."),
+				internalError,
+			},
+		},
+		{
+			Method:      http.MethodPut,
+			Pattern:     "/quarantine/{recordId}",
+			Description: "Replaces the content of the quarantined record with the id 'recordId'.",
+			Middlewares: []func(http.Handler) http.Handler{quarantineCtx},
+			Handler:     controller.fixQuarantinedRecord,
+			Params: []routeParam{
+				pathParam(string(recordIdKey), "Id of the quarantined record."),
+			},
+			Body: &routeBody{
+				Description: "'record', the fixed record in the format of its feed.",
+				Value:       QuarantineFixBody{},
+			},
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "The fixed record.", QuarantinedRecord{}),
+				errorResponse(http.StatusBadRequest, "The record id or the body is invalid."),
+				errorResponse(http.StatusNotFound, "There's no quarantined record with the id."),
+				internalError,
+			},
+		},
+		{
+			Method:      http.MethodDelete,
+			Pattern:     "/quarantine/{recordId}",
+			Description: "Discards the quarantined record with the id 'recordId'.",
+			Middlewares: []func(http.Handler) http.Handler{quarantineCtx},
+			Handler:     controller.discardQuarantinedRecord,
+			Params: []routeParam{
+				pathParam(string(recordIdKey), "Id of the quarantined record."),
+			},
+			Responses: []routeResponse{
+				{Status: http.StatusNoContent, Description: "The record was discarded."},
+				errorResponse(http.StatusBadRequest, "The record id is invalid."),
+				errorResponse(http.StatusNotFound, "There's no quarantined record with the id."),
+				internalError,
+			},
+		},
+		{
+			Method:      http.MethodPost,
+			Pattern:     "/quarantine/{recordId}/import",
+			Description: "Validates the quarantined record with the id 'recordId' again and saves it to the database. Responds with 422 and the record if it still has issues.",
+			Middlewares: []func(http.Handler) http.Handler{quarantineCtx},
+			Handler:     controller.importQuarantinedRecord,
+			Params: []routeParam{
+				pathParam(string(recordIdKey), "Id of the quarantined record."),
+			},
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "The imported record, which is no longer quarantined.", QuarantinedRecord{}),
+				jsonResponse(http.StatusUnprocessableEntity, "The record, with the issues it still has.", QuarantinedRecord{}),
+				errorResponse(http.StatusBadRequest, "The record id is invalid."),
+				errorResponse(http.StatusNotFound, "There's no quarantined record with the id."),
+				internalError,
+			},
+		},
+		{
+			Method:      http.MethodPost,
+			Pattern:     "/graphql",
+			Description: "Runs a GraphQL query over the buyers, their transactions and products, the buyers sharing their IPs and their recommendations.",
+			Handler:     graphqlHandler.ServeHTTP,
+			Body: &routeBody{
+				Description: "'query', and optionally 'operationName' and 'variables'.",
+				Value:       GraphqlRequest{},
+			},
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "The data of the query, and the errors found while running it.", graphql.Response{}),
+				{Status: http.StatusBadRequest, Description: "The body isn't a GraphQL request.", ContentType: "text/plain", Value: ""},
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/graphql/playground",
+			Description: "Serves a GraphiQL page to explore the GraphQL schema and run queries.",
+			Handler:     graphqlPlayground,
+			Responses: []routeResponse{
+				{Status: http.StatusOK, Description: "The GraphiQL page.", ContentType: "text/html", Value: ""},
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/sync/history",
+			Description: "Returns the scheduled synchronizations, most recent first, with the dates each one loaded and the state of its job.",
+			Handler:     controller.getSyncHistory,
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "The synchronization runs.", []SyncRun{}),
+				internalError,
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/upstream/metrics",
+			Description: "Returns the request, retry and failure counters of the upstream feed fetches, and the state of their circuit breaker.",
+			Handler:     controller.getUpstreamMetrics,
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "The upstream metrics.", UpstreamMetrics{}),
+				internalError,
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/buyer/all",
+			Description: "Returns a page of the buyers currently saved on the database.",
+			Middlewares: []func(http.Handler) http.Handler{buyersCtx},
+			Handler:     controller.getBuyers,
+			Params: []routeParam{
+				queryParam(string(firstKey), "integer", "Number of buyers to return, "+pageSize+".", false),
+				queryParam(string(afterKey), "string", "EndCursor of the previous page, to return the buyers after it.", false),
+			},
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "The page of buyers, how many buyers there are and the cursor of the next page.", BuyerCollection{}),
+				errorResponse(http.StatusBadRequest, "The page parameters are invalid."),
+				internalError,
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/buyer/{buyerId}",
+			Description: "Returns the buyer with the id 'buyerId', a page of their transaction history, a page of the buyers that used the same IPs and product recommendations.",
+			Middlewares: []func(http.Handler) http.Handler{buyerCtx},
+			Handler:     controller.getBuyer,
+			Params: []routeParam{
+				pathParam(string(buyerIdKey), "Id of the buyer."),
+				queryParam(string(firstBKey), "integer", "Number of buyers with the same IP to return, "+pageSize+".", false),
+				queryParam(string(afterBKey), "string", "EndCursor of the previous page of buyers with the same IP.", false),
+				queryParam(string(firstTKey), "integer", "Number of transactions to return, "+pageSize+".", false),
+				queryParam(string(afterTKey), "string", "EndCursor of the previous page of the transaction history.", false),
+			},
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "The buyer.", BuyerIdEndpoint{}),
+				errorResponse(http.StatusBadRequest, "The buyer id or the page parameters are invalid."),
+				errorResponse(http.StatusNotFound, "There's no buyer with the id."),
+				internalError,
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/products",
+			Description: "Returns the products with the given ids. The ids of the products that aren't saved are listed in 'missing'.",
+			Middlewares: []func(http.Handler) http.Handler{productsCtx},
+			Handler:     controller.getProducts,
+			Params: []routeParam{
+				queryParam(string(productsKey), "string", "Comma separated ids of the products.", true),
+			},
+			Responses: []routeResponse{
+				jsonResponse(http.StatusOK, "The saved products among the requested ones, and the requested ids that aren't saved.", ProductsById{}),
+				errorResponse(http.StatusBadRequest, "The product ids are invalid."),
+				internalError,
+			},
+		},
+	}
+}
+
+/*
+	Returns the storage backend named by @backend. Dgraph is used
+	when no backend is configured.
+*/
+func newStore(backend string) (Store, error) {
+	switch backend {
+	case "", c.DgraphStorage:
+		return newDgraphStore(newDGraphClient()), nil
+	case c.MemoryStorage:
+		return newMemoryStore(), nil
+	case c.SqliteStorage:
+		return newSqlStore(f.GoDotEnvVariable("SQLITE_PATH"))
+	default:
+		return nil, fmt.Errorf("unknown storage backend '%s'", backend)
+	}
+}
 
-	router.Route("/restaurant-data", func(router chi.Router) {
-		router.Use(restaurantCtx)
+/*
+	Returns the upstream data source named by @source. The upstream
+	HTTP endpoints, fetched through @upstream, are used when no data
+	source is configured.
+*/
+func newDataSource(source string, upstream *upstreamClient) (DataSource, error) {
+	switch source {
+	case "", c.HttpDataSource:
+		return newHttpDataSource(f.GoDotEnvVariable("DATA_SOURCE_URL"), upstream), nil
+	case c.DirectoryDataSource:
+		return newDirectoryDataSource(f.GoDotEnvVariable("DATA_SOURCE_DIR")), nil
+	default:
+		return nil, fmt.Errorf("unknown data source '%s'", source)
+	}
+}
 
-		router.Post("/", loadRestaurantData)
-	})
+/*
+	Returns the JobManager that keeps its job records in @dir, or in
+	c.DefaultJobsDir when no directory is configured.
+*/
+func newJobs(dir string, service *RestaurantService) (*JobManager, error) {
+	if dir == "" {
+		dir = c.DefaultJobsDir
+	}
 
-	router.Route("/buyer", func(router chi.Router) {
-		router.Use(buyersCtx)
-		router.Get("/all", getBuyers)
+	repository, err := newFileJobRepository(dir)
+	if err != nil {
+		return nil, err
+	}
 
-		router.Route("/{buyerId}", func(router chi.Router) {
-			router.Use(buyerCtx)
-			router.Get("/", getBuyer)
-		})
-	})
+	return newJobManager(repository, service)
+}
 
-	router.Route("/products", func(router chi.Router) {
-		router.Use(productsCtx)
+/*
+	Returns the repository that keeps the quarantined records in
+	@dir, or in c.DefaultQuarantineDir when no directory is configured.
+*/
+func newQuarantine(dir string) (QuarantineRepository, error) {
+	if dir == "" {
+		dir = c.DefaultQuarantineDir
+	}
 
-		router.Get("/", getProducts)
-	})
+	return newFileQuarantineRepository(dir)
+}
 
-	fmt.Printf("Server listening on port %s\n", port)
+/*
+	Returns the scheduler synchronizing on the cron expression
+	@schedule, which keeps its history in @dir, or in c.DefaultSyncDir
+	when no directory is configured.
+*/
+func newScheduler(schedule string, dir string, service *RestaurantService, jobs *JobManager) (*SyncScheduler, error) {
+	if dir == "" {
+		dir = c.DefaultSyncDir
+	}
 
-	err := http.ListenAndServe(":"+port, router)
+	history, err := newFileSyncRepository(dir)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+
+	return newSyncScheduler(schedule, history, service, jobs)
+}
+
+/*
+	Returns the archive of the raw feeds in @dir, or in
+	c.DefaultArchiveDir when no directory is configured.
+*/
+func newArchive(dir string) (*feedArchive, error) {
+	if dir == "" {
+		dir = c.DefaultArchiveDir
+	}
+
+	return newFeedArchive(dir)
 }
 
 func newDGraphClient() *dgo.Dgraph {
@@ -104,13 +573,43 @@ func newDGraphClient() *dgo.Dgraph {
 	return dgo.NewDgraphClient(dc)
 }
 
+/*
+	Describes the endpoints of apiRoutes.
+*/
 func describeAPI(writter http.ResponseWriter, request *http.Request) {
+	descriptor := []APIDescriptor{}
+	for _, route := range apiRoutes {
+		apiDescriptor := APIDescriptor{
+			Method:      route.Method,
+			Endpoint:    route.Pattern,
+			Description: route.Description,
+		}
+
+		if route.Body != nil {
+			apiDescriptor.Body = route.Body.Description
+		}
+
+		var queryParams []string
+		for _, param := range route.Params {
+			if param.In == "query" {
+				queryParams = append(queryParams, fmt.Sprintf("'%s': %s", param.Name, param.Description))
+			}
+		}
+		apiDescriptor.URLParam = strings.Join(queryParams, " ")
+
+		descriptor = append(descriptor, apiDescriptor)
+	}
+
 	jsonDescriptor, err := json.Marshal(descriptor)
 
 	if err != nil {
-		http.Error(writter, "error while processing response", http.StatusInternalServerError)
+		writeError(writter, fmt.Errorf("error while processing response | %w", err))
 		return
 	}
 
 	writter.Write(jsonDescriptor)
 }
+
+func serveOpenAPI(writter http.ResponseWriter, request *http.Request) {
+	writter.Write(openAPIJson)
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"fmt"
+	c "module/constants"
+	f "module/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	Store that keeps all the data in memory. It doesn't need any
+	external service, so it's meant for development and CI machines.
+*/
+type memoryStore struct {
+	mutex        sync.RWMutex
+	buyers       []Buyer
+	products     []Product
+	transactions []Transaction
+}
+
+/*
+	Buffers the writes of a load until Commit is called, the same
+	way a Dgraph transaction does. Deletions are recorded as the
+	dates and ids to drop, and are applied before the new data.
+*/
+type memoryTxn struct {
+	store             *memoryStore
+	mutex             sync.Mutex
+	finished          bool
+	buyers            []Buyer
+	products          []Product
+	transactions      []Transaction
+	deletedDates      []string
+	deletedBuyerIds   []string
+	deletedProductIds []string
+}
+
+type memoryBuyerRepository struct {
+	store *memoryStore
+}
+
+type memoryProductRepository struct {
+	store *memoryStore
+}
+
+type memoryTransactionRepository struct {
+	store *memoryStore
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{}
+}
+
+func (store *memoryStore) Buyers() BuyerRepository {
+	return &memoryBuyerRepository{store: store}
+}
+
+func (store *memoryStore) Products() ProductRepository {
+	return &memoryProductRepository{store: store}
+}
+
+func (store *memoryStore) Transactions() TransactionRepository {
+	return &memoryTransactionRepository{store: store}
+}
+
+func (store *memoryStore) NewTxn() (Txn, error) {
+	return &memoryTxn{store: store}, nil
+}
+
+func (t *memoryTxn) Commit() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.finished {
+		return fmt.Errorf("transaction has already been committed or discarded")
+	}
+	t.finished = true
+
+	t.store.mutex.Lock()
+	defer t.store.mutex.Unlock()
+
+	t.store.transactions = t.remainingTransactions()
+
+	var buyers []Buyer
+	for _, buyer := range t.store.buyers {
+		if !f.ArrayContains(t.deletedBuyerIds, buyer.BuyerId) {
+			buyers = append(buyers, buyer)
+		}
+	}
+	t.store.buyers = buyers
+
+	var products []Product
+	for _, product := range t.store.products {
+		if !f.ArrayContains(t.deletedProductIds, product.ProductId) {
+			products = append(products, product)
+		}
+	}
+	t.store.products = products
+
+	// Another txn can have saved the same ids since they were
+	// checked, so they are checked again the way an upsert would
+	buyerIds := t.buyerIds()
+	for _, buyer := range t.buyers {
+		if !buyerIds[buyer.BuyerId] {
+			t.store.buyers = append(t.store.buyers, buyer)
+		}
+	}
+
+	productIds := t.productIds()
+	for _, product := range t.products {
+		if !productIds[product.ProductId] {
+			t.store.products = append(t.store.products, product)
+		}
+	}
+
+	transactionIds := t.transactionIds()
+	for _, transaction := range t.transactions {
+		if !transactionIds[transaction.TransactionId] {
+			t.store.transactions = append(t.store.transactions, transaction)
+		}
+	}
+
+	return nil
+}
+
+func (t *memoryTxn) Discard() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.finished = true
+	t.buyers = nil
+	t.products = nil
+	t.transactions = nil
+	t.deletedDates = nil
+	t.deletedBuyerIds = nil
+	t.deletedProductIds = nil
+}
+
+/*
+	Returns the stored transactions whose date isn't deleted by the
+	txn. Must be called holding the store lock.
+*/
+func (t *memoryTxn) remainingTransactions() []Transaction {
+	var transactions []Transaction
+	for _, transaction := range t.store.transactions {
+		if !f.ArrayContains(t.deletedDates, transaction.Date) {
+			transactions = append(transactions, transaction)
+		}
+	}
+
+	return transactions
+}
+
+/*
+	Return the ids of the stored buyers, products and transactions
+	that the txn doesn't delete. Must be called holding the store
+	lock.
+*/
+func (t *memoryTxn) buyerIds() map[string]bool {
+	ids := map[string]bool{}
+	for _, buyer := range t.store.buyers {
+		if !f.ArrayContains(t.deletedBuyerIds, buyer.BuyerId) {
+			ids[buyer.BuyerId] = true
+		}
+	}
+
+	return ids
+}
+
+func (t *memoryTxn) productIds() map[string]bool {
+	ids := map[string]bool{}
+	for _, product := range t.store.products {
+		if !f.ArrayContains(t.deletedProductIds, product.ProductId) {
+			ids[product.ProductId] = true
+		}
+	}
+
+	return ids
+}
+
+func (t *memoryTxn) transactionIds() map[string]bool {
+	ids := map[string]bool{}
+	for _, transaction := range t.remainingTransactions() {
+		ids[transaction.TransactionId] = true
+	}
+
+	return ids
+}
+
+func asMemoryTxn(txn Txn) *memoryTxn {
+	return txn.(*memoryTxn)
+}
+
+func (repository *memoryBuyerRepository) FindBuyers(page PageRequest) (BuyerCollection, error) {
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	buyers := append([]Buyer{}, repository.store.buyers...)
+
+	return pageBuyers(buyers, page), nil
+}
+
+func (repository *memoryBuyerRepository) FindBuyersByIds(buyerIds []string, excludedBuyerId string, page PageRequest) (BuyerCollection, error) {
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	buyers := []Buyer{}
+	for _, buyer := range repository.store.buyers {
+		if f.ArrayContains(buyerIds, buyer.BuyerId) && buyer.BuyerId != excludedBuyerId {
+			buyers = append(buyers, buyer)
+		}
+	}
+
+	return pageBuyers(buyers, page), nil
+}
+
+func (repository *memoryBuyerRepository) FindBuyersByIps(ips []string, excludedBuyerId string, page PageRequest) (BuyerCollection, error) {
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	sharing := map[string]bool{}
+	for _, transaction := range repository.store.transactions {
+		if f.ArrayContains(ips, transaction.Ip) && transaction.BuyerId != excludedBuyerId {
+			sharing[transaction.BuyerId] = true
+		}
+	}
+
+	buyers := []Buyer{}
+	for _, buyer := range repository.store.buyers {
+		if sharing[buyer.BuyerId] {
+			buyers = append(buyers, buyer)
+		}
+	}
+
+	return pageBuyers(buyers, page), nil
+}
+
+/*
+	Returns @page of @buyers, which are sorted in place.
+*/
+func pageBuyers(buyers []Buyer, page PageRequest) BuyerCollection {
+	sort.Slice(buyers, func(i, j int) bool {
+		return buyers[i].BuyerId < buyers[j].BuyerId
+	})
+
+	ids := make([]string, len(buyers))
+	for i, buyer := range buyers {
+		ids[i] = buyer.BuyerId
+	}
+
+	start, end := pageOf(ids, page)
+	return newBuyerCollection(buyers[start:end], len(buyers), page)
+}
+
+func (repository *memoryBuyerRepository) FindBuyerName(buyerId string) (string, error) {
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	for _, buyer := range repository.store.buyers {
+		if buyer.BuyerId == buyerId {
+			return buyer.Name, nil
+		}
+	}
+
+	return "", errBuyerNotFound
+}
+
+func (repository *memoryBuyerRepository) SaveBuyers(txn Txn, buyers []Buyer) ([]Buyer, error) {
+	memTxn := asMemoryTxn(txn)
+	memTxn.mutex.Lock()
+	defer memTxn.mutex.Unlock()
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	savedIds := memTxn.buyerIds()
+	for _, buyer := range memTxn.buyers {
+		savedIds[buyer.BuyerId] = true
+	}
+
+	var saved []Buyer
+	for _, buyer := range buyers {
+		if savedIds[buyer.BuyerId] {
+			continue
+		}
+
+		storedDate, err := toOptionalStoredDate(buyer.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		saved = append(saved, buyer)
+		savedIds[buyer.BuyerId] = true
+
+		// Dgraph doesn't return the node type when querying with expand(_all_)
+		buyer.Type = ""
+		buyer.Date = storedDate
+		memTxn.buyers = append(memTxn.buyers, buyer)
+	}
+
+	return saved, nil
+}
+
+func (repository *memoryBuyerRepository) DeleteOrphanBuyers(txn Txn, date string) (int, error) {
+	storedDate, err := toStoredDate(date)
+	if err != nil {
+		return 0, err
+	}
+
+	memTxn := asMemoryTxn(txn)
+	memTxn.mutex.Lock()
+	defer memTxn.mutex.Unlock()
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	var referencedIds []string
+	for _, transactions := range [][]Transaction{memTxn.remainingTransactions(), memTxn.transactions} {
+		for _, transaction := range transactions {
+			referencedIds = append(referencedIds, transaction.BuyerId)
+		}
+	}
+
+	deleted := 0
+	for _, buyer := range repository.store.buyers {
+		if buyer.Date != storedDate || f.ArrayContains(referencedIds, buyer.BuyerId) ||
+			f.ArrayContains(memTxn.deletedBuyerIds, buyer.BuyerId) {
+			continue
+		}
+
+		memTxn.deletedBuyerIds = append(memTxn.deletedBuyerIds, buyer.BuyerId)
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+func (repository *memoryProductRepository) FindProductsByIds(productIds []string) ([]Product, error) {
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	products := []Product{}
+	for _, product := range repository.store.products {
+		if f.ArrayContains(productIds, product.ProductId) {
+			products = append(products, product)
+		}
+	}
+
+	return products, nil
+}
+
+func (repository *memoryProductRepository) SaveProducts(txn Txn, products []Product) ([]Product, error) {
+	memTxn := asMemoryTxn(txn)
+	memTxn.mutex.Lock()
+	defer memTxn.mutex.Unlock()
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	savedIds := memTxn.productIds()
+	for _, product := range memTxn.products {
+		savedIds[product.ProductId] = true
+	}
+
+	var saved []Product
+	for _, product := range products {
+		if savedIds[product.ProductId] {
+			continue
+		}
+
+		storedDate, err := toOptionalStoredDate(product.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		saved = append(saved, product)
+		savedIds[product.ProductId] = true
+
+		product.Type = ""
+		product.Date = storedDate
+		memTxn.products = append(memTxn.products, product)
+	}
+
+	return saved, nil
+}
+
+func (repository *memoryProductRepository) DeleteOrphanProducts(txn Txn, date string) (int, error) {
+	storedDate, err := toStoredDate(date)
+	if err != nil {
+		return 0, err
+	}
+
+	memTxn := asMemoryTxn(txn)
+	memTxn.mutex.Lock()
+	defer memTxn.mutex.Unlock()
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	var referencedIds []string
+	for _, transactions := range [][]Transaction{memTxn.remainingTransactions(), memTxn.transactions} {
+		for _, transaction := range transactions {
+			referencedIds = append(referencedIds, transaction.Products...)
+		}
+	}
+
+	deleted := 0
+	for _, product := range repository.store.products {
+		if product.Date != storedDate || f.ArrayContains(referencedIds, product.ProductId) ||
+			f.ArrayContains(memTxn.deletedProductIds, product.ProductId) {
+			continue
+		}
+
+		memTxn.deletedProductIds = append(memTxn.deletedProductIds, product.ProductId)
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+func (repository *memoryTransactionRepository) FindTransactionHistoryPage(buyerId string, page PageRequest) (TransactionCollection, error) {
+	transactions := repository.findHistory(buyerId)
+
+	ids := make([]string, len(transactions))
+	for i, transaction := range transactions {
+		ids[i] = transaction.TransactionId
+	}
+
+	start, end := pageOf(ids, page)
+	return newTransactionCollection(transactions[start:end], len(transactions), page), nil
+}
+
+/*
+	Returns the transactions of @buyerId, sorted by TransactionId.
+*/
+func (repository *memoryTransactionRepository) findHistory(buyerId string) []Transaction {
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	transactions := []Transaction{}
+	for _, transaction := range repository.store.transactions {
+		if transaction.BuyerId == buyerId {
+			transactions = append(transactions, transaction)
+		}
+	}
+
+	sort.Slice(transactions, func(i, j int) bool {
+		return transactions[i].TransactionId < transactions[j].TransactionId
+	})
+
+	return transactions
+}
+
+func (repository *memoryTransactionRepository) FindBuyerActivity(buyerId string) (BuyerActivity, error) {
+	activity := BuyerActivity{Ips: []string{}, ProductIds: []string{}}
+	seenIps := map[string]bool{}
+	seenProductIds := map[string]bool{}
+
+	for _, transaction := range repository.findHistory(buyerId) {
+		if !seenIps[transaction.Ip] {
+			seenIps[transaction.Ip] = true
+			activity.Ips = append(activity.Ips, transaction.Ip)
+		}
+
+		for _, productId := range transaction.Products {
+			if !seenProductIds[productId] {
+				seenProductIds[productId] = true
+				activity.ProductIds = append(activity.ProductIds, productId)
+			}
+		}
+	}
+
+	return activity, nil
+}
+
+func (repository *memoryTransactionRepository) FindTransactionsWithProducts(productIds []string, first int) ([]Transaction, error) {
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	transactions := []Transaction{}
+	for _, transaction := range repository.store.transactions {
+		if len(transactions) >= first {
+			break
+		}
+
+		for _, productId := range transaction.Products {
+			if f.ArrayContains(productIds, productId) {
+				transactions = append(transactions, transaction)
+				break
+			}
+		}
+	}
+
+	return transactions, nil
+}
+
+func (repository *memoryTransactionRepository) IsDateSynchronized(txn Txn, date string) (bool, error) {
+	storedDate, err := toStoredDate(date)
+	if err != nil {
+		return false, err
+	}
+
+	memTxn := asMemoryTxn(txn)
+	memTxn.mutex.Lock()
+	defer memTxn.mutex.Unlock()
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	for _, transactions := range [][]Transaction{memTxn.remainingTransactions(), memTxn.transactions} {
+		for _, transaction := range transactions {
+			if transaction.Date == storedDate {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func (repository *memoryTransactionRepository) FindLatestSynchronizedDate(txn Txn) (string, error) {
+	memTxn := asMemoryTxn(txn)
+	memTxn.mutex.Lock()
+	defer memTxn.mutex.Unlock()
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	latest := ""
+	for _, transactions := range [][]Transaction{memTxn.remainingTransactions(), memTxn.transactions} {
+		for _, transaction := range transactions {
+			if transaction.Date > latest {
+				latest = transaction.Date
+			}
+		}
+	}
+
+	if latest == "" {
+		return "", nil
+	}
+
+	t, err := time.Parse(time.RFC3339, latest)
+	if err != nil {
+		return "", fmt.Errorf("error while parsing string '%s' to date | %w", latest, err)
+	}
+
+	return t.Format(c.DateLayout), nil
+}
+
+func (repository *memoryTransactionRepository) SaveTransactions(txn Txn, transactions []Transaction) (int, error) {
+	memTxn := asMemoryTxn(txn)
+	memTxn.mutex.Lock()
+	defer memTxn.mutex.Unlock()
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	savedIds := memTxn.transactionIds()
+	for _, transaction := range memTxn.transactions {
+		savedIds[transaction.TransactionId] = true
+	}
+
+	saved := 0
+	for _, transaction := range transactions {
+		if savedIds[transaction.TransactionId] {
+			continue
+		}
+
+		storedDate, err := toStoredDate(transaction.Date)
+		if err != nil {
+			return saved, err
+		}
+
+		saved++
+		savedIds[transaction.TransactionId] = true
+
+		transaction.Date = storedDate
+		transaction.Type = ""
+		// Copied so it's [] like in the other stores when it has no products
+		transaction.Products = append([]string{}, transaction.Products...)
+		memTxn.transactions = append(memTxn.transactions, transaction)
+	}
+
+	return saved, nil
+}
+
+func (repository *memoryTransactionRepository) DeleteTransactionsOfDate(txn Txn, date string) (int, error) {
+	storedDate, err := toStoredDate(date)
+	if err != nil {
+		return 0, err
+	}
+
+	memTxn := asMemoryTxn(txn)
+	memTxn.mutex.Lock()
+	defer memTxn.mutex.Unlock()
+	repository.store.mutex.RLock()
+	defer repository.store.mutex.RUnlock()
+
+	deleted := 0
+	for _, transaction := range memTxn.remainingTransactions() {
+		if transaction.Date == storedDate {
+			deleted++
+		}
+	}
+
+	var transactions []Transaction
+	for _, transaction := range memTxn.transactions {
+		if transaction.Date == storedDate {
+			deleted++
+		} else {
+			transactions = append(transactions, transaction)
+		}
+	}
+
+	memTxn.transactions = transactions
+	memTxn.deletedDates = append(memTxn.deletedDates, storedDate)
+	return deleted, nil
+}
+
+/*
+	Converts a yyyy-MM-DD date to the RFC3339 representation
+	Dgraph returns for datetime predicates, so that both stores
+	produce the same output.
+*/
+func toStoredDate(date string) (string, error) {
+	t, err := time.Parse(c.DateLayout, date)
+	if err != nil {
+		return "", fmt.Errorf("error while parsing string '%s' to date | %w", date, err)
+	}
+
+	return t.Format(time.RFC3339), nil
+}
+
+/*
+	Same as toStoredDate, but keeps an empty @date empty. Buyers and
+	products loaded before they were tagged with their date have none.
+*/
+func toOptionalStoredDate(date string) (string, error) {
+	if date == "" {
+		return "", nil
+	}
+
+	return toStoredDate(date)
+}
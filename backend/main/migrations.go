@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+	Migration of a store's schema. AppliedAt is nil while the
+	migration is pending.
+*/
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+	// Embedded file of the migration
+	file string
+}
+
+/*
+	Implemented by the stores whose schema is versioned.
+*/
+type Migrator interface {
+	// Returns every migration, applied or not, ordered by version.
+	Migrations() ([]MigrationStatus, error)
+	// Applies the pending migrations in order.
+	Migrate() error
+}
+
+/*
+	Runs the migrate command: "status" lists the migrations of
+	@store and whether they are applied, and "up" applies the pending
+	ones.
+*/
+func runMigrateCommand(store Store, args []string) error {
+	migrator, ok := store.(Migrator)
+	if !ok {
+		return fmt.Errorf("the storage backend has no migrations")
+	}
+
+	if len(args) != 1 {
+		return fmt.Errorf("usage: migrate status|up")
+	}
+
+	switch args[0] {
+	case "status":
+		return printMigrationStatus(migrator)
+	case "up":
+		err := migrator.Migrate()
+		if err != nil {
+			return err
+		}
+
+		return printMigrationStatus(migrator)
+	default:
+		return fmt.Errorf("unknown migrate command '%s', expected status or up", args[0])
+	}
+}
+
+func printMigrationStatus(migrator Migrator) error {
+	statuses, err := migrator.Migrations()
+	if err != nil {
+		return err
+	}
+
+	schemaVersion := 0
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "VERSION\tNAME\tAPPLIED AT")
+
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+			schemaVersion = status.Version
+		}
+
+		fmt.Fprintf(writer, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Schema version: %d\n", schemaVersion)
+	return nil
+}
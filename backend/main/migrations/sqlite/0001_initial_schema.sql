@@ -0,0 +1,38 @@
+CREATE TABLE buyers (
+	id       INTEGER PRIMARY KEY AUTOINCREMENT,
+	buyer_id TEXT    NOT NULL UNIQUE,
+	name     TEXT    NOT NULL,
+	age      INTEGER NOT NULL
+);
+
+CREATE TABLE products (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	product_id TEXT    NOT NULL UNIQUE,
+	name       TEXT    NOT NULL,
+	price      TEXT    NOT NULL
+);
+
+-- BuyerId and the product ids aren't foreign keys because the upstream
+-- feeds can reference buyers and products that were never published.
+CREATE TABLE transactions (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	transaction_id TEXT    NOT NULL,
+	buyer_id       TEXT    NOT NULL,
+	ip             TEXT    NOT NULL,
+	device         TEXT    NOT NULL,
+	date           TEXT    NOT NULL
+);
+
+CREATE INDEX transactions_transaction_id ON transactions (transaction_id);
+CREATE INDEX transactions_buyer_id ON transactions (buyer_id);
+CREATE INDEX transactions_ip ON transactions (ip);
+CREATE INDEX transactions_date ON transactions (date);
+
+CREATE TABLE transaction_products (
+	transaction_id INTEGER NOT NULL REFERENCES transactions (id) ON DELETE CASCADE,
+	position       INTEGER NOT NULL,
+	product_id     TEXT    NOT NULL,
+	PRIMARY KEY (transaction_id, position)
+);
+
+CREATE INDEX transaction_products_product_id ON transaction_products (product_id);
@@ -0,0 +1,8 @@
+-- The date whose load introduced each buyer and product, so that purging
+-- a date can also delete the buyers and products only that date brought
+-- in. Rows loaded before this migration have no date and are never purged.
+ALTER TABLE buyers ADD COLUMN date TEXT;
+ALTER TABLE products ADD COLUMN date TEXT;
+
+CREATE INDEX buyers_date ON buyers (date);
+CREATE INDEX products_date ON products (date);
@@ -0,0 +1,10 @@
+-- Transactions are upserted on their transaction_id, which needs it to be
+-- unique. Copies left by loads made before this migration are dropped,
+-- keeping the first one saved; their products go with them through
+-- ON DELETE CASCADE.
+DELETE FROM transactions WHERE id NOT IN (
+	SELECT MIN(id) FROM transactions GROUP BY transaction_id
+);
+
+DROP INDEX transactions_transaction_id;
+CREATE UNIQUE INDEX transactions_transaction_id ON transactions (transaction_id);
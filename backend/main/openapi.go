@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	d "github.com/shopspring/decimal"
+)
+
+/*
+	Endpoint of the API. The routes are the single source for the
+	router, GET / and the OpenAPI document, so every route must be
+	documented: a route without a description or responses, or
+	with an undeclared path parameter, keeps the server from starting.
+*/
+type route struct {
+	Method      string
+	Pattern     string
+	Description string
+	Middlewares []func(http.Handler) http.Handler
+	Handler     http.HandlerFunc
+	Params      []routeParam
+	Body        *routeBody
+	Responses   []routeResponse
+}
+
+type routeParam struct {
+	Name        string
+	In          string
+	Type        string
+	Description string
+	Required    bool
+}
+
+/*
+	Request body of a route. Value is a value of the type the body
+	is unmarshalled to, to generate its schema.
+*/
+type routeBody struct {
+	Description string
+	Value       interface{}
+}
+
+/*
+	Response of a route. Value is a value of the type marshalled to
+	the body, or nil when the response has none.
+*/
+type routeResponse struct {
+	Status      int
+	Description string
+	ContentType string
+	Value       interface{}
+}
+
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `json:"schemas"`
+}
+
+type openAPIOperation struct {
+	Description string                      `json:"description"`
+	Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Description string                      `json:"description,omitempty"`
+	Required    bool                        `json:"required"`
+	Content     map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+}
+
+var pathParamPattern = regexp.MustCompile(`{([^}:]+)[^}]*}`)
+
+func pathParam(name string, description string) routeParam {
+	return routeParam{Name: name, In: "path", Type: "string", Description: description, Required: true}
+}
+
+func queryParam(name string, paramType string, description string, required bool) routeParam {
+	return routeParam{Name: name, In: "query", Type: paramType, Description: description, Required: required}
+}
+
+func jsonResponse(status int, description string, value interface{}) routeResponse {
+	return routeResponse{Status: status, Description: description, ContentType: "application/json", Value: value}
+}
+
+/*
+	Response written with writeError, whose body is a Problem.
+*/
+func errorResponse(status int, description string) routeResponse {
+	return routeResponse{Status: status, Description: description, ContentType: "application/problem+json", Value: Problem{}}
+}
+
+/*
+	Registers @routes on @router, each with its own middlewares.
+*/
+func registerRoutes(router chi.Router, routes []route) {
+	for _, route := range routes {
+		router.With(route.Middlewares...).Method(route.Method, route.Pattern, route.Handler)
+	}
+}
+
+/*
+	Generates the OpenAPI 3 document of @routes. The schemas of the
+	bodies are generated from the types of their values.
+*/
+func newOpenAPIDocument(routes []route) (*openAPIDocument, error) {
+	generator := &schemaGenerator{schemas: map[string]*openAPISchema{}}
+	document := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:   "Restaurant API",
+			Version: "1.0.0",
+		},
+		Paths:      map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{Schemas: generator.schemas},
+	}
+
+	for _, route := range routes {
+		err := checkRouteDocumented(route)
+		if err != nil {
+			return nil, err
+		}
+
+		operation := &openAPIOperation{
+			Description: route.Description,
+			Responses:   map[string]*openAPIResponse{},
+		}
+
+		for _, param := range route.Params {
+			operation.Parameters = append(operation.Parameters, openAPIParameter{
+				Name:        param.Name,
+				In:          param.In,
+				Description: param.Description,
+				Required:    param.Required,
+				Schema:      &openAPISchema{Type: param.Type},
+			})
+		}
+
+		if route.Body != nil {
+			operation.RequestBody = &openAPIRequestBody{
+				Description: route.Body.Description,
+				Required:    true,
+				Content: map[string]openAPIMediaType{
+					"application/json": {Schema: generator.schemaOf(reflect.TypeOf(route.Body.Value))},
+				},
+			}
+		}
+
+		for _, response := range route.Responses {
+			openAPIResponse := &openAPIResponse{Description: response.Description}
+			if response.Value != nil {
+				openAPIResponse.Content = map[string]openAPIMediaType{
+					response.ContentType: {Schema: generator.schemaOf(reflect.TypeOf(response.Value))},
+				}
+			}
+
+			operation.Responses[strconv.Itoa(response.Status)] = openAPIResponse
+		}
+
+		path := document.Paths[route.Pattern]
+		if path == nil {
+			path = map[string]*openAPIOperation{}
+			document.Paths[route.Pattern] = path
+		}
+		path[strings.ToLower(route.Method)] = operation
+	}
+
+	return document, nil
+}
+
+func checkRouteDocumented(route route) error {
+	if route.Description == "" {
+		return fmt.Errorf("route %s %s has no description", route.Method, route.Pattern)
+	}
+
+	if len(route.Responses) == 0 {
+		return fmt.Errorf("route %s %s has no documented responses", route.Method, route.Pattern)
+	}
+
+	for _, match := range pathParamPattern.FindAllStringSubmatch(route.Pattern, -1) {
+		declared := false
+		for _, param := range route.Params {
+			if param.In == "path" && param.Name == match[1] {
+				declared = true
+			}
+		}
+
+		if !declared {
+			return fmt.Errorf("route %s %s doesn't document its path parameter '%s'", route.Method, route.Pattern, match[1])
+		}
+	}
+
+	return nil
+}
+
+/*
+	Generates schemas from Go types the way encoding/json marshals
+	them. Named structs are added to the components once and
+	referenced from then on.
+*/
+type schemaGenerator struct {
+	schemas map[string]*openAPISchema
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	decimalType    = reflect.TypeOf(d.Decimal{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (generator *schemaGenerator) schemaOf(t reflect.Type) *openAPISchema {
+	if t == nil {
+		return &openAPISchema{}
+	}
+
+	switch t {
+	case timeType:
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case decimalType:
+		return &openAPISchema{Type: "string", Format: "decimal"}
+	case rawMessageType:
+		return &openAPISchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := generator.schemaOf(t.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &openAPISchema{Type: "array", Items: generator.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: generator.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return generator.structSchema(t)
+		}
+
+		_, generated := generator.schemas[t.Name()]
+		if !generated {
+			// Added before generating the fields, in case the type references itself
+			generator.schemas[t.Name()] = &openAPISchema{}
+			*generator.schemas[t.Name()] = *generator.structSchema(t)
+		}
+
+		return &openAPISchema{Ref: "#/components/schemas/" + t.Name()}
+	default:
+		return &openAPISchema{}
+	}
+}
+
+func (generator *schemaGenerator) structSchema(t reflect.Type) *openAPISchema {
+	schema := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+	generator.addFields(schema, t)
+	sort.Strings(schema.Required)
+
+	return schema
+}
+
+/*
+	Adds the fields of the struct type @t to @schema, flattening the
+	embedded structs as encoding/json does.
+*/
+func (generator *schemaGenerator) addFields(schema *openAPISchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options := parseJsonTag(field.Tag.Get("json"))
+		if name == "-" && options == "" {
+			continue
+		}
+
+		fieldType := field.Type
+		if field.Anonymous && name == "" {
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				generator.addFields(schema, fieldType)
+				continue
+			}
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		schema.Properties[name] = generator.schemaOf(fieldType)
+		if !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+func parseJsonTag(tag string) (string, string) {
+	parts := strings.SplitN(tag, ",", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+/*
+	Returns the router the server runs, over @store.
+*/
+func newTestRouter(t *testing.T, store Store) chi.Router {
+	t.Helper()
+
+	return newSchedulerTestRouter(t, newTestSyncScheduler(t, store))
+}
+
+/*
+	Returns the router the server runs, with the service and jobs of
+	@scheduler.
+*/
+func newSchedulerTestRouter(t *testing.T, scheduler *SyncScheduler) chi.Router {
+	t.Helper()
+
+	controller := &RestaurantController{
+		service:   scheduler.service,
+		jobs:      scheduler.jobs,
+		upstream:  newUpstreamClient(),
+		scheduler: scheduler,
+	}
+
+	graphqlHandler, err := newGraphqlHandler(scheduler.service)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := newRouter(controller, graphqlHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return router
+}
+
+/*
+	Returns the names of the path parameters of @pattern.
+*/
+func pathParamNames(pattern string) []string {
+	var names []string
+	for _, match := range pathParamPattern.FindAllStringSubmatch(pattern, -1) {
+		names = append(names, match[1])
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+/*
+	Every route the server serves has to be in the document served at
+	/openapi.json, with its path parameters, and every operation of
+	the document has to be served.
+*/
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	router := newTestRouter(t, newMemoryStore())
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json responded %d", recorder.Code)
+	}
+
+	var document openAPIDocument
+	err := json.Unmarshal(recorder.Body.Bytes(), &document)
+	if err != nil {
+		t.Fatalf("invalid OpenAPI document | %v", err)
+	}
+
+	served := map[string]bool{}
+	err = chi.Walk(router, func(method string, pattern string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		// Documented paths have no regular expressions in their parameters
+		path := pathParamPattern.ReplaceAllString(pattern, "{$1}")
+		served[method+" "+path] = true
+
+		operation := document.Paths[path][strings.ToLower(method)]
+		if operation == nil {
+			t.Errorf("%s %s is served but not documented", method, pattern)
+			return nil
+		}
+
+		var documented []string
+		for _, param := range operation.Parameters {
+			if param.In == "path" {
+				documented = append(documented, param.Name)
+			}
+		}
+		sort.Strings(documented)
+
+		if strings.Join(documented, ",") != strings.Join(pathParamNames(pattern), ",") {
+			t.Errorf("%s %s has path parameters %v, documented %v", method, pattern, pathParamNames(pattern), documented)
+		}
+		if operation.Description == "" || len(operation.Responses) == 0 {
+			t.Errorf("%s %s has no description or responses", method, pattern)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, operations := range document.Paths {
+		for method := range operations {
+			if !served[strings.ToUpper(method)+" "+path] {
+				t.Errorf("%s %s is documented but not served", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
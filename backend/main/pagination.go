@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/base64"
+	c "module/constants"
+	"strconv"
+)
+
+/*
+	Page of a collection: at most First items, starting after the item
+	whose id is After, or at the start of the collection if After is
+	empty. The collections are ordered by the id of their items, so
+	that a page stays the same while items are added.
+*/
+type PageRequest struct {
+	First int
+	After string
+}
+
+/*
+	EndCursor is the cursor of the last item of the page, to be passed
+	as 'after' to get the next one.
+*/
+type PageInfo struct {
+	EndCursor   string `json:",omitempty"`
+	HasNextPage bool
+}
+
+/*
+	Returns the page requested with the 'first' and 'after' parameters.
+	'first' defaults to c.DefaultPageSize and can't be greater than
+	c.MaxPageSize, and 'after' must be a cursor returned in a PageInfo.
+*/
+func newPageRequest(firstParam string, afterParam string) (PageRequest, error) {
+	page := PageRequest{First: c.DefaultPageSize}
+
+	if firstParam != "" {
+		first, err := strconv.Atoi(firstParam)
+		if err != nil || first <= 0 || first > c.MaxPageSize {
+			return PageRequest{}, errInvalidPage
+		}
+
+		page.First = first
+	}
+
+	if afterParam != "" {
+		after, err := decodeCursor(afterParam)
+		if err != nil {
+			return PageRequest{}, errInvalidPage
+		}
+
+		page.After = after
+	}
+
+	return page, nil
+}
+
+/*
+	Returns the bounds of @page in a whole collection whose items have
+	the sorted @ids, including one item more than requested, for the
+	stores that page their results instead of their queries.
+*/
+func pageOf(ids []string, page PageRequest) (int, int) {
+	start := 0
+	if page.After != "" {
+		for start < len(ids) && ids[start] <= page.After {
+			start++
+		}
+	}
+
+	end := start + page.First + 1
+	if end > len(ids) {
+		end = len(ids)
+	}
+
+	return start, end
+}
+
+/*
+	Number of items to fetch for @page: one more than requested, to
+	know whether there's a next page.
+*/
+func (page PageRequest) limit() int {
+	return page.First + 1
+}
+
+/*
+	Returns the collection of the @buyers fetched for @page, with up
+	to page.limit() of them, out of @totalCount buyers.
+*/
+func newBuyerCollection(buyers []Buyer, totalCount int, page PageRequest) BuyerCollection {
+	if buyers == nil {
+		buyers = []Buyer{}
+	}
+
+	collection := BuyerCollection{Buyers: buyers, TotalCount: totalCount}
+	if len(buyers) > page.First {
+		collection.Buyers = buyers[:page.First]
+		collection.PageInfo.HasNextPage = true
+	}
+
+	if len(collection.Buyers) > 0 {
+		collection.PageInfo.EndCursor = encodeCursor(collection.Buyers[len(collection.Buyers)-1].BuyerId)
+	}
+
+	return collection
+}
+
+/*
+	Returns the collection of the @transactions fetched for @page, as
+	newBuyerCollection does.
+*/
+func newTransactionCollection(transactions []Transaction, totalCount int, page PageRequest) TransactionCollection {
+	if transactions == nil {
+		transactions = []Transaction{}
+	}
+
+	collection := TransactionCollection{Transactions: transactions, TotalCount: totalCount}
+	if len(transactions) > page.First {
+		collection.Transactions = transactions[:page.First]
+		collection.PageInfo.HasNextPage = true
+	}
+
+	if len(collection.Transactions) > 0 {
+		collection.PageInfo.EndCursor = encodeCursor(collection.Transactions[len(collection.Transactions)-1].TransactionId)
+	}
+
+	return collection
+}
+
+/*
+	Cursors are opaque to the clients, which mustn't build them.
+*/
+func encodeCursor(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	id, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	return string(id), nil
+}
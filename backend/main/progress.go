@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	ProductsStage     string = "products"
+	BuyersStage       string = "buyers"
+	TransactionsStage string = "transactions"
+	PurgeStage        string = "purge"
+	CommitStage       string = "commit"
+	JobStage          string = "job"
+)
+
+const (
+	FetchedStep      string = "fetched"
+	ParsedStep       string = "parsed"
+	DeduplicatedStep string = "deduplicated"
+	PersistedStep    string = "persisted"
+	PurgedStep       string = "purged"
+	CommittedStep    string = "committed"
+	FailedStep       string = "failed"
+)
+
+/*
+	Reports how far a data load has gotten. Count is the number of
+	records the stage handled in that step, e.g. the number of
+	products left after deduplication. Job events carry the job state
+	as their step.
+*/
+type ProgressEvent struct {
+	JobId string
+	Date  string `json:",omitempty"`
+	Stage string
+	Step  string
+	Count int    `json:",omitempty"`
+	Error string `json:",omitempty"`
+	Time  time.Time
+}
+
+type progressFunc func(event ProgressEvent)
+
+/*
+	Fans out the progress events of each job to the clients
+	following it.
+*/
+type progressBroker struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[chan ProgressEvent]bool
+}
+
+func newProgressBroker() *progressBroker {
+	return &progressBroker{subscribers: map[string]map[chan ProgressEvent]bool{}}
+}
+
+/*
+	Returns a channel receiving the events of @jobId, which is closed
+	when the job finishes, and a function to stop receiving them.
+*/
+func (broker *progressBroker) subscribe(jobId string) (<-chan ProgressEvent, func()) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	events := make(chan ProgressEvent, 64)
+	if broker.subscribers[jobId] == nil {
+		broker.subscribers[jobId] = map[chan ProgressEvent]bool{}
+	}
+	broker.subscribers[jobId][events] = true
+
+	unsubscribe := func() {
+		broker.mutex.Lock()
+		defer broker.mutex.Unlock()
+
+		if broker.subscribers[jobId][events] {
+			delete(broker.subscribers[jobId], events)
+			close(events)
+		}
+		// The job may have finished before the subscription, or not exist
+		if len(broker.subscribers[jobId]) == 0 {
+			delete(broker.subscribers, jobId)
+		}
+	}
+
+	return events, unsubscribe
+}
+
+/*
+	Sends @event to the subscribers of its job. Events are dropped for
+	subscribers that aren't keeping up, so a slow client never slows
+	the load down.
+*/
+func (broker *progressBroker) publish(event ProgressEvent) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	for events := range broker.subscribers[event.JobId] {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+/*
+	Closes the channels of every subscriber of @jobId.
+*/
+func (broker *progressBroker) finish(jobId string) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	for events := range broker.subscribers[jobId] {
+		close(events)
+	}
+	delete(broker.subscribers, jobId)
+}
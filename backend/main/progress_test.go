@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressBroker(t *testing.T) {
+	broker := newProgressBroker()
+	events, unsubscribe := broker.subscribe("job1")
+	defer unsubscribe()
+	other, unsubscribeOther := broker.subscribe("job2")
+	defer unsubscribeOther()
+
+	steps := []string{FetchedStep, ParsedStep, PersistedStep}
+	for _, step := range steps {
+		broker.publish(ProgressEvent{JobId: "job1", Stage: BuyersStage, Step: step})
+	}
+	broker.finish("job1")
+
+	var received []string
+	for event := range events {
+		received = append(received, event.Step)
+	}
+	if strings.Join(received, ",") != strings.Join(steps, ",") {
+		t.Errorf("got steps %v, want %v", received, steps)
+	}
+
+	select {
+	case event := <-other:
+		t.Errorf("got event %+v of another job", event)
+	default:
+	}
+
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if _, ok := broker.subscribers["job1"]; ok {
+		t.Errorf("the subscribers of a finished job are kept")
+	}
+}
+
+/*
+	Subscribing to a job that already finished, or doesn't exist,
+	leaves nothing behind once the subscriber is gone.
+*/
+func TestProgressBrokerSubscribeToFinishedJob(t *testing.T) {
+	broker := newProgressBroker()
+	broker.finish("job1")
+
+	events, unsubscribe := broker.subscribe("job1")
+	unsubscribe()
+	unsubscribe()
+
+	if _, open := <-events; open {
+		t.Errorf("the channel is open after unsubscribing")
+	}
+	if len(broker.subscribers) != 0 {
+		t.Errorf("got subscribers %v after unsubscribing, want none", broker.subscribers)
+	}
+}
+
+/*
+	Reads the events of the stream at @url until the "done" event,
+	returning their names and the data of the last one.
+*/
+func readTestEvents(url string) ([]string, []byte, error) {
+	response, err := http.Get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		return nil, nil, fmt.Errorf("got Content-Type %q, want text/event-stream", contentType)
+	}
+
+	var names []string
+	var data []byte
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event: ") {
+			names = append(names, strings.TrimPrefix(line, "event: "))
+		}
+		if strings.HasPrefix(line, "data: ") {
+			data = []byte(strings.TrimPrefix(line, "data: "))
+		}
+		if line == "" && len(names) > 0 && names[len(names)-1] == "done" {
+			break
+		}
+	}
+
+	return names, data, scanner.Err()
+}
+
+/*
+	Waits until @broker has @count subscribers of @jobId, failing the
+	test if it doesn't within a few seconds.
+*/
+func waitForSubscribers(t *testing.T, broker *progressBroker, jobId string, count int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		broker.mutex.Lock()
+		subscribers := len(broker.subscribers[jobId])
+		broker.mutex.Unlock()
+
+		if subscribers == count {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d subscribers of %s, want %d", subscribers, jobId, count)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJobEventsStream(t *testing.T) {
+	scheduler := newTestSyncScheduler(t, newMemoryStore())
+	source := newBlockingDataSource()
+	scheduler.service.source = source
+
+	server := httptest.NewServer(newSchedulerTestRouter(t, scheduler))
+	defer server.Close()
+
+	job, err := scheduler.jobs.submitDateJob(testDate, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type stream struct {
+		names []string
+		data  []byte
+		err   error
+	}
+	streamed := make(chan stream)
+	go func() {
+		names, data, err := readTestEvents(server.URL + "/jobs/" + job.Id + "/events")
+		streamed <- stream{names, data, err}
+	}()
+
+	// The load can't finish before the client is following it
+	waitForJobState(t, scheduler.jobs, job.Id, JobRunning)
+	waitForSubscribers(t, scheduler.jobs.progress, job.Id, 1)
+	close(source.release)
+
+	live := <-streamed
+	if live.err != nil {
+		t.Fatal(live.err)
+	}
+	if len(live.names) < 2 || live.names[0] != "progress" || live.names[len(live.names)-1] != "done" {
+		t.Errorf("got events %v, want progress events and then done", live.names)
+	}
+
+	var done Job
+	err = json.Unmarshal(live.data, &done)
+	if err != nil || done.State != JobSucceeded {
+		t.Errorf("got done event %s (%v), want the succeeded job", live.data, err)
+	}
+
+	// A finished job streams only its final state
+	names, _, err := readTestEvents(server.URL + "/jobs/" + job.Id + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "done" {
+		t.Errorf("got events %v for a finished job, want only done", names)
+	}
+
+	waitForSubscribers(t, scheduler.jobs.progress, job.Id, 0)
+	scheduler.jobs.progress.mutex.Lock()
+	defer scheduler.jobs.progress.mutex.Unlock()
+	if _, ok := scheduler.jobs.progress.subscribers[job.Id]; ok {
+		t.Errorf("the subscribers of %s are kept after the streams ended", job.Id)
+	}
+}
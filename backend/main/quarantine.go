@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	c "module/constants"
+	p "module/productfeed"
+	t "module/transactionfeed"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errRecordNotFound = newNotFoundError(RecordNotFoundCode, "quarantined record not found")
+
+/*
+	Record of a feed that was left out of a load, kept as it came in
+	the feed so that it can be fixed and imported again. Transaction
+	records keep their NUL separators.
+*/
+type QuarantinedRecord struct {
+	Id        string
+	Date      string
+	Feed      string
+	Record    string
+	Issues    []ValidationIssue
+	CreatedAt time.Time
+	UpdatedAt *time.Time `json:",omitempty"`
+}
+
+type QuarantineRepository interface {
+	SaveRecords(records []QuarantinedRecord) error
+	// Returns errRecordNotFound if there's no record with @id.
+	FindRecord(id string) (QuarantinedRecord, error)
+	// Returns the records of @date, or all of them if @date is empty.
+	FindRecords(date string) ([]QuarantinedRecord, error)
+	DeleteRecord(id string) error
+	// Deletes the records of @date, returning how many were deleted.
+	DeleteRecordsOfDate(date string) (int, error)
+}
+
+/*
+	Keeps each quarantined record as a JSON file, in a subdirectory
+	per date.
+*/
+type fileQuarantineRepository struct {
+	dir   string
+	mutex sync.RWMutex
+}
+
+func newFileQuarantineRepository(dir string) (*fileQuarantineRepository, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating quarantine directory '%s' | %w", dir, err)
+	}
+
+	return &fileQuarantineRepository{dir: dir}, nil
+}
+
+func (repository *fileQuarantineRepository) SaveRecords(records []QuarantinedRecord) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	for _, record := range records {
+		err := os.MkdirAll(filepath.Join(repository.dir, record.Date), 0755)
+		if err != nil {
+			return err
+		}
+
+		jsonRecord, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(repository.dir, record.Date, record.Id+".json")
+		err = os.WriteFile(path+".tmp", jsonRecord, 0644)
+		if err != nil {
+			return fmt.Errorf("error while saving quarantined record '%s' | %w", record.Id, err)
+		}
+
+		err = os.Rename(path+".tmp", path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (repository *fileQuarantineRepository) FindRecord(id string) (QuarantinedRecord, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+
+	path, err := repository.recordPath(id)
+	if err != nil {
+		return QuarantinedRecord{}, err
+	}
+
+	return repository.readRecord(path)
+}
+
+func (repository *fileQuarantineRepository) FindRecords(date string) ([]QuarantinedRecord, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+
+	dateDir := "*"
+	if date != "" {
+		dateDir = date
+	}
+
+	paths, err := filepath.Glob(filepath.Join(repository.dir, dateDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	records := []QuarantinedRecord{}
+	for _, path := range paths {
+		record, err := repository.readRecord(path)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Date != records[j].Date {
+			return records[i].Date < records[j].Date
+		}
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	return records, nil
+}
+
+func (repository *fileQuarantineRepository) DeleteRecord(id string) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	path, err := repository.recordPath(id)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func (repository *fileQuarantineRepository) DeleteRecordsOfDate(date string) (int, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(repository.dir, date, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.RemoveAll(filepath.Join(repository.dir, date))
+	if err != nil {
+		return 0, fmt.Errorf("error while deleting quarantined records of '%s' | %w", date, err)
+	}
+
+	return len(paths), nil
+}
+
+/*
+	Returns the path of the file of the record @id, which can be in
+	the directory of any date.
+*/
+func (repository *fileQuarantineRepository) recordPath(id string) (string, error) {
+	paths, err := filepath.Glob(filepath.Join(repository.dir, "*", id+".json"))
+	if err != nil {
+		return "", err
+	}
+
+	if len(paths) == 0 {
+		return "", errRecordNotFound
+	}
+
+	return paths[0], nil
+}
+
+func (repository *fileQuarantineRepository) readRecord(path string) (QuarantinedRecord, error) {
+	jsonRecord, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return QuarantinedRecord{}, errRecordNotFound
+	}
+	if err != nil {
+		return QuarantinedRecord{}, fmt.Errorf("error while reading quarantined record '%s' | %w", path, err)
+	}
+
+	var record QuarantinedRecord
+	err = json.Unmarshal(jsonRecord, &record)
+	if err != nil {
+		return QuarantinedRecord{}, fmt.Errorf("error while unmarshalling quarantined record '%s' | %w", path, err)
+	}
+
+	return record, nil
+}
+
+func (service *RestaurantService) findQuarantinedRecords(date string) ([]QuarantinedRecord, error) {
+	return service.quarantine.FindRecords(date)
+}
+
+func (service *RestaurantService) findQuarantinedRecord(id string) (QuarantinedRecord, error) {
+	return service.quarantine.FindRecord(id)
+}
+
+/*
+	Replaces the content of the quarantined record @id with @content,
+	which must be in the format of the record's feed.
+*/
+func (service *RestaurantService) fixQuarantinedRecord(id string, content string) (QuarantinedRecord, error) {
+	record, err := service.quarantine.FindRecord(id)
+	if err != nil {
+		return QuarantinedRecord{}, err
+	}
+
+	updatedAt := time.Now().UTC()
+	record.Record = content
+	record.UpdatedAt = &updatedAt
+
+	err = service.quarantine.SaveRecords([]QuarantinedRecord{record})
+	if err != nil {
+		return QuarantinedRecord{}, err
+	}
+
+	return record, nil
+}
+
+func (service *RestaurantService) discardQuarantinedRecord(id string) error {
+	return service.quarantine.DeleteRecord(id)
+}
+
+/*
+	Validates the quarantined record @id again and, if it has no
+	issues anymore, saves it to the store and takes it out of the
+	quarantine. Otherwise the record is updated with its current
+	issues, which are returned.
+*/
+func (service *RestaurantService) importQuarantinedRecord(id string) (QuarantinedRecord, []ValidationIssue, error) {
+	record, err := service.quarantine.FindRecord(id)
+	if err != nil {
+		return QuarantinedRecord{}, nil, err
+	}
+
+	txn, err := service.store.NewTxn()
+	if err != nil {
+		return QuarantinedRecord{}, nil, fmt.Errorf("error while importing quarantined record '%s' | %w", record.Id, err)
+	}
+	defer txn.Discard()
+
+	var issues []ValidationIssue
+	switch record.Feed {
+	case ProductsFeed:
+		issues, err = service.importProduct(txn, record)
+	case TransactionsFeed:
+		issues, err = service.importTransaction(txn, record)
+	default:
+		err = fmt.Errorf("unknown feed '%s' of quarantined record '%s'", record.Feed, record.Id)
+	}
+	if err != nil {
+		return QuarantinedRecord{}, nil, err
+	}
+
+	if len(issues) > 0 {
+		updatedAt := time.Now().UTC()
+		record.Issues = issues
+		record.UpdatedAt = &updatedAt
+
+		err = service.quarantine.SaveRecords([]QuarantinedRecord{record})
+		if err != nil {
+			return QuarantinedRecord{}, nil, err
+		}
+
+		return record, issues, nil
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return QuarantinedRecord{}, nil, fmt.Errorf("error while committing quarantined record '%s' | %w", record.Id, err)
+	}
+
+	err = service.quarantine.DeleteRecord(record.Id)
+	if err != nil {
+		return QuarantinedRecord{}, nil, err
+	}
+
+	record.Issues = nil
+	return record, nil, nil
+}
+
+/*
+	Saves the product of @record unless it's already in the store.
+*/
+func (service *RestaurantService) importProduct(txn Txn, record QuarantinedRecord) ([]ValidationIssue, error) {
+	product, err := p.ParseLine(record.Record, service.productFeedMode)
+	if err != nil {
+		return []ValidationIssue{{Feed: ProductsFeed, Code: MalformedLineIssue, Reason: err.Error()}}, nil
+	}
+
+	_, err = service.store.Products().SaveProducts(txn, []Product{{
+		ProductId: product.Id,
+		Name:      product.Name,
+		Price:     product.Price,
+		Date:      record.Date,
+		Type:      c.ProductType,
+	}})
+	return nil, err
+}
+
+/*
+	Saves the transaction of @record if its buyer and products are
+	in the store and its fields are valid. The record must hold a
+	single transaction. Duplicates are only looked for within a load,
+	so they aren't checked here.
+*/
+func (service *RestaurantService) importTransaction(txn Txn, record QuarantinedRecord) ([]ValidationIssue, error) {
+	decoder := t.NewDecoder(strings.NewReader(record.Record))
+	decoded, err := decoder.Next()
+
+	var recordErr *t.RecordError
+	if errors.As(err, &recordErr) {
+		return []ValidationIssue{{Feed: TransactionsFeed, Code: MalformedRecordIssue, Reason: recordErr.Reason}}, nil
+	}
+	if err != nil {
+		return []ValidationIssue{{Feed: TransactionsFeed, Code: MalformedRecordIssue, Reason: "empty record"}}, nil
+	}
+
+	_, err = decoder.Next()
+	if err != io.EOF {
+		return []ValidationIssue{{Feed: TransactionsFeed, Code: MalformedRecordIssue, Reason: "more than one record"}}, nil
+	}
+
+	transaction := Transaction{
+		TransactionId: decoded.Id,
+		BuyerId:       decoded.BuyerId,
+		Ip:            decoded.Ip,
+		Device:        decoded.Device,
+		Products:      decoded.Products,
+		Date:          record.Date,
+		Type:          c.TransactionType,
+	}
+
+	validator := newTransactionValidator(
+		func(buyerId string) (bool, error) {
+			buyers, err := service.store.Buyers().FindBuyersByIds([]string{buyerId}, "", PageRequest{First: 1})
+			return len(buyers.Buyers) > 0, err
+		},
+		func(productId string) (bool, error) {
+			products, err := service.store.Products().FindProductsByIds([]string{productId})
+			return len(products) > 0, err
+		},
+	)
+
+	issues, err := validator.validate(transaction)
+	if err != nil || len(issues) > 0 {
+		for i := range issues {
+			issues[i].Feed = TransactionsFeed
+		}
+		return issues, err
+	}
+
+	_, err = service.store.Transactions().SaveTransactions(txn, []Transaction{transaction})
+	return nil, err
+}
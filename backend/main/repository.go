@@ -0,0 +1,77 @@
+package main
+
+/*
+	Distinct IPs and products of the transactions of a buyer.
+*/
+type BuyerActivity struct {
+	Ips        []string
+	ProductIds []string
+}
+
+/*
+	Groups the reads and writes performed while loading the data
+	of a date so that they are committed or discarded together.
+*/
+type Txn interface {
+	Commit() error
+	Discard()
+}
+
+type BuyerRepository interface {
+	// Returns a page of all the buyers, ordered by BuyerId.
+	FindBuyers(page PageRequest) (BuyerCollection, error)
+	// Returns a page of the buyers in @buyerIds, leaving out @excludedBuyerId, ordered by BuyerId.
+	FindBuyersByIds(buyerIds []string, excludedBuyerId string, page PageRequest) (BuyerCollection, error)
+	// Returns a page of the distinct buyers, other than @excludedBuyerId, with transactions from any of @ips, ordered by BuyerId.
+	FindBuyersByIps(ips []string, excludedBuyerId string, page PageRequest) (BuyerCollection, error)
+	// Returns errBuyerNotFound if there's no buyer with @buyerId.
+	FindBuyerName(buyerId string) (string, error)
+	// Saves the buyers whose BuyerId isn't saved yet, returning them.
+	SaveBuyers(txn Txn, buyers []Buyer) ([]Buyer, error)
+	// Deletes the buyers loaded with @date that no transaction references, returning how many were deleted.
+	DeleteOrphanBuyers(txn Txn, date string) (int, error)
+}
+
+type ProductRepository interface {
+	FindProductsByIds(productIds []string) ([]Product, error)
+	// Saves the products whose ProductId isn't saved yet, returning them.
+	SaveProducts(txn Txn, products []Product) ([]Product, error)
+	// Deletes the products loaded with @date that no transaction contains, returning how many were deleted.
+	DeleteOrphanProducts(txn Txn, date string) (int, error)
+}
+
+type TransactionRepository interface {
+	// Returns a page of the transactions of @buyerId, ordered by TransactionId.
+	FindTransactionHistoryPage(buyerId string, page PageRequest) (TransactionCollection, error)
+	// Returns the distinct IPs and product ids of the transactions of @buyerId.
+	FindBuyerActivity(buyerId string) (BuyerActivity, error)
+	// Returns at most @first transactions containing any of the products in @productIds.
+	FindTransactionsWithProducts(productIds []string, first int) ([]Transaction, error)
+	// Reports whether data for @date, in yyyy-MM-DD format, has already been loaded.
+	IsDateSynchronized(txn Txn, date string) (bool, error)
+	// Returns the most recent date, in yyyy-MM-DD format, with loaded transactions, or "" if there's none.
+	FindLatestSynchronizedDate(txn Txn) (string, error)
+	// Saves the transactions whose TransactionId isn't saved yet, returning how many were saved.
+	SaveTransactions(txn Txn, transactions []Transaction) (int, error)
+	// Deletes the transactions of @date, returning how many were deleted.
+	DeleteTransactionsOfDate(txn Txn, date string) (int, error)
+}
+
+/*
+	Implemented by the stores that can't take c.MaxConcurrentLoads
+	loads writing at the same time.
+*/
+type LoadLimiter interface {
+	// Returns how many loads can write to the store at the same time.
+	MaxConcurrentLoads() int
+}
+
+/*
+	Storage backend used by the service layer and the DataLoader.
+*/
+type Store interface {
+	Buyers() BuyerRepository
+	Products() ProductRepository
+	Transactions() TransactionRepository
+	NewTxn() (Txn, error)
+}
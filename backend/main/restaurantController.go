@@ -14,24 +14,44 @@ import (
 const (
 	buyerIdKey     key = "buyerId"
 	dateKey        key = "date"
+	fromKey        key = "from"
+	toKey          key = "to"
+	forceKey       key = "force"
+	dryRunKey      key = "dryRun"
 	productsKey    key = "products"
 	pageKey        key = "page"
-	pageSizeKey    key = "pageSize"
-	pageBKey       key = "pageB"
-	pageSizeBKey   key = "pageSizeB"
-	pageTKey       key = "pageT"
-	pageSizeTKey   key = "pageSizeT"
+	firstKey       key = "first"
+	afterKey       key = "after"
+	firstBKey      key = "firstB"
+	afterBKey      key = "afterB"
+	firstTKey      key = "firstT"
+	afterTKey      key = "afterT"
 	buyerParamsKey key = "buyerParams"
+	jobIdKey       key = "jobId"
+	recordIdKey    key = "recordId"
 )
 
+type RestaurantController struct {
+	service   *RestaurantService
+	jobs      *JobManager
+	upstream  *upstreamClient
+	scheduler *SyncScheduler
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writter http.ResponseWriter, request *http.Request) {
 		writter.Header().Set("Access-Control-Allow-Origin", f.GoDotEnvVariable("ALLOWED_ORIGIN"))
 		writter.Header().Set("Access-Control-Allow-Credentials", "true")
-		writter.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
+		writter.Header().Set("Access-Control-Allow-Methods", "POST, GET, PUT, DELETE, OPTIONS")
 		writter.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 		writter.Header().Set("Content-Type", "application/json")
 
+		//To solve CORS preflight invalid status error
+		if request.Method == http.MethodOptions {
+			writter.WriteHeader(http.StatusOK)
+			return
+		}
+
 		next.ServeHTTP(writter, request)
 	})
 }
@@ -42,86 +62,433 @@ func corsMiddleware(next http.Handler) http.Handler {
 */
 func restaurantCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writter http.ResponseWriter, request *http.Request) {
-		//To solve CORS preflight invalid status error
-		if request.Method == http.MethodOptions {
-			writter.WriteHeader(http.StatusOK)
-			return
-		}
-
 		body, err := io.ReadAll(request.Body)
 		if err != nil {
-			http.Error(writter, "Error while processing request body", http.StatusInternalServerError)
+			writeError(writter, fmt.Errorf("error while reading request body | %w", err))
 			return
 		}
 
 		var requestBody RequestBody
 		err = json.Unmarshal(body, &requestBody)
 		if err != nil {
-			http.Error(writter, "Error while processing request", http.StatusBadRequest)
+			writeError(writter, newValidationError(InvalidRequestCode, "the body isn't valid JSON"))
+			return
+		}
+
+		if requestBody.Date != "" && (requestBody.From != "" || requestBody.To != "") {
+			writeError(writter, newValidationError(InvalidRequestCode, "'date' can't be combined with 'from' and 'to'"))
+			return
+		}
+
+		if requestBody.DryRun && requestBody.Date == "" {
+			writeError(writter, newValidationError(InvalidRequestCode, "'dryRun' requires a 'date'"))
 			return
 		}
 
 		ctx := context.WithValue(request.Context(), dateKey, requestBody.Date)
+		ctx = context.WithValue(ctx, fromKey, requestBody.From)
+		ctx = context.WithValue(ctx, toKey, requestBody.To)
+		ctx = context.WithValue(ctx, forceKey, requestBody.Force)
+		ctx = context.WithValue(ctx, dryRunKey, requestBody.DryRun)
 		next.ServeHTTP(writter, request.WithContext(ctx))
 	})
 }
 
-func loadRestaurantData(writter http.ResponseWriter, request *http.Request) {
+func (controller *RestaurantController) loadRestaurantData(writter http.ResponseWriter, request *http.Request) {
 	requestContext := request.Context()
 	date := requestContext.Value(dateKey).(string)
+	from := requestContext.Value(fromKey).(string)
+	to := requestContext.Value(toKey).(string)
+	force := requestContext.Value(forceKey).(bool)
+	dryRun := requestContext.Value(dryRunKey).(bool)
+
+	if from != "" || to != "" {
+		controller.loadRestaurantDataRange(writter, from, to, force)
+		return
+	}
+
+	if dryRun {
+		controller.dryRunRestaurantData(writter, date, force)
+		return
+	}
+
+	err := controller.service.checkDateLoadable(date, force)
+	if err != nil {
+		writeError(writter, err)
+		return
+	}
+
+	job, err := controller.jobs.submitDateJob(date, force)
+	if err != nil {
+		writeError(writter, newStorageError("error while creating load job", err))
+		return
+	}
+
+	writeAcceptedJob(writter, job)
+}
+
+func (controller *RestaurantController) loadRestaurantDataRange(writter http.ResponseWriter, from string, to string, force bool) {
+	dates, err := getDateRange(from, to)
+	if err != nil {
+		writeError(writter, err)
+		return
+	}
+
+	job, err := controller.jobs.submitRangeJob(from, to, dates, force)
+	if err != nil {
+		writeError(writter, newStorageError("error while creating load job", err))
+		return
+	}
 
-	txn := dgraphClient.NewTxn()
-	defer txn.Discard(ctx)
+	writeAcceptedJob(writter, job)
+}
 
-	dataLoader := &DataLoader{
-		dateStr: date,
-		txn:     txn,
+/*
+	Runs the load of @date synchronously without saving anything,
+	responding with what it would save.
+*/
+func (controller *RestaurantController) dryRunRestaurantData(writter http.ResponseWriter, date string, force bool) {
+	dryRun, err := controller.service.dryRunDate(date, force)
+	if err != nil {
+		writeError(writter, err)
+		return
 	}
 
-	res, errorType, err := startDataLoading(dataLoader)
+	jsonDryRun, err := json.Marshal(dryRun)
 	if err != nil {
-		if errorType == DateError {
-			http.Error(writter, "invalid date", http.StatusBadRequest)
-		} else {
-			http.Error(writter, "error while loading restaurant data", http.StatusInternalServerError)
+		writeError(writter, fmt.Errorf("error while processing response | %w", err))
+		return
+	}
+
+	writter.Write(jsonDryRun)
+}
+
+func purgeCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writter http.ResponseWriter, request *http.Request) {
+		date := chi.URLParam(request, "date")
+
+		err := isDateParamValid(date)
+		if err != nil {
+			writeError(writter, errInvalidDate)
+			return
 		}
+
+		ctx := context.WithValue(request.Context(), dateKey, date)
+		next.ServeHTTP(writter, request.WithContext(ctx))
+	})
+}
+
+func (controller *RestaurantController) purgeRestaurantData(writter http.ResponseWriter, request *http.Request) {
+	date := request.Context().Value(dateKey).(string)
+
+	purged, err := controller.service.purgeDate(date)
+	if err != nil {
+		writeError(writter, err)
 		return
 	}
 
-	writter.WriteHeader(http.StatusCreated)
-	writter.Write(res)
+	jsonPurged, err := json.Marshal(purged)
+	if err != nil {
+		writeError(writter, fmt.Errorf("error while processing response | %w", err))
+		return
+	}
+
+	writter.Write(jsonPurged)
 }
 
-func buyersCtx(next http.Handler) http.Handler {
+/*
+	Responds with 202 and @job, pointing the client to the
+	endpoint where the job can be followed.
+*/
+func writeAcceptedJob(writter http.ResponseWriter, job Job) {
+	jsonJob, err := json.Marshal(job)
+	if err != nil {
+		writeError(writter, fmt.Errorf("error while processing response | %w", err))
+		return
+	}
+
+	writter.Header().Set("Location", "/jobs/"+job.Id)
+	writter.WriteHeader(http.StatusAccepted)
+	writter.Write(jsonJob)
+}
+
+func jobCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writter http.ResponseWriter, request *http.Request) {
-		if request.URL.Path == "/buyer/all" {
-			pageParam := request.URL.Query().Get(string(pageKey))
-			pageSizeParam := request.URL.Query().Get(string(pageSizeKey))
+		jobId := chi.URLParam(request, string(jobIdKey))
+
+		if !isIdParamValid(jobId) {
+			writeError(writter, newValidationError(InvalidIdCode, "invalid jobId"))
+			return
+		}
+
+		ctx := context.WithValue(request.Context(), jobIdKey, jobId)
+		next.ServeHTTP(writter, request.WithContext(ctx))
+	})
+}
+
+func (controller *RestaurantController) getJobs(writter http.ResponseWriter, request *http.Request) {
+	jobs, err := controller.jobs.findJobs()
+	if err != nil {
+		writeError(writter, newStorageError("error while fetching jobs", err))
+		return
+	}
+
+	jsonJobs, err := json.Marshal(jobs)
+	if err != nil {
+		writeError(writter, fmt.Errorf("error while processing response | %w", err))
+		return
+	}
+
+	writter.Write(jsonJobs)
+}
+
+func (controller *RestaurantController) getJob(writter http.ResponseWriter, request *http.Request) {
+	jobId := request.Context().Value(jobIdKey).(string)
+
+	job, err := controller.jobs.findJob(jobId)
+	if err != nil {
+		writeError(writter, newStorageError("error while fetching job", err))
+		return
+	}
+
+	jsonJob, err := json.Marshal(job)
+	if err != nil {
+		writeError(writter, fmt.Errorf("error while processing response | %w", err))
+		return
+	}
+
+	writter.Write(jsonJob)
+}
+
+/*
+	Streams the progress of a job as Server-Sent Events. Each load
+	step is sent as a "progress" event, and the final job as a "done"
+	event right before the stream ends. Finished jobs only get the
+	"done" event.
+*/
+func (controller *RestaurantController) getJobEvents(writter http.ResponseWriter, request *http.Request) {
+	jobId := request.Context().Value(jobIdKey).(string)
+
+	flusher, ok := writter.(http.Flusher)
+	if !ok {
+		writeError(writter, fmt.Errorf("streaming not supported"))
+		return
+	}
+
+	// Subscribe before reading the job so that no event is missed in between
+	events, unsubscribe := controller.jobs.subscribe(jobId)
+	defer unsubscribe()
+
+	job, err := controller.jobs.findJob(jobId)
+	if err != nil {
+		writeError(writter, newStorageError("error while fetching job", err))
+		return
+	}
+
+	writter.Header().Set("Content-Type", "text/event-stream")
+	writter.Header().Set("Cache-Control", "no-cache")
+	writter.Header().Set("Connection", "keep-alive")
+	writter.WriteHeader(http.StatusOK)
+	flusher.Flush()
 
-			page, pageSize, err := validatePageParams(pageParam, pageSizeParam)
-			if err != nil {
-				http.Error(writter, err.Error(), http.StatusBadRequest)
+	if job.State == JobSucceeded || job.State == JobFailed {
+		writeEvent(writter, flusher, "done", job)
+		return
+	}
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case event, open := <-events:
+			if !open {
+				job, err = controller.jobs.findJob(jobId)
+				if err != nil {
+					fmt.Printf("error while fetching job | %v\n", err)
+					return
+				}
+
+				writeEvent(writter, flusher, "done", job)
 				return
 			}
 
-			ctx := context.WithValue(request.Context(), pageKey, page)
-			ctx = context.WithValue(ctx, pageSizeKey, pageSize)
-			next.ServeHTTP(writter, request.WithContext(ctx))
-		} else {
-			next.ServeHTTP(writter, request)
+			writeEvent(writter, flusher, "progress", event)
+		}
+	}
+}
+
+func writeEvent(writter http.ResponseWriter, flusher http.Flusher, name string, data interface{}) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		fmt.Printf("error while marshalling '%s' event | %v\n", name, err)
+		return
+	}
+
+	fmt.Fprintf(writter, "event: %s\ndata: %s\n\n", name, jsonData)
+	flusher.Flush()
+}
+
+func quarantineCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writter http.ResponseWriter, request *http.Request) {
+		recordId := chi.URLParam(request, string(recordIdKey))
+
+		if !isIdParamValid(recordId) {
+			writeError(writter, newValidationError(InvalidIdCode, "invalid recordId"))
+			return
 		}
 
+		ctx := context.WithValue(request.Context(), recordIdKey, recordId)
+		next.ServeHTTP(writter, request.WithContext(ctx))
 	})
 }
 
-func getBuyers(writter http.ResponseWriter, request *http.Request) {
-	ctx := request.Context()
-	page := ctx.Value(pageKey).(int)
-	pageSize := ctx.Value(pageSizeKey).(int)
+func (controller *RestaurantController) getQuarantinedRecords(writter http.ResponseWriter, request *http.Request) {
+	date := request.URL.Query().Get(string(dateKey))
+
+	if date != "" && isDateParamValid(date) != nil {
+		writeError(writter, errInvalidDate)
+		return
+	}
+
+	records, err := controller.service.findQuarantinedRecords(date)
+	if err != nil {
+		writeError(writter, newStorageError("error while fetching quarantined records", err))
+		return
+	}
+
+	writeQuarantineResponse(writter, http.StatusOK, records)
+}
+
+func (controller *RestaurantController) getQuarantinedRecord(writter http.ResponseWriter, request *http.Request) {
+	recordId := request.Context().Value(recordIdKey).(string)
+
+	record, err := controller.service.findQuarantinedRecord(recordId)
+	if !handleQuarantineError(writter, err) {
+		return
+	}
+
+	writeQuarantineResponse(writter, http.StatusOK, record)
+}
+
+func (controller *RestaurantController) fixQuarantinedRecord(writter http.ResponseWriter, request *http.Request) {
+	recordId := request.Context().Value(recordIdKey).(string)
+
+	var requestBody QuarantineFixBody
+	err := json.NewDecoder(request.Body).Decode(&requestBody)
+	if err != nil || requestBody.Record == "" {
+		writeError(writter, newValidationError(InvalidRequestCode, "the body must hold the fixed 'record'"))
+		return
+	}
+
+	record, err := controller.service.fixQuarantinedRecord(recordId, requestBody.Record)
+	if !handleQuarantineError(writter, err) {
+		return
+	}
+
+	writeQuarantineResponse(writter, http.StatusOK, record)
+}
+
+func (controller *RestaurantController) discardQuarantinedRecord(writter http.ResponseWriter, request *http.Request) {
+	recordId := request.Context().Value(recordIdKey).(string)
+
+	err := controller.service.discardQuarantinedRecord(recordId)
+	if !handleQuarantineError(writter, err) {
+		return
+	}
+
+	writter.WriteHeader(http.StatusNoContent)
+}
 
-	res, err := fetchBuyers(page, pageSize)
+/*
+	Imports a quarantined record into the database. If the record
+	still has issues it stays in quarantine, and it's returned with
+	them and a 422 status.
+*/
+func (controller *RestaurantController) importQuarantinedRecord(writter http.ResponseWriter, request *http.Request) {
+	recordId := request.Context().Value(recordIdKey).(string)
+
+	record, issues, err := controller.service.importQuarantinedRecord(recordId)
+	if !handleQuarantineError(writter, err) {
+		return
+	}
+
+	if len(issues) > 0 {
+		writeQuarantineResponse(writter, http.StatusUnprocessableEntity, record)
+		return
+	}
+
+	writeQuarantineResponse(writter, http.StatusOK, record)
+}
+
+/*
+	Writes the response for @err, if any, and reports whether the
+	request can go on.
+*/
+func handleQuarantineError(writter http.ResponseWriter, err error) bool {
+	if err != nil {
+		writeError(writter, newStorageError("error while processing quarantined record", err))
+		return false
+	}
+
+	return true
+}
+
+func writeQuarantineResponse(writter http.ResponseWriter, status int, data interface{}) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		writeError(writter, fmt.Errorf("error while processing response | %w", err))
+		return
+	}
+
+	writter.WriteHeader(status)
+	writter.Write(jsonData)
+}
+
+func buyersCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writter http.ResponseWriter, request *http.Request) {
+		page, err := newPageRequest(request.URL.Query().Get(string(firstKey)), request.URL.Query().Get(string(afterKey)))
+		if err != nil {
+			writeError(writter, err)
+			return
+		}
+
+		ctx := context.WithValue(request.Context(), pageKey, page)
+		next.ServeHTTP(writter, request.WithContext(ctx))
+	})
+}
+
+func (controller *RestaurantController) getSyncHistory(writter http.ResponseWriter, request *http.Request) {
+	runs, err := controller.scheduler.findRuns()
+	if err != nil {
+		writeError(writter, newStorageError("error while fetching sync history", err))
+		return
+	}
+
+	jsonRuns, err := json.Marshal(runs)
+	if err != nil {
+		writeError(writter, fmt.Errorf("error while processing response | %w", err))
+		return
+	}
+
+	writter.Write(jsonRuns)
+}
+
+func (controller *RestaurantController) getUpstreamMetrics(writter http.ResponseWriter, request *http.Request) {
+	jsonMetrics, err := json.Marshal(controller.upstream.findMetrics())
+	if err != nil {
+		writeError(writter, fmt.Errorf("error while processing response | %w", err))
+		return
+	}
+
+	writter.Write(jsonMetrics)
+}
+
+func (controller *RestaurantController) getBuyers(writter http.ResponseWriter, request *http.Request) {
+	page := request.Context().Value(pageKey).(PageRequest)
+
+	res, err := controller.service.fetchBuyers(page)
 	if err != nil {
-		http.Error(writter, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeError(writter, err)
 		return
 	}
 
@@ -132,7 +499,7 @@ func productsCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writter http.ResponseWriter, request *http.Request) {
 		products := request.URL.Query().Get(string(productsKey))
 		if !isProductParamValid(products) {
-			http.Error(writter, "Invalid products", http.StatusBadRequest)
+			writeError(writter, newValidationError(InvalidIdCode, "invalid products"))
 			return
 		}
 
@@ -141,13 +508,13 @@ func productsCtx(next http.Handler) http.Handler {
 	})
 }
 
-func getProducts(writter http.ResponseWriter, request *http.Request) {
+func (controller *RestaurantController) getProducts(writter http.ResponseWriter, request *http.Request) {
 	ctx := request.Context()
 	productIds := ctx.Value(productsKey).(string)
 
-	products, err := fetchProducts(productIds)
+	products, err := controller.service.fetchProducts(productIds)
 	if err != nil {
-		http.Error(writter, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeError(writter, err)
 		return
 	}
 
@@ -159,39 +526,42 @@ func buyerCtx(next http.Handler) http.Handler {
 		buyerId := chi.URLParam(request, string(buyerIdKey))
 
 		if !isBuyerIdParamValid(buyerId) {
-			http.Error(writter, "Invalid buyerId", http.StatusBadRequest)
+			writeError(writter, newValidationError(InvalidIdCode, "invalid buyerId"))
 			return
 		}
 
-		pageBParam := request.URL.Query().Get(string(pageBKey))
-		pageSizeBParam := request.URL.Query().Get(string(pageSizeBKey))
-		pageTParam := request.URL.Query().Get(string(pageTKey))
-		pageSizeTParam := request.URL.Query().Get(string(pageSizeTKey))
+		query := request.URL.Query()
+		transactionsPage, err := newPageRequest(query.Get(string(firstTKey)), query.Get(string(afterTKey)))
+		if err != nil {
+			writeError(writter, err)
+			return
+		}
 
-		buyerReqParams, err := getBuyerRequestParams(pageBParam,
-			pageSizeBParam,
-			pageTParam,
-			pageSizeTParam)
+		buyersPage, err := newPageRequest(query.Get(string(firstBKey)), query.Get(string(afterBKey)))
 		if err != nil {
-			http.Error(writter, "Invalid request parameters", http.StatusBadRequest)
+			writeError(writter, err)
 			return
 		}
 
+		buyerReqParams := BuyerRequestParams{
+			Transactions:     transactionsPage,
+			BuyersWithSameIp: buyersPage,
+		}
+
 		ctx := context.WithValue(request.Context(), buyerIdKey, buyerId)
 		ctx = context.WithValue(ctx, buyerParamsKey, buyerReqParams)
 		next.ServeHTTP(writter, request.WithContext(ctx))
 	})
 }
 
-func getBuyer(writter http.ResponseWriter, request *http.Request) {
+func (controller *RestaurantController) getBuyer(writter http.ResponseWriter, request *http.Request) {
 	ctx := request.Context()
 	buyerId := ctx.Value(buyerIdKey).(string)
 	buyerReqParams := ctx.Value(buyerParamsKey).(BuyerRequestParams)
 
-	buyer, err := fetchBuyer(buyerId, buyerReqParams)
+	buyer, err := controller.service.fetchBuyer(buyerId, buyerReqParams)
 	if err != nil {
-		fmt.Printf("error while fetching buyer | %v\n", err)
-		http.Error(writter, "Error while fetching buyer", http.StatusInternalServerError)
+		writeError(writter, err)
 		return
 	}
 
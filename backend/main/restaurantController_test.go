@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+/*
+	Serves @method @target with the router of the server over a
+	memory store holding the test data, returning the response.
+*/
+func serveTestRequest(t *testing.T, method string, target string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	store := newMemoryStore()
+	saveTestData(t, store)
+
+	recorder := httptest.NewRecorder()
+	newTestRouter(t, store).ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+	return recorder
+}
+
+func decodeProblem(t *testing.T, recorder *httptest.ResponseRecorder) Problem {
+	t.Helper()
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/problem+json" {
+		t.Errorf("got Content-Type %q, want application/problem+json", contentType)
+	}
+
+	var problem Problem
+	err := json.Unmarshal(recorder.Body.Bytes(), &problem)
+	if err != nil {
+		t.Fatalf("invalid problem body %q | %v", recorder.Body.String(), err)
+	}
+
+	return problem
+}
+
+func TestGetBuyer(t *testing.T) {
+	recorder := serveTestRequest(t, http.MethodGet, "/buyer/b1?firstB=1")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var buyer BuyerIdEndpoint
+	err := json.Unmarshal(recorder.Body.Bytes(), &buyer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buyer.Name != "Buyer b1" || buyer.TransactionHistory.TotalCount != 2 {
+		t.Errorf("got buyer %q with %d transactions, want Buyer b1 with 2", buyer.Name, buyer.TransactionHistory.TotalCount)
+	}
+	if !reflect.DeepEqual(buyerIdsOf(buyer.BuyersWithSameIp), []string{"b2"}) || !buyer.BuyersWithSameIp.PageInfo.HasNextPage {
+		t.Errorf("got buyers with the same IP %v, want a first page with b2", buyerIdsOf(buyer.BuyersWithSameIp))
+	}
+}
+
+func TestGetUnknownBuyer(t *testing.T) {
+	recorder := serveTestRequest(t, http.MethodGet, "/buyer/b404")
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("got status %d, want 404: %s", recorder.Code, recorder.Body.String())
+	}
+
+	problem := decodeProblem(t, recorder)
+	if problem.Code != BuyerNotFoundCode || problem.Status != http.StatusNotFound {
+		t.Errorf("got problem %+v, want code %s", problem, BuyerNotFoundCode)
+	}
+}
+
+func TestGetProductsReportsMissingIds(t *testing.T) {
+	recorder := serveTestRequest(t, http.MethodGet, "/products?products=p1,zz9,p2,zz9")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var products ProductsById
+	err := json.Unmarshal(recorder.Body.Bytes(), &products)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(products.Products) != 2 {
+		t.Errorf("got %d products, want p1 and p2", len(products.Products))
+	}
+	if !reflect.DeepEqual(products.Missing, []string{"zz9"}) {
+		t.Errorf("got missing ids %v, want [zz9]", products.Missing)
+	}
+
+	recorder = serveTestRequest(t, http.MethodGet, "/products?products=p1,p2")
+	err = json.Unmarshal(recorder.Body.Bytes(), &products)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if products.Missing == nil || len(products.Missing) != 0 {
+		t.Errorf("got missing ids %v when all are saved, want []", products.Missing)
+	}
+}
+
+/*
+	A load responds 202 with the queued job, which can be followed at
+	its Location until it succeeds.
+*/
+func TestPostRestaurantDataAcceptsJob(t *testing.T) {
+	router := newTestRouter(t, newMemoryStore())
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/restaurant-data", strings.NewReader(`{"date":"`+testDate+`"}`)))
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want 202: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var job Job
+	err := json.Unmarshal(recorder.Body.Bytes(), &job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != JobQueued || job.Date != testDate {
+		t.Errorf("got job %+v, want a queued job of %s", job, testDate)
+	}
+
+	location := recorder.Header().Get("Location")
+	if location != "/jobs/"+job.Id {
+		t.Fatalf("got Location %q, want /jobs/%s", location, job.Id)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.State != JobSucceeded && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+
+		recorder = httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, location, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("GET %s responded %d: %s", location, recorder.Code, recorder.Body.String())
+		}
+
+		err = json.Unmarshal(recorder.Body.Bytes(), &job)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if job.State != JobSucceeded || job.Counts.Transactions != 2 {
+		t.Errorf("got job %+v at its Location, want it succeeded with 2 transactions", job)
+	}
+}
+
+/*
+	Returns the router of the server over a memory store holding the
+	test data, with @records in its quarantine.
+*/
+func newQuarantineTestRouter(t *testing.T, records ...QuarantinedRecord) (chi.Router, Store) {
+	t.Helper()
+
+	store := newMemoryStore()
+	saveTestData(t, store)
+
+	quarantine, err := newFileQuarantineRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = quarantine.SaveRecords(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler := newTestSyncScheduler(t, store)
+	scheduler.service.quarantine = quarantine
+
+	return newSchedulerTestRouter(t, scheduler), store
+}
+
+func serveRouterRequest(router chi.Router, method string, target string, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return recorder
+}
+
+func decodeQuarantinedRecord(t *testing.T, recorder *httptest.ResponseRecorder) QuarantinedRecord {
+	t.Helper()
+
+	var record QuarantinedRecord
+	err := json.Unmarshal(recorder.Body.Bytes(), &record)
+	if err != nil {
+		t.Fatalf("invalid quarantined record %q | %v", recorder.Body.String(), err)
+	}
+
+	return record
+}
+
+func newTestQuarantinedRecord(id string, record string) QuarantinedRecord {
+	return QuarantinedRecord{
+		Id:        id,
+		Date:      testDate,
+		Feed:      TransactionsFeed,
+		Record:    record,
+		Issues:    []ValidationIssue{{Feed: TransactionsFeed, Code: UnknownDeviceIssue}},
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+/*
+	A record that still has issues stays in quarantine with them, and
+	once fixed it's imported and leaves the quarantine.
+*/
+func TestFixAndImportQuarantinedRecord(t *testing.T) {
+	id := "00000000000000a1"
+	router, store := newQuarantineTestRouter(t,
+		newTestQuarantinedRecord(id, "#t9\x00b1\x001.1.1.1\x00toaster\x00(p1)"))
+
+	recorder := serveRouterRequest(router, http.MethodGet, "/quarantine?date="+testDate, "")
+	var records []QuarantinedRecord
+	err := json.Unmarshal(recorder.Body.Bytes(), &records)
+	if err != nil || len(records) != 1 || records[0].Id != id {
+		t.Fatalf("got quarantined records %s (%v), want %s", recorder.Body.String(), err, id)
+	}
+
+	recorder = serveRouterRequest(router, http.MethodPost, "/quarantine/"+id+"/import", "")
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d importing an invalid record, want 422: %s", recorder.Code, recorder.Body.String())
+	}
+	record := decodeQuarantinedRecord(t, recorder)
+	if len(record.Issues) != 1 || record.Issues[0].Code != UnknownDeviceIssue || record.UpdatedAt == nil {
+		t.Errorf("got record %+v, want it updated with its unknown device", record)
+	}
+
+	fixed := "#t9\x00b1\x001.1.1.1\x00linux\x00(p1)"
+	body, err := json.Marshal(QuarantineFixBody{Record: fixed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder = serveRouterRequest(router, http.MethodPut, "/quarantine/"+id, string(body))
+	if recorder.Code != http.StatusOK || decodeQuarantinedRecord(t, recorder).Record != fixed {
+		t.Fatalf("got status %d fixing the record, want 200 with the fixed record: %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = serveRouterRequest(router, http.MethodPost, "/quarantine/"+id+"/import", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d importing the fixed record, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = serveRouterRequest(router, http.MethodGet, "/quarantine/"+id, "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("got status %d for an imported record, want 404", recorder.Code)
+	}
+
+	history, err := store.Transactions().FindTransactionHistoryPage("b1", PageRequest{First: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.TotalCount != 3 {
+		t.Errorf("got %d transactions of b1 after the import, want t1, t6 and t9", history.TotalCount)
+	}
+}
+
+/*
+	The content of a transaction record must be a single record, so
+	that none of it is silently dropped on import.
+*/
+func TestImportRejectsSeveralRecords(t *testing.T) {
+	id := "00000000000000a2"
+	router, store := newQuarantineTestRouter(t, newTestQuarantinedRecord(id,
+		"#t9\x00b1\x001.1.1.1\x00mac\x00(p1)\x00\x00#t10\x00b2\x001.1.1.1\x00mac\x00(p2)"))
+
+	recorder := serveRouterRequest(router, http.MethodPost, "/quarantine/"+id+"/import", "")
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d, want 422: %s", recorder.Code, recorder.Body.String())
+	}
+
+	record := decodeQuarantinedRecord(t, recorder)
+	if len(record.Issues) != 1 || record.Issues[0].Code != MalformedRecordIssue {
+		t.Errorf("got issues %+v, want a malformed record", record.Issues)
+	}
+
+	history, err := store.Transactions().FindTransactionHistoryPage("b1", PageRequest{First: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.TotalCount != 2 {
+		t.Errorf("got %d transactions of b1, want the record left out", history.TotalCount)
+	}
+}
+
+func TestDiscardQuarantinedRecord(t *testing.T) {
+	id := "00000000000000a3"
+	router, _ := newQuarantineTestRouter(t, newTestQuarantinedRecord(id, "#t9\x00b1\x001.1.1.1\x00toaster\x00(p1)"))
+
+	recorder := serveRouterRequest(router, http.MethodDelete, "/quarantine/"+id, "")
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want 204: %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = serveRouterRequest(router, http.MethodGet, "/quarantine/"+id, "")
+	if recorder.Code != http.StatusNotFound || decodeProblem(t, recorder).Code != RecordNotFoundCode {
+		t.Errorf("got status %d for a discarded record, want 404", recorder.Code)
+	}
+
+	recorder = serveRouterRequest(router, http.MethodDelete, "/quarantine/"+id, "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("got status %d discarding it again, want 404", recorder.Code)
+	}
+}
@@ -3,25 +3,114 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"math/rand"
 	c "module/constants"
-	f "module/utils"
 	"strings"
 	"time"
+
+	"github.com/dgraph-io/dgo/v2"
+	"github.com/dgraph-io/dgo/v2/protos/api"
 )
 
-func fetchBuyersFromDB(page int, pageSize int) ([]byte, error) {
-	txn := dgraphClient.NewTxn()
-	defer txn.Discard(ctx)
-	offset := pageSize * page
+/*
+	Store backed by a Dgraph cluster.
+*/
+type dgraphStore struct {
+	client *dgo.Dgraph
+}
 
-	query := fmt.Sprintf(`
-	{
-		buyers(func: type(Buyer), offset: %v, first: %v){
-			  expand(_all_){}
-		}
-	  }
-	`, offset, pageSize)
+type dgraphTxn struct {
+	txn *dgo.Txn
+}
+
+type dgraphBuyerRepository struct {
+	client *dgo.Dgraph
+}
+
+type dgraphProductRepository struct {
+	client *dgo.Dgraph
+}
+
+type dgraphTransactionRepository struct {
+	client *dgo.Dgraph
+}
+
+/*
+	Transaction as it's stored in Dgraph, where the buyer and the
+	products are edges to their nodes instead of ids.
+*/
+type dgraphTransaction struct {
+	Uid           string `json:"uid,omitempty"`
+	TransactionId string
+	Ip            string
+	Device        string
+	Date          string
+	BoughtBy      *dgraphNode  `json:",omitempty"`
+	Items         []dgraphNode `json:",omitempty"`
+	Type          string       `json:"dgraph.type,omitempty"`
+}
+
+/*
+	Buyer or product at the end of an edge of a transaction.
+*/
+type dgraphNode struct {
+	Uid       string `json:"uid,omitempty"`
+	BuyerId   string `json:",omitempty"`
+	ProductId string `json:",omitempty"`
+}
+
+type dgraphTransactionHolder struct {
+	Transactions []dgraphTransaction
+}
+
+// Predicates of a transaction, with the ids of its buyer and products
+const transactionFields string = `
+	TransactionId
+	Ip
+	Device
+	Date
+	BoughtBy {
+		BuyerId
+	}
+	Items {
+		ProductId
+	}
+`
+
+func newDgraphStore(client *dgo.Dgraph) *dgraphStore {
+	return &dgraphStore{client: client}
+}
+
+func (store *dgraphStore) Buyers() BuyerRepository {
+	return &dgraphBuyerRepository{client: store.client}
+}
+
+func (store *dgraphStore) Products() ProductRepository {
+	return &dgraphProductRepository{client: store.client}
+}
+
+func (store *dgraphStore) Transactions() TransactionRepository {
+	return &dgraphTransactionRepository{client: store.client}
+}
+
+func (store *dgraphStore) NewTxn() (Txn, error) {
+	return &dgraphTxn{txn: store.client.NewTxn()}, nil
+}
+
+func (t *dgraphTxn) Commit() error {
+	return t.txn.Commit(ctx)
+}
+
+func (t *dgraphTxn) Discard() {
+	t.txn.Discard(ctx)
+}
+
+func asDgraphTxn(txn Txn) *dgo.Txn {
+	return txn.(*dgraphTxn).txn
+}
+
+func (repository *dgraphBuyerRepository) FindBuyers(page PageRequest) (BuyerCollection, error) {
+	txn := repository.client.NewTxn()
+	defer txn.Discard(ctx)
 
 	countQuery := `
 	{
@@ -31,42 +120,41 @@ func fetchBuyersFromDB(page int, pageSize int) ([]byte, error) {
 	  }
 	`
 
-	totalBuyers, err := countEntities(countQuery)
+	totalBuyers, err := countEntities(repository.client, newDqlQuery(), countQuery)
 	if err != nil {
-		return nil, err
+		return BuyerCollection{}, err
 	}
 
-	qRes, err := txn.Query(ctx, query)
+	qRes, err := newDqlQuery().
+		withString("after", page.After).
+		withInt("first", page.limit()).
+		run(txn, `
+	{
+		buyers(func: type(Buyer), orderasc: BuyerId, first: $first)
+			@filter(gt(BuyerId, $after)) {
+			  expand(_all_){}
+		}
+	  }
+	`)
 	if err != nil {
-		return nil, err
+		return BuyerCollection{}, err
 	}
 
 	type Buyers struct{ Buyers []Buyer }
 	var result Buyers
 	err = json.Unmarshal(qRes.Json, &result)
 	if err != nil {
-		return nil, err
-	}
-
-	buyersCollection := &BuyerCollection{
-		Buyers: result.Buyers,
-		Count:  totalBuyers,
-	}
-
-	jsonRes, err := json.Marshal(buyersCollection)
-	if err != nil {
-		return nil, err
+		return BuyerCollection{}, err
 	}
 
-	return jsonRes, nil
-
+	return newBuyerCollection(result.Buyers, totalBuyers, page), nil
 }
 
-func countEntities(countQuery string) (int, error) {
-	txn := dgraphClient.NewTxn()
+func countEntities(client *dgo.Dgraph, query *dqlQuery, countQuery string) (int, error) {
+	txn := client.NewTxn()
 	defer txn.Discard(ctx)
 
-	cRes, err := txn.Query(ctx, countQuery)
+	cRes, err := query.run(txn, countQuery)
 	if err != nil {
 		return 0, err
 	}
@@ -80,215 +168,423 @@ func countEntities(countQuery string) (int, error) {
 	return collectionCount.CountArray[0].Total, nil
 }
 
-func fetchProductsFromDB(productIds string) ([]byte, error) {
-	productList := strings.Split(productIds, ",")
+func (repository *dgraphBuyerRepository) FindBuyersByIds(buyerIds []string, excludedBuyerId string, page PageRequest) (BuyerCollection, error) {
+	txn := repository.client.NewTxn()
+	defer txn.Discard(ctx)
+
+	query, filter := newBuyersByIdsQuery(buyerIds, excludedBuyerId)
+
+	countQuery := `{
+	CountArray(func: type(Buyer))
+			@filter(` + filter + `) {
+				total: count(uid)
+		}
+	}`
+
+	totalBuyers, err := countEntities(repository.client, query, countQuery)
+	if err != nil {
+		return BuyerCollection{}, err
+	}
+
+	res, err := query.
+		withString("after", page.After).
+		withInt("first", page.limit()).
+		run(txn, `{
+		buyersById(func: type(Buyer), orderasc: BuyerId, first: $first)
+			@filter(`+filter+` and gt(BuyerId, $after)) {
+			  BuyerId
+			  Age
+			  Name
+			  Date
+		}
+	}`)
+	if err != nil {
+		fmt.Printf("Error while retrieving buyers: %v\n", err)
+		return BuyerCollection{}, err
+	}
+
+	var buyersById BuyersById
+	err = json.Unmarshal(res.Json, &buyersById)
+	if err != nil {
+		fmt.Printf("Error while unmarshalling buyersById | %v", err)
+		return BuyerCollection{}, err
+	}
+
+	return newBuyerCollection(buyersById.Buyers, totalBuyers, page), nil
+}
 
-	txn := dgraphClient.NewTxn()
+/*
+	Returns the query variables and the filter matching the buyers of
+	@buyerIds but @excludedBuyerId. No buyer is excluded when
+	@excludedBuyerId is empty.
+*/
+func newBuyersByIdsQuery(buyerIds []string, excludedBuyerId string) (*dqlQuery, string) {
+	query := newDqlQuery().withTerms("buyerIds", buyerIds)
+	filter := "anyofterms(BuyerId, $buyerIds)"
+
+	if excludedBuyerId != "" {
+		query.withString("excludedBuyerId", excludedBuyerId)
+		filter += " and not anyofterms(BuyerId, $excludedBuyerId)"
+	}
+
+	return query, filter
+}
+
+/*
+	Follows the BoughtBy edges of the transactions made from @ips to
+	their buyers, which are paged and counted by Dgraph.
+*/
+func (repository *dgraphBuyerRepository) FindBuyersByIps(ips []string, excludedBuyerId string, page PageRequest) (BuyerCollection, error) {
+	if len(ips) == 0 {
+		return newBuyerCollection(nil, 0, page), nil
+	}
+
+	txn := repository.client.NewTxn()
 	defer txn.Discard(ctx)
 
-	query := fmt.Sprintf(`{
-		products(func: type(Product)) 
-			@filter(anyofterms(ProductId, "%s")) {
-			  expand(_all_){}
+	res, err := newDqlQuery().
+		withTerms("ips", ips).
+		withString("excludedBuyerId", excludedBuyerId).
+		withString("after", page.After).
+		withInt("first", page.limit()).
+		run(txn, `{
+		var(func: anyofterms(Ip, $ips)) @filter(type(Transaction)) {
+			sharing as BoughtBy @filter(not eq(BuyerId, $excludedBuyerId))
 		}
-	  }`, productList)
 
-	res, err := txn.Query(ctx, query)
+		CountArray(func: uid(sharing)) {
+			total: count(uid)
+		}
+
+		buyersById(func: uid(sharing), orderasc: BuyerId, first: $first)
+			@filter(gt(BuyerId, $after)) {
+			  BuyerId
+			  Age
+			  Name
+			  Date
+		}
+	}`)
 	if err != nil {
-		return nil, err
+		fmt.Printf("Error while retrieving buyers with the same IP: %v\n", err)
+		return BuyerCollection{}, err
+	}
+
+	var buyersByIps struct {
+		BuyersById
+		CollectionCount
+	}
+	err = json.Unmarshal(res.Json, &buyersByIps)
+	if err != nil {
+		fmt.Printf("Error while unmarshalling buyers with the same IP | %v\n", err)
+		return BuyerCollection{}, err
 	}
 
-	return res.Json, nil
+	totalBuyers := 0
+	if len(buyersByIps.CountArray) > 0 {
+		totalBuyers = buyersByIps.CountArray[0].Total
+	}
+
+	return newBuyerCollection(buyersByIps.Buyers, totalBuyers, page), nil
 }
 
-func fetchTransactionHistoryFromDB(buyerId string) (TransactionCollection, error) {
-	txn := dgraphClient.NewTxn()
+func (repository *dgraphBuyerRepository) FindBuyerName(buyerId string) (string, error) {
+	txn := repository.client.NewTxn()
 	defer txn.Discard(ctx)
 
-	query := fmt.Sprintf(`{
-		transactions(func: type(Transaction)) 
-			@filter(eq(BuyerId, "%s")) {
-			  expand(_all_){}
+	type BuyerName struct {
+		BuyerName []struct {
+			Name string
 		}
-	  }`, buyerId)
+	}
+	var bn BuyerName
 
-	countQuery := fmt.Sprintf(`
-	  {
-		  CountArray(func: type(Transaction))
-			  @filter(eq(BuyerId, "%s")){
-				total: count(uid)
-		  }
+	res, err := newDqlQuery().withString("buyerId", buyerId).run(txn, `{
+		buyerName(func: type(Buyer))
+			@filter(eq(BuyerId, $buyerId)) {
+			  Name
 		}
-	  `, buyerId)
+	}`)
+	if err != nil {
+		return "", err
+	}
 
-	totalTransactions, err := countEntities(countQuery)
+	err = json.Unmarshal(res.Json, &bn)
 	if err != nil {
-		return TransactionCollection{}, err
+		return "", err
+	}
+
+	if len(bn.BuyerName) == 0 {
+		return "", errBuyerNotFound
+	}
+
+	return bn.BuyerName[0].Name, nil
+}
+
+func (repository *dgraphBuyerRepository) SaveBuyers(txn Txn, buyers []Buyer) ([]Buyer, error) {
+	var buyerIds []string
+	var nodes []interface{}
+	for _, buyer := range buyers {
+		buyerIds = append(buyerIds, buyer.BuyerId)
+		nodes = append(nodes, buyer)
 	}
 
-	res, err := txn.Query(ctx, query)
+	savedIndexes, err := upsertNodes(asDgraphTxn(txn), "BuyerId", buyerIds, nodes)
 	if err != nil {
-		fmt.Printf("Error while retrieving transaction history for buyer %s: %v\n", buyerId, err)
-		return TransactionCollection{}, err
+		fmt.Printf("Error while persisting buyers to database: %v", err)
+		return nil, err
+	}
+
+	var saved []Buyer
+	for _, i := range savedIndexes {
+		saved = append(saved, buyers[i])
 	}
 
-	var transactionHistory TransactionHolder
-	err = json.Unmarshal(res.Json, &transactionHistory)
+	return saved, nil
+}
 
+func (repository *dgraphBuyerRepository) DeleteOrphanBuyers(txn Txn, date string) (int, error) {
+	dateTime, err := toDgraphDate(date)
 	if err != nil {
-		fmt.Printf("Error while unmarshalling transactions from database | %v", err)
-		return TransactionCollection{}, err
+		return 0, err
 	}
 
-	return TransactionCollection{
-		Transactions: transactionHistory.Transactions,
-		Count:        totalTransactions,
-	}, nil
+	var dateBuyers struct {
+		Buyers []struct {
+			Uid          string `json:"uid"`
+			Transactions int
+		}
+	}
+
+	res, err := newDqlQuery().withString("date", dateTime).run(asDgraphTxn(txn), `{
+		buyers(func: eq(Date, $date)) @filter(type(Buyer)) {
+			uid
+			Transactions: count(~BoughtBy @filter(not eq(Date, $date)))
+		}
+	}`)
+	if err != nil {
+		return 0, fmt.Errorf("error while fetching buyers of '%s' | %w", date, err)
+	}
+
+	err = json.Unmarshal(res.Json, &dateBuyers)
+	if err != nil {
+		return 0, err
+	}
+
+	var orphanUids []string
+	for _, buyer := range dateBuyers.Buyers {
+		if buyer.Transactions == 0 {
+			orphanUids = append(orphanUids, buyer.Uid)
+		}
+	}
+
+	return len(orphanUids), deleteNodes(asDgraphTxn(txn), orphanUids)
 }
 
-func fetchTransactionsForIpsFromDB(ips []string) ([]Transaction, error) {
-	txn := dgraphClient.NewTxn()
+func (repository *dgraphProductRepository) FindProductsByIds(productIds []string) ([]Product, error) {
+	txn := repository.client.NewTxn()
 	defer txn.Discard(ctx)
 
-	query := fmt.Sprintf(`{
-		transactions(func: type(Transaction))
-			@filter(anyofterms(Ip, "%s")) {
+	res, err := newDqlQuery().withTerms("productIds", productIds).run(txn, `{
+		products(func: type(Product))
+			@filter(anyofterms(ProductId, $productIds)) {
 			  expand(_all_){}
 		}
-	  }`, fmt.Sprint(ips))
+	  }`)
+	if err != nil {
+		fmt.Printf("Error while fetching products: %v\n", err)
+		return nil, err
+	}
 
-	res, err := txn.Query(ctx, query)
+	var productHolder ProductHolder
+	err = json.Unmarshal(res.Json, &productHolder)
 	if err != nil {
-		fmt.Printf("Error while retrieving transaction for the specified ip addresses: %v\n", err)
+		fmt.Printf("Error while unmarshaling products | %v\n", err)
 		return nil, err
 	}
 
-	var transactionsForIps TransactionHolder
-	err = json.Unmarshal(res.Json, &transactionsForIps)
+	return productHolder.Products, nil
+}
+
+func (repository *dgraphProductRepository) SaveProducts(txn Txn, products []Product) ([]Product, error) {
+	var productIds []string
+	var nodes []interface{}
+	for _, product := range products {
+		productIds = append(productIds, product.ProductId)
+		nodes = append(nodes, product)
+	}
+
+	savedIndexes, err := upsertNodes(asDgraphTxn(txn), "ProductId", productIds, nodes)
 	if err != nil {
-		fmt.Printf("Error while unmarshalling transactions for the specified ip addresses | %v\n", err)
+		fmt.Printf("Error while persisting new products | %v\n", err)
 		return nil, err
 	}
 
-	return transactionsForIps.Transactions, nil
+	var saved []Product
+	for _, i := range savedIndexes {
+		saved = append(saved, products[i])
+	}
 
+	return saved, nil
 }
 
-func fetchBuyersByIdFromDB(buyerIds []string, buyerId string) (BuyerCollection, error) {
-	txn := dgraphClient.NewTxn()
-	defer txn.Discard(ctx)
+func (repository *dgraphProductRepository) DeleteOrphanProducts(txn Txn, date string) (int, error) {
+	dateTime, err := toDgraphDate(date)
+	if err != nil {
+		return 0, err
+	}
 
-	query := fmt.Sprintf(`{
-		buyersById(func: type(Buyer))
-			@filter(anyofterms(BuyerId, "%s") and not anyofterms(BuyerId, "%s")) {
-			  BuyerId
-			  Age
-			  Name
-			  Date
+	var dateProducts struct {
+		Products []struct {
+			Uid          string `json:"uid"`
+			Transactions int
 		}
-	}`, fmt.Sprint(buyerIds), buyerId)
+	}
 
-	countQuery := fmt.Sprintf(`{
-	CountArray(func: type(Buyer))
-			@filter(anyofterms(BuyerId, "%s") and not anyofterms(BuyerId, "%s")) {
-				total: count(uid)
+	res, err := newDqlQuery().withString("date", dateTime).run(asDgraphTxn(txn), `{
+		products(func: eq(Date, $date)) @filter(type(Product)) {
+			uid
+			Transactions: count(~Items @filter(not eq(Date, $date)))
 		}
-	}`, fmt.Sprint(buyerIds), buyerId)
-
-	totalBuyers, err := countEntities(countQuery)
+	}`)
 	if err != nil {
-		return BuyerCollection{}, err
+		return 0, fmt.Errorf("error while fetching products of '%s' | %w", date, err)
 	}
 
-	res, err := txn.Query(ctx, query)
+	err = json.Unmarshal(res.Json, &dateProducts)
 	if err != nil {
-		fmt.Printf("Error while retrieving buyers: %v\n", err)
-		return BuyerCollection{}, err
+		return 0, err
 	}
 
-	var buyersById BuyersById
-	err = json.Unmarshal(res.Json, &buyersById)
-	if err != nil {
-		fmt.Printf("Error while unmarshalling buyersById | %v", err)
-		return BuyerCollection{}, err
+	var orphanUids []string
+	for _, product := range dateProducts.Products {
+		if product.Transactions == 0 {
+			orphanUids = append(orphanUids, product.Uid)
+		}
 	}
 
-	return BuyerCollection{
-		Buyers: buyersById.Buyers,
-		Count:  totalBuyers,
-	}, nil
+	return len(orphanUids), deleteNodes(asDgraphTxn(txn), orphanUids)
 }
 
-func fetchProductRecommendationsFromDB(buyerTransactions []Transaction) ([]Product, error) {
-	var boughtProducts []string
+func (repository *dgraphTransactionRepository) FindTransactionHistoryPage(buyerId string, page PageRequest) (TransactionCollection, error) {
+	txn := repository.client.NewTxn()
+	defer txn.Discard(ctx)
 
-	for _, transaction := range buyerTransactions {
-		boughtProducts = append(boughtProducts, transaction.Products...)
+	res, err := newDqlQuery().
+		withString("buyerId", buyerId).
+		withString("after", page.After).
+		withInt("first", page.limit()).
+		run(txn, `{
+		buyer(func: eq(BuyerId, $buyerId)) @filter(type(Buyer)) {
+			Count: count(~BoughtBy)
+			Transactions: ~BoughtBy (orderasc: TransactionId, first: $first)
+				@filter(gt(TransactionId, $after)) {`+transactionFields+`}
+		}
+	}`)
+	if err != nil {
+		fmt.Printf("Error while retrieving transaction history for buyer %s: %v\n", buyerId, err)
+		return TransactionCollection{}, err
 	}
 
-	similarProductTransactions, err := fetchSimilarProductTransactionsFromDB(boughtProducts)
+	var buyerHistory struct {
+		Buyer []struct {
+			Count        int
+			Transactions []dgraphTransaction
+		}
+	}
+	err = json.Unmarshal(res.Json, &buyerHistory)
 	if err != nil {
-		fmt.Println(err)
-		return nil, err
+		fmt.Printf("Error while unmarshalling transactions from database | %v", err)
+		return TransactionCollection{}, err
 	}
 
-	var productIdsBuffer []string
-	for _, transaction := range similarProductTransactions {
-		productIdsBuffer = append(productIdsBuffer, transaction.Products...)
+	if len(buyerHistory.Buyer) == 0 {
+		return newTransactionCollection(nil, 0, page), nil
 	}
 
-	//Filter out bought products
-	productIds := filterBoughtProductIds(boughtProducts, productIdsBuffer)
+	return newTransactionCollection(fromDgraphTransactions(buyerHistory.Buyer[0].Transactions), buyerHistory.Buyer[0].Count, page), nil
+}
 
-	txn := dgraphClient.NewTxn()
+/*
+	Only the IPs and the product ids of the transactions are fetched.
+*/
+func (repository *dgraphTransactionRepository) FindBuyerActivity(buyerId string) (BuyerActivity, error) {
+	txn := repository.client.NewTxn()
 	defer txn.Discard(ctx)
 
-	query := fmt.Sprintf(`{
-		products(func: type(Product)) 
-			@filter(anyofterms(ProductId, "%s")) {
-			  expand(_all_){}
+	res, err := newDqlQuery().withString("buyerId", buyerId).run(txn, `{
+		buyer(func: eq(BuyerId, $buyerId)) @filter(type(Buyer)) {
+			Transactions: ~BoughtBy {
+				Ip
+				Items {
+					ProductId
+				}
+			}
 		}
-	  }`, productIds)
-
-	productsRes, err := txn.Query(ctx, query)
+	}`)
 	if err != nil {
-		fmt.Printf("Error while fetching products: %v\n", err)
-		return nil, err
+		fmt.Printf("Error while retrieving the activity of buyer %s: %v\n", buyerId, err)
+		return BuyerActivity{}, err
 	}
 
-	var productHolder ProductHolder
-	err = json.Unmarshal(productsRes.Json, &productHolder)
+	var buyerActivity struct {
+		Buyer []struct {
+			Transactions []dgraphTransaction
+		}
+	}
+	err = json.Unmarshal(res.Json, &buyerActivity)
 	if err != nil {
-		fmt.Printf("Error while unmarshaling products | %v\n", err)
-		return nil, err
+		fmt.Printf("Error while unmarshalling the activity of buyer %s | %v\n", buyerId, err)
+		return BuyerActivity{}, err
 	}
 
-	recommendedProducts := filterRepeatedProducts(productHolder.Products)
+	activity := BuyerActivity{Ips: []string{}, ProductIds: []string{}}
+	if len(buyerActivity.Buyer) == 0 {
+		return activity, nil
+	}
+
+	seenIps := map[string]bool{}
+	seenProductIds := map[string]bool{}
+	for _, transaction := range buyerActivity.Buyer[0].Transactions {
+		if !seenIps[transaction.Ip] {
+			seenIps[transaction.Ip] = true
+			activity.Ips = append(activity.Ips, transaction.Ip)
+		}
 
-	return recommendedProducts, nil
+		for _, item := range transaction.Items {
+			if !seenProductIds[item.ProductId] {
+				seenProductIds[item.ProductId] = true
+				activity.ProductIds = append(activity.ProductIds, item.ProductId)
+			}
+		}
+	}
+
+	return activity, nil
 }
 
 /*
-	Returns transactions that contain products specified in @boughtProducts
+	Follows the Items edges back from the products to the
+	transactions that contain them.
 */
-func fetchSimilarProductTransactionsFromDB(boughtProducts []string) ([]Transaction, error) {
-	txn := dgraphClient.NewTxn()
+func (repository *dgraphTransactionRepository) FindTransactionsWithProducts(productIds []string, first int) ([]Transaction, error) {
+	txn := repository.client.NewTxn()
 	defer txn.Discard(ctx)
 
-	query := fmt.Sprintf(`{
-		transactions(func: type(Transaction), first: 10) 
-			@filter(anyofterms(Products, "%s")) {
-			  expand(_all_){}
+	transactionsRes, err := newDqlQuery().
+		withInt("first", first).
+		withTerms("productIds", productIds).
+		run(txn, `{
+		var(func: anyofterms(ProductId, $productIds)) @filter(type(Product)) {
+			containing as ~Items
 		}
-	  }`, boughtProducts)
 
-	transactionsRes, err := txn.Query(ctx, query)
+		transactions(func: uid(containing), first: $first) {`+transactionFields+`}
+	}`)
 	if err != nil {
 		fmt.Printf("Error while fetching transactions with products bought by this buyer: %v\n", err)
 		return nil, err
 	}
 
-	var transactionsForBuyerProductsRes TransactionHolder
+	var transactionsForBuyerProductsRes dgraphTransactionHolder
 	err = json.Unmarshal(transactionsRes.Json, &transactionsForBuyerProductsRes)
 
 	if err != nil {
@@ -296,80 +592,376 @@ func fetchSimilarProductTransactionsFromDB(boughtProducts []string) ([]Transacti
 		return nil, err
 	}
 
-	return transactionsForBuyerProductsRes.Transactions, nil
+	return fromDgraphTransactions(transactionsForBuyerProductsRes.Transactions), nil
 }
 
-func filterBoughtProductIds(boughtProducts []string, productIdsBuffer []string) []string {
-	var filteredProductIds []string
+func (repository *dgraphTransactionRepository) IsDateSynchronized(txn Txn, date string) (bool, error) {
+	//Parse the date to the format the database uses for dates: RFC3339
+	t, err := time.Parse(c.DateLayout, date)
+	if err != nil {
+		fmt.Printf("Error while parsing string '%s' to date | %v\n", date, err)
+		return false, err
+	}
+
+	// Buyers and products have a Date too, so only transactions are looked at
+	res, err := newDqlQuery().withString("date", t.Format(c.DateLayoutRFC3339)).run(asDgraphTxn(txn), `{
+		q(func: eq(Date, $date)) @filter(type(Transaction)){
+				  uid
+			  }
+	  }`)
+	if err != nil {
+		return false, err
+	}
+
+	return res.Metrics.NumUids["uid"] > 0, nil
+}
 
-	for _, id := range productIdsBuffer {
-		if !f.ArrayContains(boughtProducts, id) && !f.ArrayContains(filteredProductIds, id) {
-			filteredProductIds = append(filteredProductIds, id)
+func (repository *dgraphTransactionRepository) FindLatestSynchronizedDate(txn Txn) (string, error) {
+	var latest struct {
+		Transactions []struct {
+			Date string
 		}
 	}
 
-	return filteredProductIds
+	res, err := newDqlQuery().run(asDgraphTxn(txn), `{
+		transactions(func: type(Transaction), orderdesc: Date, first: 1) {
+			Date
+		}
+	}`)
+	if err != nil {
+		return "", fmt.Errorf("error while fetching the latest transaction | %w", err)
+	}
+
+	err = json.Unmarshal(res.Json, &latest)
+	if err != nil {
+		return "", err
+	}
+
+	if len(latest.Transactions) == 0 {
+		return "", nil
+	}
+
+	return fromDgraphDate(latest.Transactions[0].Date)
 }
 
-func filterRepeatedProducts(products []Product) []Product {
-	//Shuffle the array so that each time, new recommendations
-	//are generated.
-	rand.Seed(time.Now().UnixNano())
-	rand.Shuffle(len(products), func(i, j int) {
-		products[i], products[j] = products[j], products[i]
-	})
+/*
+	Saves @transactions with edges to their buyer and products, which
+	must be in the database or have been saved earlier in @txn.
+*/
+func (repository *dgraphTransactionRepository) SaveTransactions(txn Txn, transactions []Transaction) (int, error) {
+	var buyerIds, productIds []string
+	for _, transaction := range transactions {
+		buyerIds = append(buyerIds, transaction.BuyerId)
+		productIds = append(productIds, transaction.Products...)
+	}
+
+	buyerUids, productUids, err := findNodeUids(asDgraphTxn(txn), buyerIds, productIds)
+	if err != nil {
+		return 0, err
+	}
+
+	var transactionIds []string
+	var nodes []interface{}
+	for _, transaction := range transactions {
+		dgraphTransaction, err := toDgraphTransaction(transaction, buyerUids, productUids)
+		if err != nil {
+			return 0, err
+		}
 
-	var addedIds []string
-	var result []Product = []Product{}
+		transactionIds = append(transactionIds, transaction.TransactionId)
+		nodes = append(nodes, dgraphTransaction)
+	}
 
-	var max int
-	if len(products) < c.MaxProductRecommendations {
-		max = len(products)
-	} else {
-		max = c.MaxProductRecommendations
+	savedIndexes, err := upsertNodes(asDgraphTxn(txn), "TransactionId", transactionIds, nodes)
+	if err != nil {
+		fmt.Printf("Error while persisting transactions: %v\n", err)
+		return 0, err
 	}
 
-	for _, product := range products {
-		if len(addedIds) >= max {
-			break
+	return len(savedIndexes), nil
+}
+
+func (repository *dgraphTransactionRepository) DeleteTransactionsOfDate(txn Txn, date string) (int, error) {
+	dateTime, err := toDgraphDate(date)
+	if err != nil {
+		return 0, err
+	}
+
+	var dateTransactions struct {
+		Transactions []struct {
+			Uid string `json:"uid"`
 		}
+	}
 
-		if !f.ArrayContains(addedIds, product.ProductId) {
-			addedIds = append(addedIds, product.ProductId)
-			result = append(result, product)
+	res, err := newDqlQuery().withString("date", dateTime).run(asDgraphTxn(txn), `{
+		transactions(func: eq(Date, $date)) @filter(type(Transaction)) {
+			uid
 		}
+	}`)
+	if err != nil {
+		return 0, fmt.Errorf("error while fetching transactions of '%s' | %w", date, err)
+	}
+
+	err = json.Unmarshal(res.Json, &dateTransactions)
+	if err != nil {
+		return 0, err
 	}
 
-	return result
+	var uids []string
+	for _, transaction := range dateTransactions.Transactions {
+		uids = append(uids, transaction.Uid)
+	}
+
+	return len(uids), deleteNodes(asDgraphTxn(txn), uids)
 }
 
-func fetchBuyerNameFromDB(buyerId string) (string, error) {
-	txn := dgraphClient.NewTxn()
-	defer txn.Discard(ctx)
+/*
+	Deletes every predicate of the nodes in @uids.
+*/
+func deleteNodes(txn *dgo.Txn, uids []string) error {
+	if len(uids) == 0 {
+		return nil
+	}
 
-	query := fmt.Sprintf(`{
-		buyerName(func: type(Buyer))
-			@filter(eq(BuyerId, "%s")) {
-			  Name
+	var nodes []map[string]string
+	for _, uid := range uids {
+		nodes = append(nodes, map[string]string{"uid": uid})
+	}
+
+	jsonNodes, err := json.Marshal(nodes)
+	if err != nil {
+		return err
+	}
+
+	_, err = txn.Mutate(ctx, &api.Mutation{DeleteJson: jsonNodes})
+	if err != nil {
+		return fmt.Errorf("error while deleting nodes | %w", err)
+	}
+
+	return nil
+}
+
+/*
+	Parses a yyyy-MM-DD @date to the format the database uses for dates: RFC3339
+*/
+func toDgraphDate(date string) (string, error) {
+	t, err := time.Parse(c.DateLayout, date)
+	if err != nil {
+		return "", fmt.Errorf("error while parsing string '%s' to date | %w", date, err)
+	}
+
+	return t.Format(c.DateLayoutRFC3339), nil
+}
+
+/*
+	Parses a @dateTime as returned by the database to yyyy-MM-DD.
+*/
+func fromDgraphDate(dateTime string) (string, error) {
+	t, err := time.Parse(time.RFC3339, dateTime)
+	if err != nil {
+		return "", fmt.Errorf("error while parsing string '%s' to date | %w", dateTime, err)
+	}
+
+	return t.Format(c.DateLayout), nil
+}
+
+/*
+	Returns the uids of the buyers in @buyerIds and of the products in
+	@productIds, keyed by their ids.
+*/
+func findNodeUids(txn *dgo.Txn, buyerIds []string, productIds []string) (map[string]string, map[string]string, error) {
+	var nodes struct {
+		Buyers   []dgraphNode
+		Products []dgraphNode
+	}
+
+	res, err := newDqlQuery().
+		withTerms("buyerIds", buyerIds).
+		withTerms("productIds", productIds).
+		run(txn, `{
+		buyers(func: anyofterms(BuyerId, $buyerIds)) @filter(type(Buyer)) {
+			uid
+			BuyerId
 		}
-	}`, buyerId)
 
-	type BuyerName struct {
-		BuyerName []struct {
-			Name string
+		products(func: anyofterms(ProductId, $productIds)) @filter(type(Product)) {
+			uid
+			ProductId
 		}
+	}`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while fetching the uids of buyers and products | %w", err)
 	}
-	var bn BuyerName
 
-	res, err := txn.Query(ctx, query)
+	err = json.Unmarshal(res.Json, &nodes)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
-	err = json.Unmarshal(res.Json, &bn)
+	buyerUids := map[string]string{}
+	for _, buyer := range nodes.Buyers {
+		buyerUids[buyer.BuyerId] = buyer.Uid
+	}
+
+	productUids := map[string]string{}
+	for _, product := range nodes.Products {
+		productUids[product.ProductId] = product.Uid
+	}
+
+	return buyerUids, productUids, nil
+}
+
+func toDgraphTransaction(transaction Transaction, buyerUids map[string]string, productUids map[string]string) (dgraphTransaction, error) {
+	buyerUid, found := buyerUids[transaction.BuyerId]
+	if !found {
+		return dgraphTransaction{}, fmt.Errorf("buyer '%s' of transaction '%s' isn't in the database", transaction.BuyerId, transaction.TransactionId)
+	}
+
+	items := []dgraphNode{}
+	for _, productId := range transaction.Products {
+		productUid, found := productUids[productId]
+		if !found {
+			return dgraphTransaction{}, fmt.Errorf("product '%s' of transaction '%s' isn't in the database", productId, transaction.TransactionId)
+		}
+
+		items = append(items, dgraphNode{Uid: productUid})
+	}
+
+	return dgraphTransaction{
+		TransactionId: transaction.TransactionId,
+		Ip:            transaction.Ip,
+		Device:        transaction.Device,
+		Date:          transaction.Date,
+		BoughtBy:      &dgraphNode{Uid: buyerUid},
+		Items:         items,
+		Type:          c.TransactionType,
+	}, nil
+}
+
+func fromDgraphTransactions(dgraphTransactions []dgraphTransaction) []Transaction {
+	transactions := []Transaction{}
+	for _, dgraphTransaction := range dgraphTransactions {
+		transaction := Transaction{
+			TransactionId: dgraphTransaction.TransactionId,
+			Ip:            dgraphTransaction.Ip,
+			Device:        dgraphTransaction.Device,
+			Date:          dgraphTransaction.Date,
+			Products:      []string{},
+		}
+
+		if dgraphTransaction.BoughtBy != nil {
+			transaction.BuyerId = dgraphTransaction.BoughtBy.BuyerId
+		}
+		for _, item := range dgraphTransaction.Items {
+			transaction.Products = append(transaction.Products, item.ProductId)
+		}
+
+		transactions = append(transactions, transaction)
+	}
+
+	return transactions
+}
+
+/*
+	Sets each node of @nodes unless a node whose @predicate is the
+	matching key of @keys is already saved, through upsert blocks of
+	c.UpsertBatchSize nodes. Only the keys are looked up, and the
+	@upsert directive of @predicate makes concurrent txns setting the
+	same key conflict. Returns the indexes of the nodes that were set.
+*/
+func upsertNodes(txn *dgo.Txn, predicate string, keys []string, nodes []interface{}) ([]int, error) {
+	var savedIndexes []int
+	setKeys := map[string]bool{}
+
+	for start := 0; start < len(nodes); start += c.UpsertBatchSize {
+		end := start + c.UpsertBatchSize
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+
+		query := newDqlQuery()
+		var blocks, vars []string
+		var mutations []*api.Mutation
+		var batchIndexes []int
+
+		for i := start; i < end; i++ {
+			// Repeated keys would be set twice, since none is saved when the block runs
+			if setKeys[keys[i]] {
+				continue
+			}
+			setKeys[keys[i]] = true
+
+			name := fmt.Sprintf("n%d", i-start)
+			query.withString(name, keys[i])
+			blocks = append(blocks, fmt.Sprintf("%s as var(func: eq(%s, $%s))", name, predicate, name))
+			vars = append(vars, name)
+
+			jsonNode, err := withUid(nodes[i], fmt.Sprintf("uid(%s)", name))
+			if err != nil {
+				return nil, err
+			}
+
+			mutations = append(mutations, &api.Mutation{
+				SetJson: jsonNode,
+				Cond:    fmt.Sprintf("@if(eq(len(%s), 0))", name),
+			})
+			batchIndexes = append(batchIndexes, i)
+		}
+
+		if len(mutations) == 0 {
+			continue
+		}
+
+		body := fmt.Sprintf("{\n%s\nsaved(func: uid(%s)) {\n%s\n}\n}",
+			strings.Join(blocks, "\n"), strings.Join(vars, ", "), predicate)
+
+		res, err := txn.Do(ctx, &api.Request{
+			Query:     query.build(body),
+			Vars:      query.variables,
+			Mutations: mutations,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error while upserting nodes by %s | %w", predicate, err)
+		}
+
+		var saved struct {
+			Saved []map[string]string
+		}
+		err = json.Unmarshal(res.Json, &saved)
+		if err != nil {
+			return nil, err
+		}
+
+		savedKeys := map[string]bool{}
+		for _, node := range saved.Saved {
+			savedKeys[node[predicate]] = true
+		}
+
+		for _, i := range batchIndexes {
+			if !savedKeys[keys[i]] {
+				savedIndexes = append(savedIndexes, i)
+			}
+		}
+	}
+
+	return savedIndexes, nil
+}
+
+/*
+	Returns the JSON of @node with its uid set to @uid.
+*/
+func withUid(node interface{}, uid string) ([]byte, error) {
+	jsonNode, err := json.Marshal(node)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return bn.BuyerName[0].Name, nil
+	var fields map[string]interface{}
+	err = json.Unmarshal(jsonNode, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	fields["uid"] = uid
+	return json.Marshal(fields)
 }
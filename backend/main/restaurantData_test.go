@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+/*
+	The exclusion is left out of the query altogether when there's no
+	buyer to exclude.
+*/
+func TestBuyersByIdsQuery(t *testing.T) {
+	query, filter := newBuyersByIdsQuery([]string{"b1", "b2"}, "")
+	if strings.Contains(filter, "excludedBuyerId") {
+		t.Errorf("got filter %q, want no exclusion", filter)
+	}
+	if _, ok := query.variables["$excludedBuyerId"]; ok || len(query.variables) != 1 {
+		t.Errorf("got variables %v, want only the buyer ids", query.variables)
+	}
+
+	query, filter = newBuyersByIdsQuery([]string{"b1", "b2"}, "b1")
+	if !strings.Contains(filter, "not anyofterms(BuyerId, $excludedBuyerId)") || query.variables["$excludedBuyerId"] != "b1" {
+		t.Errorf("got filter %q and variables %v, want b1 excluded", filter, query.variables)
+	}
+}
@@ -3,90 +3,354 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	c "module/constants"
-	"strconv"
+	p "module/productfeed"
+	f "module/utils"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 )
 
 const (
-	DateError  string = "DateError"
-	OtherError string = "OtherError"
+	DateLoaded  string = "loaded"
+	DateSkipped string = "skipped"
+	DateFailed  string = "failed"
 )
 
-func startDataLoading(dataLoader *DataLoader) ([]byte, string, error) {
-	err := isDateParamValid(dataLoader.dateStr)
+type RestaurantService struct {
+	store           Store
+	source          DataSource
+	productFeedMode p.Mode
+	quarantine      QuarantineRepository
+	loadSlotsOnce   sync.Once
+	loadSlots       chan bool
+}
+
+/*
+	Checks that @date is valid and, unless @force is set, that it
+	hasn't been synchronized yet, so that a load job can be created
+	for it.
+*/
+func (service *RestaurantService) checkDateLoadable(date string, force bool) error {
+	err := isDateParamValid(date)
 
 	if err != nil {
-		return nil, DateError, fmt.Errorf("invalid date")
+		return errInvalidDate
 	}
 
-	txn := dgraphClient.NewTxn()
-	defer txn.Discard(ctx)
+	if force {
+		return nil
+	}
 
-	validDate, err := dataLoader.isDateRequestable()
+	txn, err := service.store.NewTxn()
 	if err != nil {
-		return nil, OtherError, err
+		return newStorageError("error while checking if the date is synchronized", err)
+	}
+	defer txn.Discard()
+
+	validDate, err := service.newDataLoader(date, txn).isDateRequestable()
+	if err != nil {
+		return newStorageError("error while checking if the date is synchronized", err)
 	}
 
 	if !validDate {
-		return nil, DateError, fmt.Errorf("date already synchronized: '%s'", dataLoader.dateStr)
+		return newDateSynchronizedError(date)
+	}
+
+	return nil
+}
+
+/*
+	Loads the data of @date, reporting the progress of the load to
+	@progress if it isn't nil. When @force is set, a date that is
+	already synchronized is purged and loaded again in the same
+	transaction, so it's left untouched if the new load fails.
+*/
+func (service *RestaurantService) loadDate(date string, force bool, progress progressFunc) (*LoadResponse, error) {
+	err := isDateParamValid(date)
+
+	if err != nil {
+		return nil, errInvalidDate
+	}
+
+	release := service.acquireLoadSlot()
+	defer release()
+
+	txn, err := service.store.NewTxn()
+	if err != nil {
+		return nil, newStorageError(fmt.Sprintf("error while loading the data of '%s'", date), err)
+	}
+	defer txn.Discard()
+
+	dataLoader := service.newDataLoader(date, txn)
+	dataLoader.progress = progress
+
+	return service.runLoad(dataLoader, force)
+}
+
+/*
+	Runs the load of @date, as loadDate does, but discards it
+	instead of committing it, returning what the load would save.
+*/
+func (service *RestaurantService) dryRunDate(date string, force bool) (*DryRunResponse, error) {
+	err := isDateParamValid(date)
+
+	if err != nil {
+		return nil, errInvalidDate
+	}
+
+	release := service.acquireLoadSlot()
+	defer release()
+
+	txn, err := service.store.NewTxn()
+	if err != nil {
+		return nil, newStorageError(fmt.Sprintf("error while loading the data of '%s'", date), err)
+	}
+	defer txn.Discard()
+
+	dataLoader := service.newDataLoader(date, txn)
+	dataLoader.dryRun = true
+
+	res, err := service.runLoad(dataLoader, force)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DryRunResponse{
+		Date:         date,
+		New:          res.counts(),
+		Existing:     dataLoader.existing,
+		LoadResponse: res,
+	}, nil
+}
+
+/*
+	Runs the load of @dataLoader. Failures of the upstream keep their
+	kind, and any other failure is a storage error.
+*/
+func (service *RestaurantService) runLoad(dataLoader *DataLoader, force bool) (*LoadResponse, error) {
+	if force {
+		_, err := dataLoader.purgeDate()
+		if err != nil {
+			return nil, newStorageError(fmt.Sprintf("error while purging '%s'", dataLoader.dateStr), err)
+		}
+	} else {
+		validDate, err := dataLoader.isDateRequestable()
+		if err != nil {
+			return nil, newStorageError("error while checking if the date is synchronized", err)
+		}
+
+		if !validDate {
+			return nil, newDateSynchronizedError(dataLoader.dateStr)
+		}
 	}
 
 	res, err := dataLoader.loadRestaurantData()
 	if err != nil {
-		return nil, OtherError, err
+		return nil, newStorageError(fmt.Sprintf("error while loading the data of '%s'", dataLoader.dateStr), err)
 	}
 
-	return res, "", nil
+	return res, nil
 }
 
 /*
-	Validates the date parameter by checking if it matches
-	the layout used by Dgraph to store dates: yyyy-MM-DD.
-	Returns an error if it doesn't and nil if it matches.
+	Deletes all the data loaded with @date: its transactions and the
+	buyers and products no other date references.
 */
-func isDateParamValid(date string) error {
-	//date = ""
-	_, err := time.Parse(c.DateLayout, date)
+func (service *RestaurantService) purgeDate(date string) (*PurgeResponse, error) {
+	err := isDateParamValid(date)
+
 	if err != nil {
-		return err
+		return nil, errInvalidDate
 	}
 
-	return nil
+	release := service.acquireLoadSlot()
+	defer release()
+
+	txn, err := service.store.NewTxn()
+	if err != nil {
+		return nil, newStorageError(fmt.Sprintf("error while purging '%s'", date), err)
+	}
+	defer txn.Discard()
+
+	purged, err := service.newDataLoader(date, txn).purgeDate()
+	if err != nil {
+		return nil, newStorageError(fmt.Sprintf("error while purging '%s'", date), err)
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return nil, newStorageError(fmt.Sprintf("error while committing purge of '%s'", date), err)
+	}
+
+	if service.quarantine != nil {
+		purged.Quarantined, err = service.quarantine.DeleteRecordsOfDate(date)
+		if err != nil {
+			return nil, newStorageError("date purged, but its quarantined records couldn't be deleted", err)
+		}
+	}
+
+	return purged, nil
 }
 
-func validatePageParams(pageParam string, pageSizeParam string) (int, int, error) {
-	if pageParam == "" && pageSizeParam == "" {
-		return 0, 0, fmt.Errorf("invalid page parameters")
+/*
+	Waits until a load can write to the store, and returns the
+	function that lets the next one in. Up to c.MaxConcurrentLoads
+	loads write at the same time, or as many as the store takes when
+	it's a LoadLimiter.
+*/
+func (service *RestaurantService) acquireLoadSlot() (release func()) {
+	service.loadSlotsOnce.Do(func() {
+		slots := c.MaxConcurrentLoads
+		limiter, ok := service.store.(LoadLimiter)
+		if ok {
+			slots = limiter.MaxConcurrentLoads()
+		}
+
+		service.loadSlots = make(chan bool, slots)
+	})
+
+	service.loadSlots <- true
+	return func() { <-service.loadSlots }
+}
+
+func (service *RestaurantService) newDataLoader(date string, txn Txn) *DataLoader {
+	return &DataLoader{
+		dateStr:         date,
+		store:           service.store,
+		source:          service.source,
+		txn:             txn,
+		productFeedMode: service.productFeedMode,
+		quarantine:      service.quarantine,
+	}
+}
+
+/*
+	Loads every date of @dates, running at most c.MaxConcurrentLoads
+	loads at the same time, or fewer when the store can't take them
+	(see acquireLoadSlot). Dates that are already synchronized are
+	skipped, and a failed date doesn't stop the others. @onDateDone
+	is called with the summary of each date as soon as it finishes.
+	The progress of every load is reported to @progress. When @force
+	is set, synchronized dates are loaded again instead of skipped.
+*/
+func (service *RestaurantService) loadDateRange(dates []string, force bool, progress progressFunc, onDateDone func(summary DateLoadSummary)) RangeLoadResponse {
+	summaries := make([]DateLoadSummary, len(dates))
+	semaphore := make(chan bool, c.MaxConcurrentLoads)
+	waitGroup := sync.WaitGroup{}
+
+	for i, date := range dates {
+		waitGroup.Add(1)
+		semaphore <- true
+
+		go func(i int, date string) {
+			defer waitGroup.Done()
+			defer func() { <-semaphore }()
+
+			summaries[i] = service.loadDateOfRange(date, force, progress)
+			onDateDone(summaries[i])
+		}(i, date)
 	}
 
-	page, err := strconv.Atoi(pageParam)
+	waitGroup.Wait()
+
+	rangeResponse := RangeLoadResponse{Dates: summaries}
+	for _, summary := range summaries {
+		switch summary.Status {
+		case DateLoaded:
+			rangeResponse.Loaded++
+		case DateSkipped:
+			rangeResponse.Skipped++
+		case DateFailed:
+			rangeResponse.Failed++
+		}
+	}
+
+	return rangeResponse
+}
+
+func (service *RestaurantService) loadDateOfRange(date string, force bool, progress progressFunc) DateLoadSummary {
+	loadResponse, err := service.loadDate(date, force, progress)
+
+	if err == nil {
+		counts := loadResponse.counts()
+		return DateLoadSummary{
+			Date:       date,
+			Status:     DateLoaded,
+			Counts:     &counts,
+			Validation: &loadResponse.Validation,
+		}
+	}
+
+	if errorKind(err) == ConflictError {
+		return DateLoadSummary{Date: date, Status: DateSkipped}
+	}
+
+	fmt.Printf("Error while loading data of '%s' | %v\n", date, err)
+	return DateLoadSummary{Date: date, Status: DateFailed, Error: err.Error()}
+}
+
+/*
+	Returns the dates from @from to @to, both included, in yyyy-MM-DD
+	format. Ranges longer than c.MaxRangeLoadDays are rejected.
+*/
+func getDateRange(from string, to string) ([]string, error) {
+	fromDate, err := time.Parse(c.DateLayout, from)
 	if err != nil {
-		return 0, 0, fmt.Errorf("invalid page parameters")
+		return nil, newValidationError(InvalidDateCode, "invalid 'from' date")
 	}
 
-	pageSize, err := strconv.Atoi(pageSizeParam)
+	toDate, err := time.Parse(c.DateLayout, to)
 	if err != nil {
-		return 0, 0, fmt.Errorf("invalid page parameters")
+		return nil, newValidationError(InvalidDateCode, "invalid 'to' date")
 	}
 
-	if page < 0 || pageSize < 0 {
-		return 0, 0, fmt.Errorf("invalid page parameters")
+	if toDate.Before(fromDate) {
+		return nil, newValidationError(InvalidDateCode, "'from' must not be after 'to'")
 	}
 
-	return page, pageSize, nil
+	if toDate.Sub(fromDate).Hours()/24 >= float64(c.MaxRangeLoadDays) {
+		return nil, newValidationError(InvalidDateCode,
+			fmt.Sprintf("date ranges can't be longer than %d days", c.MaxRangeLoadDays))
+	}
 
+	var dates []string
+	for date := fromDate; !date.After(toDate); date = date.AddDate(0, 0, 1) {
+		dates = append(dates, date.Format(c.DateLayout))
+	}
+
+	return dates, nil
 }
 
-func fetchBuyers(page int, pageSize int) ([]byte, error) {
-	res, err := fetchBuyersFromDB(page, pageSize)
+/*
+	Validates the date parameter by checking if it matches
+	the layout used by Dgraph to store dates: yyyy-MM-DD.
+	Returns an error if it doesn't and nil if it matches.
+*/
+func isDateParamValid(date string) error {
+	//date = ""
+	_, err := time.Parse(c.DateLayout, date)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (service *RestaurantService) fetchBuyers(page PageRequest) ([]byte, error) {
+	buyersCollection, err := service.store.Buyers().FindBuyers(page)
+	if err != nil {
+		return nil, newStorageError("error while fetching buyers", err)
+	}
+
+	jsonRes, err := json.Marshal(buyersCollection)
 	if err != nil {
 		return nil, err
 	}
 
-	return res, nil
+	return jsonRes, nil
 }
 
 /*
@@ -126,8 +390,31 @@ func isProductParamValid(products string) bool {
 	return true
 }
 
-func fetchProducts(productIds string) ([]byte, error) {
-	productsJson, err := fetchProductsFromDB(productIds)
+/*
+	Returns the saved products among the comma separated @productIds,
+	along with the ids that aren't saved.
+*/
+func (service *RestaurantService) fetchProducts(productIds string) ([]byte, error) {
+	ids := strings.Split(productIds, ",")
+	products, err := service.store.Products().FindProductsByIds(ids)
+	if err != nil {
+		return nil, newStorageError("error while fetching products", err)
+	}
+
+	found := map[string]bool{}
+	for _, product := range products {
+		found[product.ProductId] = true
+	}
+
+	missing := []string{}
+	for _, productId := range ids {
+		if !found[productId] {
+			missing = append(missing, productId)
+			found[productId] = true
+		}
+	}
+
+	productsJson, err := json.Marshal(&ProductsById{Products: products, Missing: missing})
 	if err != nil {
 		return nil, err
 	}
@@ -159,143 +446,140 @@ func isBuyerIdParamValid(buyerId string) bool {
 	return (digitCounter + letterCounter) == len(buyerId)
 }
 
-func getBuyerRequestParams(pageBParam string, pageSizeBParam string, pageTParam string, pageSizeTParam string) (BuyerRequestParams, error) {
-	if pageBParam != "" && pageSizeBParam != "" && pageTParam != "" && pageSizeTParam != "" {
-		pageB, err := strconv.Atoi(pageBParam)
-		if err != nil {
-			return BuyerRequestParams{}, err
-		}
-
-		pageSizeB, err := strconv.Atoi(pageSizeBParam)
-		if err != nil {
-			return BuyerRequestParams{}, err
-		}
-
-		pageT, err := strconv.Atoi(pageTParam)
-		if err != nil {
-			return BuyerRequestParams{}, err
-		}
-
-		pageSizeT, err := strconv.Atoi(pageSizeTParam)
-		if err != nil {
-			return BuyerRequestParams{}, err
-		}
-
-		if pageB <= 0 ||
-			pageSizeB <= 0 ||
-			pageT <= 0 ||
-			pageSizeT <= 0 {
-			return BuyerRequestParams{}, fmt.Errorf("invalid page parameters")
-		}
-
-		return BuyerRequestParams{
-			PageBParam:     pageB,
-			PageSizeBParam: pageSizeB,
-			PageTParam:     pageT,
-			PageSizeTParam: pageSizeT,
-		}, nil
+/*
+	Returns the buyer @buyerId with a page of their transaction
+	history, a page of the buyers with the same IP and product
+	recommendations, or errBuyerNotFound if there's no such buyer.
+*/
+func (service *RestaurantService) fetchBuyer(buyerId string, buyerReqParams BuyerRequestParams) ([]byte, error) {
+	buyerName, err := service.store.Buyers().FindBuyerName(buyerId)
+	if err != nil {
+		return nil, newStorageError("error while fetching the buyer", err)
 	}
 
-	return BuyerRequestParams{}, fmt.Errorf("missing parameter")
-}
-
-func fetchBuyer(buyerId string, buyerReqParams BuyerRequestParams) ([]byte, error) {
-	buyerTransactions, err := fetchTransactionHistoryFromDB(buyerId)
+	// The IPs and the products of the whole history are needed for the other buyers and the recommendations
+	activity, err := service.store.Transactions().FindBuyerActivity(buyerId)
 	if err != nil {
-		return nil, err
+		return nil, newStorageError("error while fetching the activity of the buyer", err)
 	}
 
-	var buyerIps []string
-
-	for _, transaction := range buyerTransactions.Transactions {
-		buyerIps = append(buyerIps, transaction.Ip)
+	transactionHistory, err := service.store.Transactions().FindTransactionHistoryPage(buyerId, buyerReqParams.Transactions)
+	if err != nil {
+		return nil, newStorageError("error while fetching the transaction history", err)
 	}
 
-	transactionsForIps, err := fetchTransactionsForIpsFromDB(buyerIps)
+	buyersWithSameIp, err := service.store.Buyers().FindBuyersByIps(activity.Ips, buyerId, buyerReqParams.BuyersWithSameIp)
 	if err != nil {
-		return nil, err
+		return nil, newStorageError("error while fetching the buyers with the same IP", err)
 	}
 
-	var buyerIds []string
+	recommendedProducts, err := service.fetchProductRecommendations(activity.ProductIds)
+	if err != nil {
+		return nil, newStorageError("error while fetching the product recommendations", err)
+	}
 
-	for _, transaction := range transactionsForIps {
-		buyerIds = append(buyerIds, transaction.BuyerId)
+	dataToReturn := &BuyerIdEndpoint{
+		Name:                buyerName,
+		TransactionHistory:  transactionHistory,
+		BuyersWithSameIp:    buyersWithSameIp,
+		RecommendedProducts: recommendedProducts,
 	}
 
-	buyersById, err := fetchBuyersByIdFromDB(buyerIds, buyerId)
+	dataToReturnAsJson, err := json.Marshal(dataToReturn)
+
 	if err != nil {
-		fmt.Printf("error while fetching buyer | %v\n", err)
+		fmt.Printf("Error while marshalling dataToReturn | %v\n", err)
 		return nil, err
 	}
 
-	recommendedProducts, err := fetchProductRecommendationsFromDB(buyerTransactions.Transactions)
+	return dataToReturnAsJson, nil
+}
+
+/*
+	Returns the buyer @buyerId, or nil if there's no such buyer.
+*/
+func (service *RestaurantService) findBuyer(buyerId string) (*Buyer, error) {
+	buyers, err := service.store.Buyers().FindBuyersByIds([]string{buyerId}, "", PageRequest{First: 1})
 	if err != nil {
-		fmt.Printf("error while fetching buyer | %v\n", err)
 		return nil, err
 	}
 
-	transactionHistory, buyersWithSameIp := getPagedCollections(buyerReqParams, buyerTransactions, buyersById)
+	if len(buyers.Buyers) == 0 {
+		return nil, nil
+	}
 
-	buyerName, err := fetchBuyerNameFromDB(buyerId)
+	return &buyers.Buyers[0], nil
+}
+
+/*
+	Recommends products bought along with @boughtProducts that aren't
+	among them.
+*/
+func (service *RestaurantService) fetchProductRecommendations(boughtProducts []string) ([]Product, error) {
+	similarProductTransactions, err := service.store.Transactions().FindTransactionsWithProducts(boughtProducts, 10)
 	if err != nil {
-		fmt.Printf("error while fetching buyer | %v\n", err)
+		fmt.Println(err)
 		return nil, err
 	}
 
-	dataToReturn := &BuyerIdEndpoint{
-		Name:                buyerName,
-		TransactionHistory:  transactionHistory,
-		BuyersWithSameIp:    buyersWithSameIp,
-		RecommendedProducts: recommendedProducts,
+	var productIdsBuffer []string
+	for _, transaction := range similarProductTransactions {
+		productIdsBuffer = append(productIdsBuffer, transaction.Products...)
 	}
 
-	dataToReturnAsJson, err := json.Marshal(dataToReturn)
+	//Filter out bought products
+	productIds := filterBoughtProductIds(boughtProducts, productIdsBuffer)
 
+	products, err := service.store.Products().FindProductsByIds(productIds)
 	if err != nil {
-		fmt.Printf("Error while marshalling dataToReturn | %v\n", err)
 		return nil, err
 	}
 
-	return dataToReturnAsJson, nil
+	recommendedProducts := filterRepeatedProducts(products)
+
+	return recommendedProducts, nil
 }
 
-/*
-	Applies pagination to the buyer's transactions and to the list of
-	buyers using the same IP.
-*/
-func getPagedCollections(buyerReqParams BuyerRequestParams, buyerTransactions TransactionCollection,
-	buyersById BuyerCollection) (TransactionCollection, BuyerCollection) {
-	pageB := buyerReqParams.PageBParam
-	pageSizeB := buyerReqParams.PageSizeBParam
-	pageT := buyerReqParams.PageTParam
-	pageSizeT := buyerReqParams.PageSizeTParam
-
-	var transactionHistory TransactionCollection
-
-	if ((pageT-1)*pageSizeT)+pageSizeT < buyerTransactions.Count {
-		transactionHistory = TransactionCollection{
-			Transactions: buyerTransactions.Transactions[(pageT-1)*pageSizeT : ((pageT-1)*pageSizeT)+pageSizeT],
-			Count:        buyerTransactions.Count,
+func filterBoughtProductIds(boughtProducts []string, productIdsBuffer []string) []string {
+	var filteredProductIds []string
+
+	for _, id := range productIdsBuffer {
+		if !f.ArrayContains(boughtProducts, id) && !f.ArrayContains(filteredProductIds, id) {
+			filteredProductIds = append(filteredProductIds, id)
 		}
+	}
+
+	return filteredProductIds
+}
+
+func filterRepeatedProducts(products []Product) []Product {
+	//Shuffle the array so that each time, new recommendations
+	//are generated.
+	rand.Seed(time.Now().UnixNano())
+	rand.Shuffle(len(products), func(i, j int) {
+		products[i], products[j] = products[j], products[i]
+	})
+
+	var addedIds []string
+	var result []Product = []Product{}
+
+	var max int
+	if len(products) < c.MaxProductRecommendations {
+		max = len(products)
 	} else {
-		transactionHistory = TransactionCollection{
-			Transactions: buyerTransactions.Transactions[(pageT-1)*pageSizeT:],
-			Count:        buyerTransactions.Count,
-		}
+		max = c.MaxProductRecommendations
 	}
-	var buyersWithSameIp BuyerCollection
 
-	if ((pageB-1)*pageSizeB)+pageSizeB < buyersById.Count {
-		buyersWithSameIp = BuyerCollection{
-			Buyers: buyersById.Buyers[(pageB-1)*pageSizeB : ((pageB-1)*pageSizeB)+pageSizeB],
-			Count:  buyersById.Count,
+	for _, product := range products {
+		if len(addedIds) >= max {
+			break
 		}
-	} else {
-		buyersWithSameIp = BuyerCollection{
-			Buyers: buyersById.Buyers[(pageB-1)*pageSizeB:],
-			Count:  buyersById.Count,
+
+		if !f.ArrayContains(addedIds, product.ProductId) {
+			addedIds = append(addedIds, product.ProductId)
+			result = append(result, product)
 		}
 	}
 
-	return transactionHistory, buyersWithSameIp
+	return result
 }
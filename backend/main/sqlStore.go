@@ -0,0 +1,726 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//go:embed migrations/sqlite/*.sql
+var sqlMigrations embed.FS
+
+/*
+	Store backed by an embedded SQLite database, for deployments
+	that can't run a Dgraph cluster.
+*/
+type sqlStore struct {
+	db *sql.DB
+}
+
+/*
+	The loader shares a single transaction between its goroutines,
+	so statements are serialized here.
+*/
+type sqlTxn struct {
+	mutex sync.Mutex
+	tx    *sql.Tx
+}
+
+type sqlBuyerRepository struct {
+	db *sql.DB
+}
+
+type sqlProductRepository struct {
+	db *sql.DB
+}
+
+type sqlTransactionRepository struct {
+	db *sql.DB
+}
+
+/*
+	Opens the SQLite database at @path, creating it if it doesn't
+	exist. Its migrations aren't applied until Migrate is called.
+*/
+func newSqlStore(path string) (*sqlStore, error) {
+	dsn := fmt.Sprintf("file:%s?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000", path)
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening database '%s' | %w", path, err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error while creating schema_migrations table | %w", err)
+	}
+
+	return &sqlStore{db: db}, nil
+}
+
+/*
+	Returns the migrations in migrations/sqlite, with the time the
+	ones recorded in the schema_migrations table were applied. The
+	version of a migration is the number its file name starts with.
+*/
+func (store *sqlStore) Migrations() ([]MigrationStatus, error) {
+	fileNames, err := fs.Glob(sqlMigrations, "migrations/sqlite/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(fileNames)
+
+	var statuses []MigrationStatus
+	for _, fileName := range fileNames {
+		baseName := strings.TrimSuffix(fileName[strings.LastIndex(fileName, "/")+1:], ".sql")
+		versionName := strings.SplitN(baseName, "_", 2)
+
+		version, err := strconv.Atoi(versionName[0])
+		if err != nil || len(versionName) != 2 {
+			return nil, fmt.Errorf("invalid migration file name '%s'", fileName)
+		}
+
+		status := MigrationStatus{Version: version, Name: versionName[1], file: fileName}
+
+		var appliedAt string
+		err = store.db.QueryRow(`SELECT applied_at FROM schema_migrations WHERE version = ?`, version).Scan(&appliedAt)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		if err == nil {
+			applied, err := time.Parse("2006-01-02 15:04:05", appliedAt)
+			if err != nil {
+				return nil, fmt.Errorf("invalid applied_at of migration '%s' | %w", fileName, err)
+			}
+			status.AppliedAt = &applied
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+/*
+	Applies, in order, every migration whose version isn't recorded
+	in the schema_migrations table yet, each in its own transaction.
+*/
+func (store *sqlStore) Migrate() error {
+	statuses, err := store.Migrations()
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			continue
+		}
+
+		statements, err := sqlMigrations.ReadFile(status.file)
+		if err != nil {
+			return err
+		}
+
+		tx, err := store.db.Begin()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(string(statements))
+		if err == nil {
+			_, err = tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, status.Version)
+		}
+
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error while applying migration '%s' | %w", status.file, err)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Applied migration %s\n", status.file)
+	}
+
+	return nil
+}
+
+func (store *sqlStore) Buyers() BuyerRepository {
+	return &sqlBuyerRepository{db: store.db}
+}
+
+func (store *sqlStore) Products() ProductRepository {
+	return &sqlProductRepository{db: store.db}
+}
+
+func (store *sqlStore) Transactions() TransactionRepository {
+	return &sqlTransactionRepository{db: store.db}
+}
+
+/*
+	SQLite allows a single writer, and a transaction that can't start
+	writing fails with "database is locked" instead of waiting for the
+	busy timeout, so loads have to run one at a time.
+*/
+func (store *sqlStore) MaxConcurrentLoads() int {
+	return 1
+}
+
+func (store *sqlStore) NewTxn() (Txn, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error while starting database transaction | %w", err)
+	}
+
+	return &sqlTxn{tx: tx}, nil
+}
+
+func (t *sqlTxn) Commit() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.tx.Commit()
+}
+
+func (t *sqlTxn) Discard() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.tx.Rollback()
+}
+
+/*
+	Runs @run with the underlying *sql.Tx while holding the txn lock.
+*/
+func withSqlTxn(txn Txn, run func(tx *sql.Tx) error) error {
+	t := txn.(*sqlTxn)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return run(t.tx)
+}
+
+/*
+	Returns a "?, ?, ..." placeholder list for @values along with
+	@values as query arguments.
+*/
+func inClause(values []string) (string, []interface{}) {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+
+	return strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", "), args
+}
+
+/*
+	Lets the same scanning code run against both *sql.DB and *sql.Tx.
+*/
+type sqlQueryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+const buyerColumns string = "buyer_id, name, age, COALESCE(date, '')"
+
+/*
+	Runs @query, which must select buyerColumns.
+*/
+func queryBuyers(db sqlQueryer, query string, args ...interface{}) ([]Buyer, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buyers := []Buyer{}
+	for rows.Next() {
+		var buyer Buyer
+		err = rows.Scan(&buyer.BuyerId, &buyer.Name, &buyer.Age, &buyer.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		buyer.Date, err = toOptionalStoredDate(buyer.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		buyers = append(buyers, buyer)
+	}
+
+	return buyers, rows.Err()
+}
+
+func (repository *sqlBuyerRepository) FindBuyers(page PageRequest) (BuyerCollection, error) {
+	var totalBuyers int
+	err := repository.db.QueryRow(`SELECT COUNT(*) FROM buyers`).Scan(&totalBuyers)
+	if err != nil {
+		return BuyerCollection{}, err
+	}
+
+	buyers, err := queryBuyers(repository.db,
+		`SELECT `+buyerColumns+` FROM buyers WHERE buyer_id > ? ORDER BY buyer_id LIMIT ?`,
+		page.After, page.limit())
+	if err != nil {
+		return BuyerCollection{}, err
+	}
+
+	return newBuyerCollection(buyers, totalBuyers, page), nil
+}
+
+func (repository *sqlBuyerRepository) FindBuyersByIds(buyerIds []string, excludedBuyerId string, page PageRequest) (BuyerCollection, error) {
+	if len(buyerIds) == 0 {
+		return newBuyerCollection(nil, 0, page), nil
+	}
+
+	placeholders, args := inClause(buyerIds)
+	args = append(args, excludedBuyerId)
+
+	var totalBuyers int
+	err := repository.db.QueryRow(`SELECT COUNT(*) FROM buyers
+		WHERE buyer_id IN (`+placeholders+`) AND buyer_id <> ?`, args...).Scan(&totalBuyers)
+	if err != nil {
+		return BuyerCollection{}, err
+	}
+
+	buyers, err := queryBuyers(repository.db,
+		`SELECT `+buyerColumns+` FROM buyers
+		WHERE buyer_id IN (`+placeholders+`) AND buyer_id <> ? AND buyer_id > ?
+		ORDER BY buyer_id LIMIT ?`, append(args, page.After, page.limit())...)
+	if err != nil {
+		fmt.Printf("Error while retrieving buyers: %v\n", err)
+		return BuyerCollection{}, err
+	}
+
+	return newBuyerCollection(buyers, totalBuyers, page), nil
+}
+
+func (repository *sqlBuyerRepository) FindBuyersByIps(ips []string, excludedBuyerId string, page PageRequest) (BuyerCollection, error) {
+	if len(ips) == 0 {
+		return newBuyerCollection(nil, 0, page), nil
+	}
+
+	placeholders, args := inClause(ips)
+	args = append(args, excludedBuyerId)
+	sharingIps := `buyer_id IN (SELECT DISTINCT buyer_id FROM transactions WHERE ip IN (` + placeholders + `)) AND buyer_id <> ?`
+
+	var totalBuyers int
+	err := repository.db.QueryRow(`SELECT COUNT(*) FROM buyers WHERE `+sharingIps, args...).Scan(&totalBuyers)
+	if err != nil {
+		return BuyerCollection{}, err
+	}
+
+	buyers, err := queryBuyers(repository.db,
+		`SELECT `+buyerColumns+` FROM buyers WHERE `+sharingIps+` AND buyer_id > ?
+		ORDER BY buyer_id LIMIT ?`, append(args, page.After, page.limit())...)
+	if err != nil {
+		fmt.Printf("Error while retrieving buyers with the same IP: %v\n", err)
+		return BuyerCollection{}, err
+	}
+
+	return newBuyerCollection(buyers, totalBuyers, page), nil
+}
+
+func (repository *sqlBuyerRepository) FindBuyerName(buyerId string) (string, error) {
+	var name string
+	err := repository.db.QueryRow(`SELECT name FROM buyers WHERE buyer_id = ?`, buyerId).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", errBuyerNotFound
+	}
+
+	return name, err
+}
+
+func (repository *sqlBuyerRepository) SaveBuyers(txn Txn, buyers []Buyer) ([]Buyer, error) {
+	var saved []Buyer
+
+	err := withSqlTxn(txn, func(tx *sql.Tx) error {
+		statement, err := tx.Prepare(`INSERT INTO buyers (buyer_id, name, age, date) VALUES (?, ?, ?, NULLIF(?, ''))
+			ON CONFLICT (buyer_id) DO NOTHING`)
+		if err != nil {
+			return err
+		}
+		defer statement.Close()
+
+		for _, buyer := range buyers {
+			res, err := statement.Exec(buyer.BuyerId, buyer.Name, buyer.Age, buyer.Date)
+			if err != nil {
+				fmt.Printf("Error while persisting buyers to database: %v", err)
+				return err
+			}
+
+			inserted, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if inserted > 0 {
+				saved = append(saved, buyer)
+			}
+		}
+
+		return nil
+	})
+
+	return saved, err
+}
+
+func (repository *sqlBuyerRepository) DeleteOrphanBuyers(txn Txn, date string) (int, error) {
+	var deleted int64
+
+	err := withSqlTxn(txn, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM buyers
+			WHERE date = ? AND buyer_id NOT IN (SELECT buyer_id FROM transactions)`, date)
+		if err != nil {
+			return err
+		}
+
+		deleted, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error while deleting buyers of '%s' | %w", date, err)
+	}
+
+	return int(deleted), nil
+}
+
+func (repository *sqlProductRepository) FindProductsByIds(productIds []string) ([]Product, error) {
+	if len(productIds) == 0 {
+		return []Product{}, nil
+	}
+
+	placeholders, args := inClause(productIds)
+	rows, err := repository.db.Query(`SELECT product_id, name, price, COALESCE(date, '') FROM products
+		WHERE product_id IN (`+placeholders+`) ORDER BY id`, args...)
+	if err != nil {
+		fmt.Printf("Error while fetching products: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []Product{}
+	for rows.Next() {
+		var product Product
+		err = rows.Scan(&product.ProductId, &product.Name, &product.Price, &product.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		product.Date, err = toOptionalStoredDate(product.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		products = append(products, product)
+	}
+
+	return products, rows.Err()
+}
+
+func (repository *sqlProductRepository) SaveProducts(txn Txn, products []Product) ([]Product, error) {
+	var saved []Product
+
+	err := withSqlTxn(txn, func(tx *sql.Tx) error {
+		statement, err := tx.Prepare(`INSERT INTO products (product_id, name, price, date) VALUES (?, ?, ?, NULLIF(?, ''))
+			ON CONFLICT (product_id) DO NOTHING`)
+		if err != nil {
+			return err
+		}
+		defer statement.Close()
+
+		for _, product := range products {
+			res, err := statement.Exec(product.ProductId, product.Name, product.Price.String(), product.Date)
+			if err != nil {
+				fmt.Printf("Error while persisting new products | %v\n", err)
+				return err
+			}
+
+			inserted, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if inserted > 0 {
+				saved = append(saved, product)
+			}
+		}
+
+		return nil
+	})
+
+	return saved, err
+}
+
+func (repository *sqlProductRepository) DeleteOrphanProducts(txn Txn, date string) (int, error) {
+	var deleted int64
+
+	err := withSqlTxn(txn, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM products
+			WHERE date = ? AND product_id NOT IN (SELECT product_id FROM transaction_products)`, date)
+		if err != nil {
+			return err
+		}
+
+		deleted, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error while deleting products of '%s' | %w", date, err)
+	}
+
+	return int(deleted), nil
+}
+
+const transactionColumns string = "id, transaction_id, buyer_id, ip, device, date"
+
+/*
+	Runs @query, which must select transactionColumns, and fills in
+	the products of each returned transaction.
+*/
+func (repository *sqlTransactionRepository) queryTransactions(query string, args ...interface{}) ([]Transaction, error) {
+	rows, err := repository.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var rowIds []string
+	transactions := []Transaction{}
+	for rows.Next() {
+		var rowId int64
+		var transaction Transaction
+		err = rows.Scan(&rowId, &transaction.TransactionId, &transaction.BuyerId,
+			&transaction.Ip, &transaction.Device, &transaction.Date)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		transaction.Date, err = toStoredDate(transaction.Date)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		// Encoded as [] like in the other stores when it has no products
+		transaction.Products = []string{}
+		rowIds = append(rowIds, strconv.FormatInt(rowId, 10))
+		transactions = append(transactions, transaction)
+	}
+
+	rows.Close()
+	if rows.Err() != nil || len(transactions) == 0 {
+		return transactions, rows.Err()
+	}
+
+	placeholders, productArgs := inClause(rowIds)
+	productRows, err := repository.db.Query(`SELECT transaction_id, product_id FROM transaction_products
+		WHERE transaction_id IN (`+placeholders+`) ORDER BY transaction_id, position`, productArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer productRows.Close()
+
+	positions := make(map[string]int, len(rowIds))
+	for i, rowId := range rowIds {
+		positions[rowId] = i
+	}
+
+	for productRows.Next() {
+		var rowId, productId string
+		err = productRows.Scan(&rowId, &productId)
+		if err != nil {
+			return nil, err
+		}
+
+		transaction := &transactions[positions[rowId]]
+		transaction.Products = append(transaction.Products, productId)
+	}
+
+	return transactions, productRows.Err()
+}
+
+func (repository *sqlTransactionRepository) FindTransactionHistoryPage(buyerId string, page PageRequest) (TransactionCollection, error) {
+	var totalTransactions int
+	err := repository.db.QueryRow(`SELECT COUNT(*) FROM transactions WHERE buyer_id = ?`, buyerId).Scan(&totalTransactions)
+	if err != nil {
+		return TransactionCollection{}, err
+	}
+
+	transactions, err := repository.queryTransactions(`SELECT `+transactionColumns+` FROM transactions
+		WHERE buyer_id = ? AND transaction_id > ?
+		ORDER BY transaction_id LIMIT ?`, buyerId, page.After, page.limit())
+	if err != nil {
+		fmt.Printf("Error while retrieving transaction history for buyer %s: %v\n", buyerId, err)
+		return TransactionCollection{}, err
+	}
+
+	return newTransactionCollection(transactions, totalTransactions, page), nil
+}
+
+func (repository *sqlTransactionRepository) FindBuyerActivity(buyerId string) (BuyerActivity, error) {
+	ips, err := queryStrings(repository.db, `SELECT DISTINCT ip FROM transactions WHERE buyer_id = ?`, buyerId)
+	if err != nil {
+		return BuyerActivity{}, err
+	}
+
+	productIds, err := queryStrings(repository.db, `SELECT DISTINCT product_id FROM transaction_products
+		WHERE transaction_id IN (SELECT id FROM transactions WHERE buyer_id = ?)`, buyerId)
+	if err != nil {
+		return BuyerActivity{}, err
+	}
+
+	return BuyerActivity{Ips: ips, ProductIds: productIds}, nil
+}
+
+/*
+	Runs @query, which must select a single text column.
+*/
+func queryStrings(db sqlQueryer, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		err = rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+func (repository *sqlTransactionRepository) FindTransactionsWithProducts(productIds []string, first int) ([]Transaction, error) {
+	if len(productIds) == 0 {
+		return []Transaction{}, nil
+	}
+
+	placeholders, args := inClause(productIds)
+	args = append(args, first)
+
+	transactions, err := repository.queryTransactions(`SELECT `+transactionColumns+` FROM transactions
+		WHERE id IN (SELECT transaction_id FROM transaction_products WHERE product_id IN (`+placeholders+`))
+		ORDER BY id LIMIT ?`, args...)
+	if err != nil {
+		fmt.Printf("Error while fetching transactions with products bought by this buyer: %v\n", err)
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+func (repository *sqlTransactionRepository) IsDateSynchronized(txn Txn, date string) (bool, error) {
+	var synchronized bool
+
+	err := withSqlTxn(txn, func(tx *sql.Tx) error {
+		return tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM transactions WHERE date = ?)`, date).Scan(&synchronized)
+	})
+
+	return synchronized, err
+}
+
+func (repository *sqlTransactionRepository) FindLatestSynchronizedDate(txn Txn) (string, error) {
+	var latest sql.NullString
+
+	err := withSqlTxn(txn, func(tx *sql.Tx) error {
+		return tx.QueryRow(`SELECT MAX(date) FROM transactions`).Scan(&latest)
+	})
+
+	return latest.String, err
+}
+
+func (repository *sqlTransactionRepository) SaveTransactions(txn Txn, transactions []Transaction) (int, error) {
+	saved := 0
+
+	err := withSqlTxn(txn, func(tx *sql.Tx) error {
+		transactionStatement, err := tx.Prepare(`INSERT INTO transactions
+			(transaction_id, buyer_id, ip, device, date) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (transaction_id) DO NOTHING`)
+		if err != nil {
+			return err
+		}
+		defer transactionStatement.Close()
+
+		productStatement, err := tx.Prepare(`INSERT INTO transaction_products
+			(transaction_id, position, product_id) VALUES (?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer productStatement.Close()
+
+		for _, transaction := range transactions {
+			res, err := transactionStatement.Exec(transaction.TransactionId, transaction.BuyerId,
+				transaction.Ip, transaction.Device, transaction.Date)
+			if err != nil {
+				fmt.Printf("Error while persisting transactions: %v\n", err)
+				return err
+			}
+
+			inserted, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if inserted == 0 {
+				continue
+			}
+			saved++
+
+			rowId, err := res.LastInsertId()
+			if err != nil {
+				return err
+			}
+
+			for position, productId := range transaction.Products {
+				_, err = productStatement.Exec(rowId, position, productId)
+				if err != nil {
+					fmt.Printf("Error while persisting transactions: %v\n", err)
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return saved, err
+}
+
+func (repository *sqlTransactionRepository) DeleteTransactionsOfDate(txn Txn, date string) (int, error) {
+	var deleted int64
+
+	// The products of each transaction go with it through ON DELETE CASCADE
+	err := withSqlTxn(txn, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM transactions WHERE date = ?`, date)
+		if err != nil {
+			return err
+		}
+
+		deleted, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error while deleting transactions of '%s' | %w", date, err)
+	}
+
+	return int(deleted), nil
+}
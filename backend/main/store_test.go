@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	c "module/constants"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	d "github.com/shopspring/decimal"
+)
+
+/*
+	Returns every store that can run without external services, each
+	holding the same buyers, products and transactions.
+*/
+func newTestStores(t *testing.T) map[string]Store {
+	t.Helper()
+
+	sqlStore, err := newSqlStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlStore.db.Close() })
+
+	err = sqlStore.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := map[string]Store{c.MemoryStorage: newMemoryStore(), c.SqliteStorage: sqlStore}
+	for _, store := range stores {
+		saveTestData(t, store)
+	}
+
+	return stores
+}
+
+func saveTestData(t *testing.T, store Store) {
+	t.Helper()
+
+	buyers := []Buyer{}
+	for _, id := range []string{"b1", "b2", "b3", "b4"} {
+		buyers = append(buyers, Buyer{BuyerId: id, Name: "Buyer " + id, Age: 30, Date: testDate, Type: c.BuyerType})
+	}
+
+	products := []Product{
+		{ProductId: "p1", Name: "Rice", Price: d.NewFromInt(10), Date: testDate, Type: c.ProductType},
+		{ProductId: "p2", Name: "Tea", Price: d.NewFromInt(3), Date: testDate, Type: c.ProductType},
+	}
+
+	transaction := func(id string, buyerId string, ip string, products ...string) Transaction {
+		return Transaction{TransactionId: id, BuyerId: buyerId, Ip: ip, Device: "mac", Products: products, Date: testDate, Type: c.TransactionType}
+	}
+	transactions := []Transaction{
+		transaction("t1", "b1", "1.1.1.1", "p1", "p2"),
+		transaction("t2", "b2", "1.1.1.1", "p1"),
+		transaction("t3", "b3", "2.2.2.2", "p2"),
+		transaction("t4", "b4", "1.1.1.1"),
+		transaction("t5", "b2", "1.1.1.1", "p2"),
+		transaction("t6", "b1", "3.3.3.3", "p1"),
+	}
+
+	txn, err := store.NewTxn()
+	if err == nil {
+		_, err = store.Buyers().SaveBuyers(txn, buyers)
+	}
+	if err == nil {
+		_, err = store.Products().SaveProducts(txn, products)
+	}
+	if err == nil {
+		_, err = store.Transactions().SaveTransactions(txn, transactions)
+	}
+	if err == nil {
+		err = txn.Commit()
+	}
+	if err != nil {
+		t.Fatalf("error while saving the test data | %v", err)
+	}
+}
+
+func buyerIdsOf(buyers BuyerCollection) []string {
+	ids := []string{}
+	for _, buyer := range buyers.Buyers {
+		ids = append(ids, buyer.BuyerId)
+	}
+
+	return ids
+}
+
+func TestFindBuyersByIps(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ips := []string{"1.1.1.1", "3.3.3.3"}
+
+			first, err := store.Buyers().FindBuyersByIps(ips, "b1", PageRequest{First: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(buyerIdsOf(first), []string{"b2"}) || !first.PageInfo.HasNextPage || first.TotalCount != 2 {
+				t.Errorf("got first page %v, total %d, next %v; want [b2], 2, true",
+					buyerIdsOf(first), first.TotalCount, first.PageInfo.HasNextPage)
+			}
+
+			after, err := decodeCursor(first.PageInfo.EndCursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			second, err := store.Buyers().FindBuyersByIps(ips, "b1", PageRequest{First: 1, After: after})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(buyerIdsOf(second), []string{"b4"}) || second.PageInfo.HasNextPage {
+				t.Errorf("got second page %v, next %v; want [b4], false", buyerIdsOf(second), second.PageInfo.HasNextPage)
+			}
+
+			none, err := store.Buyers().FindBuyersByIps(nil, "b1", PageRequest{First: 5})
+			if err != nil || len(none.Buyers) != 0 || none.TotalCount != 0 {
+				t.Errorf("got %v, %v without IPs, want no buyers", buyerIdsOf(none), err)
+			}
+		})
+	}
+}
+
+func TestFindBuyerActivity(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			activity, err := store.Transactions().FindBuyerActivity("b1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sort.Strings(activity.Ips)
+			sort.Strings(activity.ProductIds)
+			want := BuyerActivity{Ips: []string{"1.1.1.1", "3.3.3.3"}, ProductIds: []string{"p1", "p2"}}
+			if !reflect.DeepEqual(activity, want) {
+				t.Errorf("got %+v, want %+v", activity, want)
+			}
+
+			activity, err = store.Transactions().FindBuyerActivity("b404")
+			if err != nil || len(activity.Ips) != 0 || len(activity.ProductIds) != 0 {
+				t.Errorf("got %+v, %v for an unknown buyer, want no activity", activity, err)
+			}
+		})
+	}
+}
+
+/*
+	A transaction without products encodes them as [] whatever the
+	store, so the responses are the same with every backend.
+*/
+func TestTransactionsWithoutProductsEncodeEmptyProducts(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			transactions, err := store.Transactions().FindTransactionHistoryPage("b4", PageRequest{First: 5})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(transactions.Transactions) != 1 {
+				t.Fatalf("got %d transactions of b4, want 1", len(transactions.Transactions))
+			}
+
+			encoded, err := json.Marshal(transactions.Transactions[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(encoded), `"Products":[]`) {
+				t.Errorf("got %s, want empty Products", encoded)
+			}
+		})
+	}
+}
+
+/*
+	SQLite allows a single writer, so the dates of a range are loaded
+	one at a time instead of failing with "database is locked".
+*/
+func TestSqliteLoadsDateRange(t *testing.T) {
+	store := newTestStores(t)[c.SqliteStorage]
+	service := &RestaurantService{store: store, source: newFakeDataSource()}
+
+	dates := []string{"2020-08-18", "2020-08-19", "2020-08-20", "2020-08-21", "2020-08-22", "2020-08-23"}
+	response := service.loadDateRange(dates, false, nil, func(summary DateLoadSummary) {})
+	if response.Loaded != len(dates) {
+		for _, summary := range response.Dates {
+			if summary.Status != DateLoaded {
+				t.Errorf("%s: %s %s", summary.Date, summary.Status, summary.Error)
+			}
+		}
+	}
+}
+
+func TestSqliteNewTxnReportsBeginErrors(t *testing.T) {
+	store := newTestStores(t)[c.SqliteStorage].(*sqlStore)
+	store.db.Close()
+
+	txn, err := store.NewTxn()
+	if err == nil || txn != nil {
+		t.Errorf("got %v, %v when the database is closed, want an error", txn, err)
+	}
+}
+
+const secondTestDate string = "2020-08-18"
+
+/*
+	Returns a data source whose feeds share buyer b1 and product p1
+	with the test data, and add buyer @buyerId, product @productId
+	and the transactions @transactionIds.
+*/
+func newSecondDateSource(buyerId string, productId string, transactionIds ...string) *fakeDataSource {
+	source := newFakeDataSource()
+	source.feeds[BuyersStage] = `[{"id":"b1","name":"Buyer b1","age":30},{"id":"` + buyerId + `","name":"New","age":20}]`
+	source.feeds[ProductsStage] = "p1'Rice'10\n" + productId + "'Soup'4\n"
+
+	source.feeds[TransactionsStage] = ""
+	for i, id := range transactionIds {
+		buyer := []string{buyerId, "b1"}[i%2]
+		source.feeds[TransactionsStage] += "#" + id + "\x00" + buyer + "\x005.5.5.5\x00mac\x00(p1," + productId + ")\x00\x00"
+	}
+
+	return source
+}
+
+/*
+	Returns the sorted ids of the buyers, products and transactions
+	saved in @store, among those the tests use.
+*/
+func savedTestIds(t *testing.T, store Store) (buyerIds []string, productIds []string, transactionIds []string) {
+	t.Helper()
+
+	buyers, err := store.Buyers().FindBuyers(PageRequest{First: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buyerIds = buyerIdsOf(buyers)
+
+	products, err := store.Products().FindProductsByIds([]string{"p1", "p2", "p3", "p4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	productIds = []string{}
+	for _, product := range products {
+		productIds = append(productIds, product.ProductId)
+	}
+	sort.Strings(productIds)
+
+	transactionIds = []string{}
+	for _, buyerId := range buyerIds {
+		transactions, err := store.Transactions().FindTransactionHistoryPage(buyerId, PageRequest{First: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, transaction := range transactions.Transactions {
+			transactionIds = append(transactionIds, transaction.TransactionId)
+		}
+	}
+	sort.Strings(transactionIds)
+
+	return buyerIds, productIds, transactionIds
+}
+
+func assertSavedTestIds(t *testing.T, store Store, buyerIds string, productIds string, transactionIds string) {
+	t.Helper()
+
+	buyers, products, transactions := savedTestIds(t, store)
+	if strings.Join(buyers, ",") != buyerIds {
+		t.Errorf("got buyers %v, want %s", buyers, buyerIds)
+	}
+	if strings.Join(products, ",") != productIds {
+		t.Errorf("got products %v, want %s", products, productIds)
+	}
+	if strings.Join(transactions, ",") != transactionIds {
+		t.Errorf("got transactions %v, want %s", transactions, transactionIds)
+	}
+}
+
+/*
+	Purging a date deletes its transactions and the buyers and
+	products only it introduced, and nothing of the other dates.
+*/
+func TestPurgeDate(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			service := &RestaurantService{store: store, source: newSecondDateSource("b5", "p3", "t7", "t8")}
+
+			_, err := service.loadDate(secondTestDate, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSavedTestIds(t, store, "b1,b2,b3,b4,b5", "p1,p2,p3", "t1,t2,t3,t4,t5,t6,t7,t8")
+
+			purged, err := service.purgeDate(secondTestDate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if purged.Transactions != 2 || purged.Buyers != 1 || purged.Products != 1 {
+				t.Errorf("got purged %+v, want 2 transactions, 1 buyer and 1 product", purged)
+			}
+			assertSavedTestIds(t, store, "b1,b2,b3,b4", "p1,p2", "t1,t2,t3,t4,t5,t6")
+
+			if !isTestDateSynchronized(t, store) {
+				t.Errorf("%s isn't synchronized after purging %s", testDate, secondTestDate)
+			}
+		})
+	}
+}
+
+/*
+	A forced reload replaces the data of the date in the same
+	transaction, so the old data stays when the reload fails.
+*/
+func TestForcedReload(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			service := &RestaurantService{store: store, source: newSecondDateSource("b5", "p3", "t7", "t8")}
+
+			_, err := service.loadDate(secondTestDate, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			failing := newSecondDateSource("b6", "p4", "t9")
+			failing.readFails[TransactionsStage] = true
+			service.source = failing
+
+			_, err = service.loadDate(secondTestDate, true, nil)
+			if err == nil {
+				t.Fatal("the reload didn't fail")
+			}
+			assertSavedTestIds(t, store, "b1,b2,b3,b4,b5", "p1,p2,p3", "t1,t2,t3,t4,t5,t6,t7,t8")
+
+			service.source = newSecondDateSource("b6", "p4", "t9")
+			_, err = service.loadDate(secondTestDate, true, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSavedTestIds(t, store, "b1,b2,b3,b4,b6", "p1,p2,p4", "t1,t2,t3,t4,t5,t6,t9")
+		})
+	}
+}
+
+/*
+	A dry run reports what the load would save and what's saved
+	already, and leaves the store and the quarantine as they were.
+*/
+func TestDryRunDate(t *testing.T) {
+	store := newMemoryStore()
+	saveTestData(t, store)
+
+	quarantine, err := newFileQuarantineRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := newSecondDateSource("b5", "p3", "t7", "t8")
+	source.feeds[TransactionsStage] += transactionRecord("#t9", "b1", "5.5.5.5", "toaster", "(p1)")
+	service := &RestaurantService{store: store, source: source, quarantine: quarantine}
+
+	dryRun, err := service.dryRunDate(secondTestDate, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := LoadCounts{Buyers: 1, Products: 1, Transactions: 2, Quarantined: 1}
+	if dryRun.New != want {
+		t.Errorf("got new counts %+v, want %+v", dryRun.New, want)
+	}
+	if dryRun.Existing.Buyers != 1 || dryRun.Existing.Products != 1 {
+		t.Errorf("got existing counts %+v, want buyer b1 and product p1", dryRun.Existing)
+	}
+
+	assertSavedTestIds(t, store, "b1,b2,b3,b4", "p1,p2", "t1,t2,t3,t4,t5,t6")
+
+	txn, err := store.NewTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Discard()
+
+	synchronized, err := store.Transactions().IsDateSynchronized(txn, secondTestDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if synchronized {
+		t.Errorf("%s is synchronized after a dry run", secondTestDate)
+	}
+
+	records, err := quarantine.FindRecords(secondTestDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("got %d quarantined records after a dry run, want none", len(records))
+	}
+}
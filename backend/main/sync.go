@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	c "module/constants"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	SyncSubmitted string = "submitted"
+	SyncUpToDate  string = "up-to-date"
+	SyncSkipped   string = "skipped"
+	SyncFailed    string = "failed"
+)
+
+/*
+	Scheduled synchronization. When there are dates to load, Job is
+	the job that loads them, as it is when the history is fetched.
+*/
+type SyncRun struct {
+	Id        string
+	State     string
+	From      string   `json:",omitempty"`
+	To        string   `json:",omitempty"`
+	Dates     []string `json:",omitempty"`
+	JobId     string   `json:",omitempty"`
+	Job       *Job     `json:",omitempty"`
+	Error     string   `json:",omitempty"`
+	StartedAt time.Time
+}
+
+type SyncRepository interface {
+	SaveRun(run SyncRun) error
+	// Returns all the runs, most recent first.
+	FindRuns() ([]SyncRun, error)
+}
+
+/*
+	Keeps each synchronization run as a JSON file in a directory.
+*/
+type fileSyncRepository struct {
+	dir   string
+	mutex sync.RWMutex
+}
+
+/*
+	Synchronizes the restaurant data on a cron schedule. Each run
+	loads the dates up to yesterday that the previous runs didn't
+	load, so the dates missed while the server was down, or whose
+	load failed, are caught up on.
+*/
+type SyncScheduler struct {
+	cron     *cron.Cron
+	history  SyncRepository
+	service  *RestaurantService
+	jobs     *JobManager
+	mutex    sync.Mutex
+	lastRun  *SyncRun
+	schedule string
+}
+
+func newFileSyncRepository(dir string) (*fileSyncRepository, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating sync history directory '%s' | %w", dir, err)
+	}
+
+	return &fileSyncRepository{dir: dir}, nil
+}
+
+func (repository *fileSyncRepository) SaveRun(run SyncRun) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	// The job is only attached when the history is fetched
+	run.Job = nil
+
+	jsonRun, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filepath.Join(repository.dir, run.Id+".json.tmp")
+	err = os.WriteFile(tmpPath, jsonRun, 0644)
+	if err != nil {
+		return fmt.Errorf("error while saving sync run '%s' | %w", run.Id, err)
+	}
+
+	return os.Rename(tmpPath, filepath.Join(repository.dir, run.Id+".json"))
+}
+
+func (repository *fileSyncRepository) FindRuns() ([]SyncRun, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+
+	paths, err := filepath.Glob(filepath.Join(repository.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	runs := []SyncRun{}
+	for _, path := range paths {
+		jsonRun, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading sync run file '%s' | %w", path, err)
+		}
+
+		var run SyncRun
+		err = json.Unmarshal(jsonRun, &run)
+		if err != nil {
+			return nil, fmt.Errorf("error while unmarshalling sync run file '%s' | %w", path, err)
+		}
+
+		runs = append(runs, run)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+
+	return runs, nil
+}
+
+/*
+	Returns a scheduler running on the cron expression @schedule,
+	evaluated in UTC unless it starts with CRON_TZ=. An empty
+	@schedule disables the scheduled synchronization, but the history
+	can still be fetched.
+*/
+func newSyncScheduler(schedule string, history SyncRepository, service *RestaurantService, jobs *JobManager) (*SyncScheduler, error) {
+	scheduler := &SyncScheduler{
+		cron:     cron.New(cron.WithLocation(time.UTC)),
+		history:  history,
+		service:  service,
+		jobs:     jobs,
+		schedule: schedule,
+	}
+
+	if schedule == "" {
+		return scheduler, nil
+	}
+
+	_, err := scheduler.cron.AddFunc(schedule, func() {
+		scheduler.synchronize(time.Now().UTC())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid sync schedule '%s' | %w", schedule, err)
+	}
+
+	return scheduler, nil
+}
+
+func (scheduler *SyncScheduler) start() {
+	if scheduler.schedule == "" {
+		return
+	}
+
+	fmt.Printf("Synchronizing restaurant data on schedule '%s'\n", scheduler.schedule)
+	scheduler.cron.Start()
+}
+
+/*
+	Submits a job loading the dates that are still to be loaded,
+	up to the day before @now, and records the run. A run is skipped
+	while the job of the previous one is still going.
+*/
+func (scheduler *SyncScheduler) synchronize(now time.Time) SyncRun {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	run := SyncRun{StartedAt: now}
+
+	id, err := newId()
+	if err != nil {
+		fmt.Printf("Error while starting synchronization | %v\n", err)
+		return run
+	}
+	run.Id = id
+
+	if scheduler.isLastRunPending() {
+		run.State = SyncSkipped
+		run.Error = fmt.Sprintf("job '%s' of the previous synchronization is still running", scheduler.lastRun.JobId)
+		scheduler.save(run)
+		return run
+	}
+
+	yesterday := now.AddDate(0, 0, -1).Format(c.DateLayout)
+	run.Dates, err = scheduler.findPendingDates(yesterday)
+	if err != nil {
+		run.State = SyncFailed
+		run.Error = err.Error()
+		scheduler.save(run)
+		return run
+	}
+
+	if len(run.Dates) == 0 {
+		run.State = SyncUpToDate
+		scheduler.save(run)
+		return run
+	}
+
+	run.From = run.Dates[0]
+	run.To = run.Dates[len(run.Dates)-1]
+
+	job, err := scheduler.jobs.submitRangeJob(run.From, run.To, run.Dates, false)
+	if err != nil {
+		run.State = SyncFailed
+		run.Error = fmt.Sprintf("error while creating load job | %v", err)
+		scheduler.save(run)
+		return run
+	}
+
+	run.State = SyncSubmitted
+	run.JobId = job.Id
+	scheduler.lastRun = &run
+	scheduler.save(run)
+	return run
+}
+
+func (scheduler *SyncScheduler) isLastRunPending() bool {
+	if scheduler.lastRun == nil {
+		return false
+	}
+
+	job, err := scheduler.jobs.findJob(scheduler.lastRun.JobId)
+	if err != nil {
+		return false
+	}
+
+	return job.State == JobQueued || job.State == JobRunning
+}
+
+func (scheduler *SyncScheduler) save(run SyncRun) {
+	err := scheduler.history.SaveRun(run)
+	if err != nil {
+		fmt.Printf("Error while saving sync run '%s' | %v\n", run.Id, err)
+	}
+}
+
+/*
+	Returns the recorded runs, most recent first, each with the
+	current state of its job.
+*/
+func (scheduler *SyncScheduler) findRuns() ([]SyncRun, error) {
+	runs, err := scheduler.history.FindRuns()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range runs {
+		if runs[i].JobId == "" {
+			continue
+		}
+
+		job, err := scheduler.jobs.findJob(runs[i].JobId)
+		if errors.Is(err, errJobNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		runs[i].Job = &job
+	}
+
+	return runs, nil
+}
+
+/*
+	Returns the dates up to @until that are still to be loaded, at
+	most the last c.MaxRangeLoadDays of them. Those are the dates of
+	the sync history whose load failed or never finished, and the
+	ones after the latest date of the history. The dates of a load
+	count as loaded even when they have no transactions. Without
+	history the dates after the latest synchronized one are returned,
+	or only @until if no date has been synchronized yet. Dates whose
+	data was saved some other way, e.g. by a load requested through
+	the API, are left out.
+*/
+func (scheduler *SyncScheduler) findPendingDates(until string) ([]string, error) {
+	runs, err := scheduler.findRuns()
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching the sync history | %w", err)
+	}
+
+	candidates := map[string]bool{}
+	loaded := map[string]bool{}
+	latest := ""
+	for _, run := range runs {
+		for _, date := range run.Dates {
+			candidates[date] = true
+			if date > latest {
+				latest = date
+			}
+		}
+
+		if run.Job == nil {
+			continue
+		}
+		for _, summary := range run.Job.Dates {
+			if summary.Status != DateFailed {
+				loaded[summary.Date] = true
+			}
+		}
+	}
+
+	if latest == "" {
+		latest, err = scheduler.service.findLatestSynchronizedDate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	untilDate, err := time.Parse(c.DateLayout, until)
+	if err != nil {
+		return nil, err
+	}
+	oldest := untilDate.AddDate(0, 0, 1-c.MaxRangeLoadDays).Format(c.DateLayout)
+
+	if latest == "" {
+		candidates[until] = true
+	} else if latest < until {
+		latestDate, err := time.Parse(c.DateLayout, latest)
+		if err != nil {
+			return nil, err
+		}
+
+		from := latestDate.AddDate(0, 0, 1).Format(c.DateLayout)
+		if from < oldest {
+			from = oldest
+		}
+
+		newDates, err := getDateRange(from, until)
+		if err != nil {
+			return nil, err
+		}
+		for _, date := range newDates {
+			candidates[date] = true
+		}
+	}
+
+	pending := []string{}
+	for date := range candidates {
+		if loaded[date] || date < oldest || date > until {
+			continue
+		}
+
+		synchronized, err := scheduler.service.isDateSynchronized(date)
+		if err != nil {
+			return nil, err
+		}
+		if !synchronized {
+			pending = append(pending, date)
+		}
+	}
+
+	sort.Strings(pending)
+	return pending, nil
+}
+
+func (service *RestaurantService) findLatestSynchronizedDate() (string, error) {
+	txn, err := service.store.NewTxn()
+	if err != nil {
+		return "", fmt.Errorf("error while fetching the latest synchronized date | %w", err)
+	}
+	defer txn.Discard()
+
+	latest, err := service.store.Transactions().FindLatestSynchronizedDate(txn)
+	if err != nil {
+		return "", fmt.Errorf("error while fetching the latest synchronized date | %w", err)
+	}
+
+	return latest, nil
+}
+
+func (service *RestaurantService) isDateSynchronized(date string) (bool, error) {
+	txn, err := service.store.NewTxn()
+	if err != nil {
+		return false, fmt.Errorf("error while checking if '%s' is synchronized | %w", date, err)
+	}
+	defer txn.Discard()
+
+	synchronized, err := service.store.Transactions().IsDateSynchronized(txn, date)
+	if err != nil {
+		return false, fmt.Errorf("error while checking if '%s' is synchronized | %w", date, err)
+	}
+
+	return synchronized, nil
+}
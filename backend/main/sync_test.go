@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestSyncScheduler(t *testing.T, store Store) *SyncScheduler {
+	t.Helper()
+
+	service := &RestaurantService{store: store, source: newFakeDataSource()}
+
+	jobRepository, err := newFileJobRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := newJobManager(jobRepository, service)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := newFileSyncRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler, err := newSyncScheduler("", history, service, jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return scheduler
+}
+
+/*
+	Records a sync run of @dates whose job ended with @summaries.
+*/
+func saveTestRun(t *testing.T, scheduler *SyncScheduler, id string, dates []string, summaries []DateLoadSummary) {
+	t.Helper()
+
+	job := Job{Id: "job-" + id, State: JobSucceeded, Dates: summaries, CreatedAt: time.Now().UTC()}
+	err := scheduler.jobs.repository.SaveJob(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = scheduler.history.SaveRun(SyncRun{Id: id, State: SyncSubmitted, Dates: dates, JobId: job.Id, StartedAt: time.Now().UTC()})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindPendingDatesWithoutHistory(t *testing.T) {
+	store := newMemoryStore()
+	_, _, err := loadTestDate(store, newFakeDataSource())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler := newTestSyncScheduler(t, store)
+	pending, err := scheduler.findPendingDates("2020-08-19")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"2020-08-18", "2020-08-19"}
+	if !reflect.DeepEqual(pending, want) {
+		t.Errorf("got pending dates %v, want %v", pending, want)
+	}
+
+	scheduler = newTestSyncScheduler(t, newMemoryStore())
+	pending, err = scheduler.findPendingDates("2020-08-19")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pending, []string{"2020-08-19"}) {
+		t.Errorf("got pending dates %v with nothing synchronized, want only the last day", pending)
+	}
+}
+
+/*
+	A date that failed is retried even though later dates loaded, and
+	a date loaded without transactions isn't queued again.
+*/
+func TestFindPendingDatesFromHistory(t *testing.T) {
+	scheduler := newTestSyncScheduler(t, newMemoryStore())
+
+	saveTestRun(t, scheduler, "1", []string{"2020-08-18", "2020-08-19", "2020-08-20"}, []DateLoadSummary{
+		{Date: "2020-08-18", Status: DateLoaded, Counts: &LoadCounts{}},
+		{Date: "2020-08-19", Status: DateFailed, Error: "upstream unavailable"},
+		{Date: "2020-08-20", Status: DateLoaded, Counts: &LoadCounts{}},
+	})
+	// Job interrupted before loading its date
+	saveTestRun(t, scheduler, "2", []string{"2020-08-21"}, nil)
+
+	pending, err := scheduler.findPendingDates("2020-08-23")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"2020-08-19", "2020-08-21", "2020-08-22", "2020-08-23"}
+	if !reflect.DeepEqual(pending, want) {
+		t.Errorf("got pending dates %v, want %v", pending, want)
+	}
+}
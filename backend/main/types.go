@@ -8,6 +8,15 @@ type ProductHolder struct {
 	Products []Product
 }
 
+/*
+	Products of a request by ids. Missing holds the requested ids
+	that aren't saved.
+*/
+type ProductsById struct {
+	Products []Product `json:"products"`
+	Missing  []string  `json:"missing"`
+}
+
 type BuyersById struct {
 	Buyers []Buyer `json:"buyersById"`
 }
@@ -20,13 +29,15 @@ type BuyerIdEndpoint struct {
 }
 
 type BuyerCollection struct {
-	Buyers []Buyer
-	Count  int
+	Buyers     []Buyer
+	TotalCount int
+	PageInfo   PageInfo
 }
 
 type TransactionCollection struct {
 	Transactions []Transaction
-	Count        int
+	TotalCount   int
+	PageInfo     PageInfo
 }
 
 type CollectionCount struct {
@@ -38,10 +49,31 @@ type Count struct {
 }
 
 type BuyerRequestParams struct {
-	PageBParam     int
-	PageSizeBParam int
-	PageTParam     int
-	PageSizeTParam int
+	Transactions     PageRequest
+	BuyersWithSameIp PageRequest
 }
 
 type key string
+
+type PurgeResponse struct {
+	Date         string
+	Transactions int
+	Buyers       int
+	Products     int
+	Quarantined  int
+}
+
+type RangeLoadResponse struct {
+	Loaded  int
+	Skipped int
+	Failed  int
+	Dates   []DateLoadSummary
+}
+
+type DateLoadSummary struct {
+	Date       string
+	Status     string
+	Counts     *LoadCounts       `json:",omitempty"`
+	Error      string            `json:",omitempty"`
+	Validation *ValidationReport `json:",omitempty"`
+}
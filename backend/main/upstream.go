@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	c "module/constants"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   string = "closed"
+	CircuitOpen     string = "open"
+	CircuitHalfOpen string = "half-open"
+)
+
+var errCircuitOpen = errors.New("upstream circuit is open")
+
+/*
+	Error for an upstream response that isn't 2xx. Transient ones
+	(5xx and 429) are retried.
+*/
+type upstreamStatusError struct {
+	Url        string
+	StatusCode int
+	Body       string
+}
+
+/*
+	Counters of the requests made to the upstream, served as-is by
+	GET /upstream/metrics.
+*/
+type UpstreamMetrics struct {
+	Requests          int64
+	Attempts          int64
+	Retries           int64
+	Successes         int64
+	Failures          int64
+	Rejected          int64
+	CircuitOpenings   int64
+	CircuitState      string
+	AverageLatencyMs  int64
+	LastError         string     `json:",omitempty"`
+	LastErrorAt       *time.Time `json:",omitempty"`
+	CircuitOpenedAt   *time.Time `json:",omitempty"`
+	ConsecutiveErrors int
+}
+
+/*
+	HTTP client shared by every fetch to the upstream. Each attempt
+	has to get the response headers within the timeout, and then
+	every read of the body has to get data within it too, so that a
+	stalled upstream is given up on while a long feed that's read
+	slowly isn't cut short. Transient failures are retried with an
+	exponential backoff, and after c.UpstreamBreakerThreshold
+	consecutive failed requests the circuit opens, failing the
+	fetches right away for c.UpstreamBreakerCooldown. Once the cooldown
+	is over a single request is let through to probe the upstream.
+*/
+type upstreamClient struct {
+	client         *http.Client
+	timeout        time.Duration
+	maxRetries     int
+	retryBaseDelay time.Duration
+	threshold      int
+	cooldown       time.Duration
+
+	mutex        sync.Mutex
+	metrics      UpstreamMetrics
+	totalLatency time.Duration
+	probing      bool
+}
+
+/*
+	Body of a response, whose attempt is canceled when a read takes
+	longer than readTimeout. Time spent between reads doesn't count,
+	since the caller can hold the body open while it does other work.
+	Closing the body cancels the attempt as well.
+*/
+type upstreamBody struct {
+	io.ReadCloser
+	cancel      context.CancelFunc
+	readTimeout time.Duration
+}
+
+func (err *upstreamStatusError) Error() string {
+	return fmt.Sprintf("upstream responded %d to '%s': %s", err.StatusCode, err.Url, err.Body)
+}
+
+func (body *upstreamBody) Read(buffer []byte) (int, error) {
+	timer := time.AfterFunc(body.readTimeout, body.cancel)
+	n, err := body.ReadCloser.Read(buffer)
+	if !timer.Stop() && err != nil && err != io.EOF {
+		err = fmt.Errorf("no data received from the upstream for %v | %w", body.readTimeout, err)
+	}
+
+	return n, err
+}
+
+func (body *upstreamBody) Close() error {
+	defer body.cancel()
+	return body.ReadCloser.Close()
+}
+
+func newUpstreamClient() *upstreamClient {
+	return &upstreamClient{
+		client:         newUpstreamHttpClient(c.UpstreamTimeout),
+		timeout:        c.UpstreamTimeout,
+		maxRetries:     c.UpstreamMaxRetries,
+		retryBaseDelay: c.UpstreamRetryBaseDelay,
+		threshold:      c.UpstreamBreakerThreshold,
+		cooldown:       c.UpstreamBreakerCooldown,
+		metrics:        UpstreamMetrics{CircuitState: CircuitClosed},
+	}
+}
+
+/*
+	Returns a client that gives up on a request when its response
+	headers take longer than @timeout to arrive. The body isn't
+	bounded by it, as it's read at the pace of the caller.
+*/
+func newUpstreamHttpClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
+
+	return &http.Client{Transport: transport}
+}
+
+/*
+	GETs @url, retrying the transient failures. Returns the body of
+	the first 2xx response, which must be closed by the caller, or
+	errCircuitOpen without contacting the upstream while the circuit
+	is open.
+*/
+func (upstream *upstreamClient) get(url string) (io.ReadCloser, error) {
+	err := upstream.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.ReadCloser
+	var transient bool
+	for attempt := 0; ; attempt++ {
+		body, transient, err = upstream.attempt(url)
+		if err == nil || !transient || attempt == upstream.maxRetries {
+			break
+		}
+
+		delay := upstream.retryBaseDelay << attempt
+		fmt.Printf("Retrying GET request '%s' in %v | %v\n", url, delay, err)
+		upstream.countRetry()
+		time.Sleep(delay)
+	}
+
+	upstream.release(err, transient)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching '%s' | %w", url, err)
+	}
+
+	return body, nil
+}
+
+/*
+	Makes a single request to @url. Reports whether a failure is
+	worth retrying.
+*/
+func (upstream *upstreamClient) attempt(url string) (io.ReadCloser, bool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		return nil, false, err
+	}
+
+	start := time.Now()
+	response, err := upstream.client.Do(request)
+	upstream.countAttempt(time.Since(start))
+	if err != nil {
+		cancel()
+		return nil, isTransientError(err), err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		content, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+		response.Body.Close()
+		cancel()
+
+		statusErr := &upstreamStatusError{Url: url, StatusCode: response.StatusCode, Body: string(content)}
+		transient := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+		return nil, transient, statusErr
+	}
+
+	return &upstreamBody{ReadCloser: response.Body, cancel: cancel, readTimeout: upstream.timeout}, false, nil
+}
+
+func isTransientError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return false
+}
+
+/*
+	Lets a request through unless the circuit is open. Moves the
+	circuit to half-open once the cooldown is over, letting only the
+	probing request through until it finishes.
+*/
+func (upstream *upstreamClient) acquire() error {
+	upstream.mutex.Lock()
+	defer upstream.mutex.Unlock()
+
+	metrics := &upstream.metrics
+	if metrics.CircuitState == CircuitOpen && time.Since(*metrics.CircuitOpenedAt) >= upstream.cooldown {
+		metrics.CircuitState = CircuitHalfOpen
+	}
+
+	if metrics.CircuitState == CircuitOpen || (metrics.CircuitState == CircuitHalfOpen && upstream.probing) {
+		metrics.Rejected++
+		return errCircuitOpen
+	}
+
+	if metrics.CircuitState == CircuitHalfOpen {
+		upstream.probing = true
+	}
+
+	metrics.Requests++
+	return nil
+}
+
+/*
+	Records the outcome of a request let through by acquire, opening
+	the circuit when the probe or too many consecutive requests fail.
+	Only @transient failures count, as the others are answers of a
+	healthy upstream.
+*/
+func (upstream *upstreamClient) release(err error, transient bool) {
+	upstream.mutex.Lock()
+	defer upstream.mutex.Unlock()
+
+	metrics := &upstream.metrics
+	upstream.probing = false
+
+	if err == nil || !transient {
+		metrics.ConsecutiveErrors = 0
+		metrics.CircuitState = CircuitClosed
+		metrics.CircuitOpenedAt = nil
+	}
+
+	if err == nil {
+		metrics.Successes++
+		return
+	}
+
+	now := time.Now()
+	metrics.Failures++
+	metrics.LastError = err.Error()
+	metrics.LastErrorAt = &now
+	if !transient {
+		return
+	}
+
+	metrics.ConsecutiveErrors++
+	if metrics.CircuitState == CircuitHalfOpen || metrics.ConsecutiveErrors >= upstream.threshold {
+		if metrics.CircuitState != CircuitOpen {
+			metrics.CircuitOpenings++
+		}
+		metrics.CircuitState = CircuitOpen
+		metrics.CircuitOpenedAt = &now
+	}
+}
+
+func (upstream *upstreamClient) countAttempt(latency time.Duration) {
+	upstream.mutex.Lock()
+	defer upstream.mutex.Unlock()
+
+	upstream.metrics.Attempts++
+	upstream.totalLatency += latency
+	upstream.metrics.AverageLatencyMs = upstream.totalLatency.Milliseconds() / upstream.metrics.Attempts
+}
+
+func (upstream *upstreamClient) countRetry() {
+	upstream.mutex.Lock()
+	defer upstream.mutex.Unlock()
+
+	upstream.metrics.Retries++
+}
+
+/*
+	Returns a snapshot of the metrics.
+*/
+func (upstream *upstreamClient) findMetrics() UpstreamMetrics {
+	upstream.mutex.Lock()
+	defer upstream.mutex.Unlock()
+
+	metrics := upstream.metrics
+	if metrics.CircuitState == CircuitOpen && time.Since(*metrics.CircuitOpenedAt) >= upstream.cooldown {
+		metrics.CircuitState = CircuitHalfOpen
+	}
+
+	return metrics
+}
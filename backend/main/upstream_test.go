@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testUpstreamTimeout    time.Duration = 100 * time.Millisecond
+	testRetryBaseDelay     time.Duration = 20 * time.Millisecond
+	testBreakerCooldown    time.Duration = 100 * time.Millisecond
+	testBreakerThreshold   int           = 2
+	testUpstreamMaxRetries int           = 2
+)
+
+/*
+	Fake upstream answering each request with the handler at the
+	position of the request, or with the last one once they run out.
+	Records when each request arrived.
+*/
+type fakeUpstream struct {
+	mutex    sync.Mutex
+	handlers []http.HandlerFunc
+	arrivals []time.Time
+	server   *httptest.Server
+}
+
+func newFakeUpstream(t *testing.T, handlers ...http.HandlerFunc) *fakeUpstream {
+	upstream := &fakeUpstream{handlers: handlers}
+	upstream.server = httptest.NewServer(http.HandlerFunc(upstream.serve))
+	t.Cleanup(upstream.server.Close)
+
+	return upstream
+}
+
+func (upstream *fakeUpstream) serve(writter http.ResponseWriter, request *http.Request) {
+	upstream.mutex.Lock()
+	upstream.arrivals = append(upstream.arrivals, time.Now())
+	handler := upstream.handlers[len(upstream.handlers)-1]
+	if len(upstream.arrivals) <= len(upstream.handlers) {
+		handler = upstream.handlers[len(upstream.arrivals)-1]
+	}
+	upstream.mutex.Unlock()
+
+	handler(writter, request)
+}
+
+func (upstream *fakeUpstream) requests() int {
+	upstream.mutex.Lock()
+	defer upstream.mutex.Unlock()
+
+	return len(upstream.arrivals)
+}
+
+func respond(status int, body string) http.HandlerFunc {
+	return func(writter http.ResponseWriter, request *http.Request) {
+		writter.WriteHeader(status)
+		writter.Write([]byte(body))
+	}
+}
+
+func newTestUpstreamClient() *upstreamClient {
+	upstream := newUpstreamClient()
+	upstream.client = newUpstreamHttpClient(testUpstreamTimeout)
+	upstream.timeout = testUpstreamTimeout
+	upstream.maxRetries = testUpstreamMaxRetries
+	upstream.retryBaseDelay = testRetryBaseDelay
+	upstream.threshold = testBreakerThreshold
+	upstream.cooldown = testBreakerCooldown
+
+	return upstream
+}
+
+func readBody(t *testing.T, body io.ReadCloser) string {
+	t.Helper()
+	defer body.Close()
+
+	content, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("error while reading the body: %v", err)
+	}
+
+	return string(content)
+}
+
+func TestUpstreamRetriesTransientFailures(t *testing.T) {
+	server := newFakeUpstream(t,
+		respond(http.StatusInternalServerError, "down"),
+		respond(http.StatusTooManyRequests, "slow down"),
+		respond(http.StatusOK, "feed"))
+	upstream := newTestUpstreamClient()
+
+	body, err := upstream.get(server.server.URL)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if content := readBody(t, body); content != "feed" {
+		t.Errorf("got body %q, want %q", content, "feed")
+	}
+
+	metrics := upstream.findMetrics()
+	if metrics.Attempts != 3 || metrics.Retries != 2 || metrics.Successes != 1 || metrics.Failures != 0 {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+}
+
+func TestUpstreamBacksOffExponentially(t *testing.T) {
+	server := newFakeUpstream(t, respond(http.StatusServiceUnavailable, "down"))
+	upstream := newTestUpstreamClient()
+
+	_, err := upstream.get(server.server.URL)
+	var statusErr *upstreamStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got error %v, want the 503 of the upstream", err)
+	}
+
+	arrivals := server.arrivals
+	if len(arrivals) != testUpstreamMaxRetries+1 {
+		t.Fatalf("got %d attempts, want %d", len(arrivals), testUpstreamMaxRetries+1)
+	}
+
+	for i := 1; i < len(arrivals); i++ {
+		want := testRetryBaseDelay << (i - 1)
+		if gap := arrivals[i].Sub(arrivals[i-1]); gap < want {
+			t.Errorf("retry %d came after %v, want at least %v", i, gap, want)
+		}
+	}
+}
+
+func TestUpstreamDoesNotRetryClientErrors(t *testing.T) {
+	server := newFakeUpstream(t, respond(http.StatusNotFound, "no such feed"))
+	upstream := newTestUpstreamClient()
+
+	_, err := upstream.get(server.server.URL)
+	if err == nil {
+		t.Fatal("get succeeded on a 404")
+	}
+	if server.requests() != 1 {
+		t.Errorf("got %d attempts, want 1", server.requests())
+	}
+
+	metrics := upstream.findMetrics()
+	if metrics.ConsecutiveErrors != 0 || metrics.CircuitState != CircuitClosed {
+		t.Errorf("a 404 counted towards the circuit breaker: %+v", metrics)
+	}
+}
+
+func TestUpstreamCircuitBreaker(t *testing.T) {
+	failing := true
+	var mutex sync.Mutex
+	server := newFakeUpstream(t, func(writter http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if failing {
+			respond(http.StatusBadGateway, "down")(writter, request)
+			return
+		}
+		respond(http.StatusOK, "feed")(writter, request)
+	})
+	upstream := newTestUpstreamClient()
+	upstream.maxRetries = 0
+
+	for i := 0; i < testBreakerThreshold; i++ {
+		_, err := upstream.get(server.server.URL)
+		if err == nil || errors.Is(err, errCircuitOpen) {
+			t.Fatalf("request %d: got error %v, want the 502 of the upstream", i, err)
+		}
+	}
+
+	// Open: fails right away, without contacting the upstream
+	requests := server.requests()
+	_, err := upstream.get(server.server.URL)
+	if !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("got error %v, want errCircuitOpen", err)
+	}
+	if server.requests() != requests {
+		t.Error("the upstream was contacted while the circuit was open")
+	}
+	if state := upstream.findMetrics().CircuitState; state != CircuitOpen {
+		t.Errorf("circuit %s, want %s", state, CircuitOpen)
+	}
+
+	// Half-open: a failed probe opens it again
+	time.Sleep(testBreakerCooldown)
+	if state := upstream.findMetrics().CircuitState; state != CircuitHalfOpen {
+		t.Errorf("circuit %s after the cooldown, want %s", state, CircuitHalfOpen)
+	}
+	_, err = upstream.get(server.server.URL)
+	if err == nil || errors.Is(err, errCircuitOpen) {
+		t.Fatalf("got error %v from the probe, want the 502 of the upstream", err)
+	}
+	if state := upstream.findMetrics().CircuitState; state != CircuitOpen {
+		t.Errorf("circuit %s after a failed probe, want %s", state, CircuitOpen)
+	}
+
+	// Half-open: a successful probe closes it
+	mutex.Lock()
+	failing = false
+	mutex.Unlock()
+	time.Sleep(testBreakerCooldown)
+
+	body, err := upstream.get(server.server.URL)
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	readBody(t, body)
+
+	metrics := upstream.findMetrics()
+	if metrics.CircuitState != CircuitClosed || metrics.CircuitOpenings != 2 || metrics.Rejected != 1 {
+		t.Errorf("unexpected metrics after a successful probe %+v", metrics)
+	}
+}
+
+/*
+	While the probe of a half-open circuit is in flight, the other
+	requests are rejected.
+*/
+func TestUpstreamHalfOpenLetsOneProbeThrough(t *testing.T) {
+	release := make(chan bool)
+	server := newFakeUpstream(t,
+		respond(http.StatusInternalServerError, "down"),
+		respond(http.StatusInternalServerError, "down"),
+		func(writter http.ResponseWriter, request *http.Request) {
+			<-release
+			respond(http.StatusOK, "feed")(writter, request)
+		})
+	upstream := newTestUpstreamClient()
+	upstream.maxRetries = 0
+
+	for i := 0; i < testBreakerThreshold; i++ {
+		upstream.get(server.server.URL)
+	}
+	time.Sleep(testBreakerCooldown)
+
+	probeDone := make(chan error)
+	go func() {
+		body, err := upstream.get(server.server.URL)
+		if err == nil {
+			body.Close()
+		}
+		probeDone <- err
+	}()
+
+	for server.requests() < testBreakerThreshold+1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err := upstream.get(server.server.URL)
+	if !errors.Is(err, errCircuitOpen) {
+		t.Errorf("got error %v while probing, want errCircuitOpen", err)
+	}
+
+	close(release)
+	err = <-probeDone
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if state := upstream.findMetrics().CircuitState; state != CircuitClosed {
+		t.Errorf("circuit %s after a successful probe, want %s", state, CircuitClosed)
+	}
+}
+
+func TestUpstreamTimesOutWaitingForHeaders(t *testing.T) {
+	server := newFakeUpstream(t, func(writter http.ResponseWriter, request *http.Request) {
+		time.Sleep(2 * testUpstreamTimeout)
+		respond(http.StatusOK, "late")(writter, request)
+	})
+	upstream := newTestUpstreamClient()
+	upstream.maxRetries = 0
+
+	_, err := upstream.get(server.server.URL)
+	if err == nil {
+		t.Fatal("get succeeded though the headers came after the timeout")
+	}
+	if upstream.findMetrics().ConsecutiveErrors != 1 {
+		t.Error("the timeout wasn't counted as a transient failure")
+	}
+}
+
+/*
+	A body that keeps sending data, or that the caller leaves unread
+	for a while, outlives the timeout. One that stalls doesn't.
+*/
+func TestUpstreamBodyReadTimeout(t *testing.T) {
+	chunks := func(pause time.Duration, count int) http.HandlerFunc {
+		return func(writter http.ResponseWriter, request *http.Request) {
+			writter.WriteHeader(http.StatusOK)
+			for i := 0; i < count; i++ {
+				writter.Write([]byte("x"))
+				writter.(http.Flusher).Flush()
+				time.Sleep(pause)
+			}
+		}
+	}
+
+	t.Run("slow but steady", func(t *testing.T) {
+		server := newFakeUpstream(t, chunks(testUpstreamTimeout/4, 8))
+		body, err := newTestUpstreamClient().get(server.server.URL)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		if content := readBody(t, body); content != "xxxxxxxx" {
+			t.Errorf("got body %q", content)
+		}
+	})
+
+	t.Run("held open by the caller", func(t *testing.T) {
+		server := newFakeUpstream(t, respond(http.StatusOK, "feed"))
+		body, err := newTestUpstreamClient().get(server.server.URL)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+
+		time.Sleep(2 * testUpstreamTimeout)
+		if content := readBody(t, body); content != "feed" {
+			t.Errorf("got body %q", content)
+		}
+	})
+
+	t.Run("stalled", func(t *testing.T) {
+		server := newFakeUpstream(t, chunks(4*testUpstreamTimeout, 2))
+		body, err := newTestUpstreamClient().get(server.server.URL)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		defer body.Close()
+
+		_, err = io.ReadAll(body)
+		if err == nil {
+			t.Error("reading a stalled body succeeded")
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	c "module/constants"
+	f "module/utils"
+	"net"
+	"sync"
+)
+
+const (
+	ProductsFeed     string = "products"
+	TransactionsFeed string = "transactions"
+)
+
+const (
+	MalformedLineIssue        string = "malformed_line"
+	MalformedRecordIssue      string = "malformed_record"
+	UnknownBuyerIssue         string = "unknown_buyer"
+	UnknownProductIssue       string = "unknown_product"
+	DuplicateTransactionIssue string = "duplicate_transaction"
+	MalformedIpIssue          string = "malformed_ip"
+	UnknownDeviceIssue        string = "unknown_device"
+)
+
+/*
+	Problem found in a record of a feed. Position is the line of the
+	record in the products feed, or its number in the transactions
+	feed.
+*/
+type ValidationIssue struct {
+	Feed         string
+	Code         string
+	Reason       string
+	Position     int    `json:",omitempty"`
+	QuarantineId string `json:",omitempty"`
+}
+
+/*
+	Issues found while loading a date. Only the first
+	c.MaxRejectedRecords issues are listed, but all of them are
+	counted in IssueCounts.
+*/
+type ValidationReport struct {
+	IssueCounts map[string]int    `json:",omitempty"`
+	Issues      []ValidationIssue `json:",omitempty"`
+	Quarantined int
+}
+
+/*
+	Collects the issues and the quarantined records of a load. The
+	load goroutines share it, so it's safe for concurrent use.
+*/
+type loadValidation struct {
+	mutex       sync.Mutex
+	date        string
+	report      ValidationReport
+	quarantined []QuarantinedRecord
+}
+
+/*
+	Checks the transactions of a feed. @isKnownBuyer and
+	@isKnownProduct tell whether an id is in the database or in the
+	feeds being loaded.
+*/
+type transactionValidator struct {
+	isKnownBuyer   func(buyerId string) (bool, error)
+	isKnownProduct func(productId string) (bool, error)
+	seenIds        map[string]bool
+}
+
+func newLoadValidation(date string) *loadValidation {
+	return &loadValidation{date: date}
+}
+
+/*
+	Records @issues for @record of @feed, and quarantines @record so
+	that it can be fixed and imported later.
+*/
+func (validation *loadValidation) reject(feed string, record string, position int, issues []ValidationIssue) error {
+	id, err := newId()
+	if err != nil {
+		return err
+	}
+
+	validation.mutex.Lock()
+	defer validation.mutex.Unlock()
+
+	recordIssues := make([]ValidationIssue, len(issues))
+	for i, issue := range issues {
+		issue.Feed = feed
+		issue.Position = position
+		issue.QuarantineId = id
+		recordIssues[i] = issue
+
+		if validation.report.IssueCounts == nil {
+			validation.report.IssueCounts = map[string]int{}
+		}
+		validation.report.IssueCounts[issue.Code]++
+
+		if len(validation.report.Issues) < c.MaxRejectedRecords {
+			validation.report.Issues = append(validation.report.Issues, issue)
+		}
+	}
+
+	validation.report.Quarantined++
+	validation.quarantined = append(validation.quarantined, QuarantinedRecord{
+		Id:     id,
+		Date:   validation.date,
+		Feed:   feed,
+		Record: record,
+		Issues: recordIssues,
+	})
+
+	return nil
+}
+
+/*
+	Returns the number of records of @feed that were rejected.
+*/
+func (validation *loadValidation) rejected(feed string) int {
+	validation.mutex.Lock()
+	defer validation.mutex.Unlock()
+
+	rejected := 0
+	for _, record := range validation.quarantined {
+		if record.Feed == feed {
+			rejected++
+		}
+	}
+
+	return rejected
+}
+
+func newTransactionValidator(isKnownBuyer func(string) (bool, error), isKnownProduct func(string) (bool, error)) *transactionValidator {
+	return &transactionValidator{
+		isKnownBuyer:   isKnownBuyer,
+		isKnownProduct: isKnownProduct,
+		seenIds:        map[string]bool{},
+	}
+}
+
+/*
+	Returns the issues of @transaction, if any. Ids are remembered
+	across calls to spot duplicated transactions.
+*/
+func (validator *transactionValidator) validate(transaction Transaction) ([]ValidationIssue, error) {
+	var issues []ValidationIssue
+
+	if validator.seenIds[transaction.TransactionId] {
+		issues = append(issues, ValidationIssue{
+			Code:   DuplicateTransactionIssue,
+			Reason: fmt.Sprintf("transaction '%s' appears more than once", transaction.TransactionId),
+		})
+	}
+	validator.seenIds[transaction.TransactionId] = true
+
+	known, err := validator.isKnownBuyer(transaction.BuyerId)
+	if err != nil {
+		return nil, err
+	}
+	if !known {
+		issues = append(issues, ValidationIssue{
+			Code:   UnknownBuyerIssue,
+			Reason: fmt.Sprintf("unknown buyer '%s'", transaction.BuyerId),
+		})
+	}
+
+	for _, productId := range transaction.Products {
+		known, err = validator.isKnownProduct(productId)
+		if err != nil {
+			return nil, err
+		}
+		if !known {
+			issues = append(issues, ValidationIssue{
+				Code:   UnknownProductIssue,
+				Reason: fmt.Sprintf("unknown product '%s'", productId),
+			})
+		}
+	}
+
+	if net.ParseIP(transaction.Ip) == nil {
+		issues = append(issues, ValidationIssue{
+			Code:   MalformedIpIssue,
+			Reason: fmt.Sprintf("malformed ip '%s'", transaction.Ip),
+		})
+	}
+
+	if !f.ArrayContains(c.KnownDevices, transaction.Device) {
+		issues = append(issues, ValidationIssue{
+			Code:   UnknownDeviceIssue,
+			Reason: fmt.Sprintf("unknown device '%s'", transaction.Device),
+		})
+	}
+
+	return issues, nil
+}
@@ -0,0 +1,240 @@
+/*
+	Parser for the products feed, which has one product per line in
+	the format id'name'price. Names holding an apostrophe are supposed
+	to be wrapped in double quotes, although the upstream doesn't
+	always do it, and names can hold HTML entities. The upstream
+	encodes apostrophes as &quot;, so that entity is read as one.
+*/
+package productfeed
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	d "github.com/shopspring/decimal"
+)
+
+type Mode string
+
+const (
+	// Stops at the first line that isn't a valid product.
+	Strict Mode = "strict"
+	// Recovers what it can from malformed lines and rejects the rest.
+	Lenient Mode = "lenient"
+)
+
+const (
+	separator byte = '\''
+	quote     byte = '"'
+
+	maxLineLength int = 1024 * 1024
+)
+
+type Product struct {
+	Id    string
+	Name  string
+	Price d.Decimal
+	Line  int
+}
+
+/*
+	Line of the feed that couldn't be parsed into a product.
+*/
+type ParseError struct {
+	Line   int
+	Text   string
+	Reason string
+}
+
+type Result struct {
+	Products []Product
+	Rejected []ParseError
+	// Number of non empty lines read
+	Lines int
+}
+
+func (err ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", err.Line, err.Reason)
+}
+
+/*
+	Returns the Mode named @name, defaulting to Lenient when @name
+	is empty.
+*/
+func ParseMode(name string) (Mode, error) {
+	switch Mode(name) {
+	case "", Lenient:
+		return Lenient, nil
+	case Strict:
+		return Strict, nil
+	}
+
+	return "", fmt.Errorf("unknown product feed mode '%s'", name)
+}
+
+/*
+	Parses every line of @feed. Blank lines are ignored. In Strict
+	mode the first invalid line is returned as a ParseError; in
+	Lenient mode invalid lines are collected in Result.Rejected.
+*/
+func Parse(feed io.Reader, mode Mode) (Result, error) {
+	var result Result
+
+	scanner := bufio.NewScanner(feed)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		result.Lines++
+
+		product, err := ParseLine(text, mode)
+		if err != nil {
+			parseErr := ParseError{Line: lineNumber, Text: text, Reason: err.Error()}
+			if mode == Strict {
+				return result, parseErr
+			}
+
+			result.Rejected = append(result.Rejected, parseErr)
+			continue
+		}
+
+		product.Line = lineNumber
+		result.Products = append(result.Products, product)
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return result, fmt.Errorf("error while reading products feed after line %d | %w", lineNumber, err)
+	}
+
+	return result, nil
+}
+
+/*
+	Parses a single line of the feed. The returned product has no
+	Line set.
+*/
+func ParseLine(line string, mode Mode) (Product, error) {
+	fields, err := tokenize(line)
+
+	if (err != nil || len(fields) != 3) && mode == Lenient {
+		fields, err = recoverFields(line)
+	}
+	if err != nil {
+		return Product{}, err
+	}
+	if len(fields) != 3 {
+		return Product{}, fmt.Errorf("expected 3 fields, found %d", len(fields))
+	}
+
+	id := strings.TrimSpace(fields[0])
+	if id == "" {
+		return Product{}, fmt.Errorf("missing product id")
+	}
+
+	name := unescapeName(fields[1])
+	if strings.TrimSpace(name) == "" || name == "null" {
+		return Product{}, fmt.Errorf("missing product name")
+	}
+
+	price, err := d.NewFromString(strings.TrimSpace(fields[2]))
+	if err != nil {
+		return Product{}, fmt.Errorf("invalid price '%s'", fields[2])
+	}
+	if price.IsNegative() {
+		return Product{}, fmt.Errorf("negative price '%s'", fields[2])
+	}
+
+	return Product{Id: id, Name: name, Price: price}, nil
+}
+
+/*
+	Decodes the HTML entities of @name. The upstream writes
+	apostrophes as &quot;, so those are read as apostrophes rather
+	than double quotes.
+*/
+func unescapeName(name string) string {
+	return html.UnescapeString(strings.ReplaceAll(name, "&quot;", "'"))
+}
+
+/*
+	Splits @line in its fields. Fields are separated by apostrophes,
+	and a field starting with a double quote runs until the double
+	quote closing it, so it can hold apostrophes.
+*/
+func tokenize(line string) ([]string, error) {
+	var fields []string
+	pos := 0
+
+	for {
+		if pos < len(line) && line[pos] == quote {
+			end := closingQuote(line, pos+1)
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated quoted field at column %d", pos+1)
+			}
+
+			fields = append(fields, line[pos+1:end])
+			pos = end + 1
+
+			if pos == len(line) {
+				return fields, nil
+			}
+			if line[pos] != separator {
+				return nil, fmt.Errorf("unexpected character after quoted field at column %d", pos+1)
+			}
+			pos++
+			continue
+		}
+
+		end := strings.IndexByte(line[pos:], separator)
+		if end == -1 {
+			return append(fields, line[pos:]), nil
+		}
+
+		fields = append(fields, line[pos:pos+end])
+		pos += end + 1
+	}
+}
+
+/*
+	Returns the position of the double quote closing a quoted field
+	that starts at @start, which is the one followed by a separator
+	or by the end of @line.
+*/
+func closingQuote(line string, start int) int {
+	for i := start; i < len(line); i++ {
+		if line[i] == quote && (i+1 == len(line) || line[i+1] == separator) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+/*
+	Takes the id up to the first apostrophe and the price after the
+	last one, leaving everything in between as the name, so that
+	unquoted names with apostrophes are still read right.
+*/
+func recoverFields(line string) ([]string, error) {
+	first := strings.IndexByte(line, separator)
+	last := strings.LastIndexByte(line, separator)
+	if first == -1 || first == last {
+		return nil, fmt.Errorf("expected 3 fields separated by apostrophes")
+	}
+
+	name := line[first+1 : last]
+	if len(name) >= 2 && name[0] == quote && name[len(name)-1] == quote {
+		name = name[1 : len(name)-1]
+	}
+
+	return []string{line[:first], name, line[last+1:]}, nil
+}
@@ -0,0 +1,156 @@
+package productfeed
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current output")
+
+/*
+	Output of parsing a feed, as kept in the golden files.
+*/
+type goldenResult struct {
+	Result
+	Error string `json:",omitempty"`
+}
+
+/*
+	Parses every testdata/*.feed in both modes and compares the
+	outcome with testdata/<feed>.<mode>.golden. Run with -update to
+	rewrite them.
+*/
+func TestParseGolden(t *testing.T) {
+	feeds, err := filepath.Glob(filepath.Join("testdata", "*.feed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feeds) == 0 {
+		t.Fatal("no feeds found in testdata")
+	}
+
+	for _, feedPath := range feeds {
+		for _, mode := range []Mode{Strict, Lenient} {
+			name := strings.TrimSuffix(filepath.Base(feedPath), ".feed")
+
+			t.Run(name+"/"+string(mode), func(t *testing.T) {
+				feed, err := os.Open(feedPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer feed.Close()
+
+				result, err := Parse(feed, mode)
+				golden := goldenResult{Result: result}
+				if err != nil {
+					golden.Error = err.Error()
+				}
+
+				var output bytes.Buffer
+				encoder := json.NewEncoder(&output)
+				encoder.SetEscapeHTML(false)
+				encoder.SetIndent("", "\t")
+				err = encoder.Encode(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := output.Bytes()
+
+				goldenPath := strings.TrimSuffix(feedPath, ".feed") + "." + string(mode) + ".golden"
+				if *update {
+					err = os.WriteFile(goldenPath, got, 0644)
+					if err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				want, err := os.ReadFile(goldenPath)
+				if err != nil {
+					t.Fatalf("%v, run the tests with -update to create it", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("output differs from %s\ngot:\n%s\nwant:\n%s", goldenPath, got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestParseLineEntities(t *testing.T) {
+	cases := map[string]string{
+		"1'Tom&quot;s Tacos'5":           "Tom's Tacos",
+		"2'\"Tom&quot;s Tacos\"'5":       "Tom's Tacos",
+		"3'Fish &amp; chips'5":           "Fish & chips",
+		"4'&amp;quot;'5":                 "&quot;",
+		"5'Caf&eacute; &#34;Luna&#34;'5": "Café \"Luna\"",
+	}
+
+	for line, want := range cases {
+		for _, mode := range []Mode{Strict, Lenient} {
+			product, err := ParseLine(line, mode)
+			if err != nil {
+				t.Errorf("%s mode, %q: %v", mode, line, err)
+				continue
+			}
+			if product.Name != want {
+				t.Errorf("%s mode, %q: got name %q, want %q", mode, line, product.Name, want)
+			}
+		}
+	}
+}
+
+/*
+	ParseLine must never panic, and the products it returns must be
+	valid. Whatever Strict mode accepts, Lenient mode reads the same
+	way.
+*/
+func FuzzParseLine(f *testing.F) {
+	seeds := []string{
+		"1'Rice'10",
+		"2'\"Baker's bread\"'3.50",
+		"3'Baker's dozen'12",
+		"4'Tom&quot;s Tacos'5",
+		"5'\"unterminated'1",
+		"6'Juice'abc",
+		"7'Soda'-2",
+		"8''1",
+		"'''",
+		"\"",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		strict, strictErr := ParseLine(line, Strict)
+		lenient, lenientErr := ParseLine(line, Lenient)
+
+		for _, product := range []Product{strict, lenient} {
+			if product.Id != strings.TrimSpace(product.Id) {
+				t.Errorf("%q: untrimmed id %q", line, product.Id)
+			}
+			if product.Price.IsNegative() {
+				t.Errorf("%q: negative price %s", line, product.Price)
+			}
+		}
+
+		if lenientErr == nil && (lenient.Id == "" || strings.TrimSpace(lenient.Name) == "") {
+			t.Errorf("%q: accepted product without id or name: %+v", line, lenient)
+		}
+
+		if strictErr == nil {
+			if lenientErr != nil {
+				t.Fatalf("%q: accepted in strict mode but rejected in lenient mode | %v", line, lenientErr)
+			}
+			if strict.Id != lenient.Id || strict.Name != lenient.Name || !strict.Price.Equal(lenient.Price) {
+				t.Errorf("%q: strict mode read %+v, lenient mode %+v", line, strict, lenient)
+			}
+		}
+	})
+}
@@ -0,0 +1,230 @@
+/*
+	Streaming decoder for the transactions feed. Each record holds
+	the fields id, buyer id, ip, device and product list, every one
+	of them followed by a NUL character, and an extra NUL closes the
+	record. Fields other than the last one may be empty. The id starts
+	with '#' and the product list has the form (a,b,c). The feed is
+	read a field at a time, so the memory used doesn't depend on its
+	size.
+*/
+package transactionfeed
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	separator byte = 0
+	fieldsQty int  = 5
+
+	// Longest field accepted, which bounds the memory used per record
+	maxFieldLength int = 1024 * 1024
+)
+
+type Record struct {
+	Id       string
+	BuyerId  string
+	Ip       string
+	Device   string
+	Products []string
+	// Position of the record in the feed, starting at 1
+	Number int
+}
+
+/*
+	Record of the feed that couldn't be decoded. The decoder can
+	keep going after it.
+*/
+type RecordError struct {
+	Number int
+	// Byte offset of the start of the record in the feed
+	Offset int64
+	Text   string
+	Reason string
+}
+
+/*
+	Reads the records of a feed one at a time.
+*/
+type Decoder struct {
+	reader *bufio.Reader
+	offset int64
+	number int
+}
+
+/*
+	Returns @record in the format of the feed, without the NUL
+	closing it.
+*/
+func (record Record) String() string {
+	return strings.Join([]string{
+		"#" + record.Id,
+		record.BuyerId,
+		record.Ip,
+		record.Device,
+		"(" + strings.Join(record.Products, ",") + ")",
+	}, "\x00")
+}
+
+func (err *RecordError) Error() string {
+	return fmt.Sprintf("record %d at offset %d: %s", err.Number, err.Offset, err.Reason)
+}
+
+func NewDecoder(feed io.Reader) *Decoder {
+	return &Decoder{reader: bufio.NewReaderSize(feed, 64*1024)}
+}
+
+/*
+	Returns the next record of the feed, or io.EOF when there are no
+	more. A malformed record is returned as a *RecordError, after
+	which Next can be called again to read the following record. Any
+	other error comes from the underlying reader.
+*/
+func (decoder *Decoder) Next() (Record, error) {
+	for {
+		start := decoder.offset
+		fields, tooLong, err := decoder.readRecord()
+		if err != nil && err != io.EOF {
+			return Record{}, fmt.Errorf("error while reading transactions feed at offset %d | %w", decoder.offset, err)
+		}
+
+		if len(fields) == 0 {
+			return Record{}, io.EOF
+		}
+
+		decoder.number++
+		if tooLong {
+			return Record{}, decoder.recordError(start, fields,
+				fmt.Sprintf("field longer than %d bytes", maxFieldLength))
+		}
+
+		record, reason := parseRecord(fields)
+		if reason != "" {
+			return Record{}, decoder.recordError(start, fields, reason)
+		}
+
+		record.Number = decoder.number
+		return record, nil
+	}
+}
+
+func (decoder *Decoder) recordError(start int64, fields []string, reason string) *RecordError {
+	return &RecordError{
+		Number: decoder.number,
+		Offset: start,
+		Text:   strings.Join(fields, "\x00"),
+		Reason: reason,
+	}
+}
+
+/*
+	Reads fields until the empty one closing the record, or until the
+	end of the feed. Empty fields are part of the record until it has
+	fieldsQty fields, and the ones before its first field are stray
+	NULs between records, which are skipped. Fields over
+	maxFieldLength are read as empty, and reported through @tooLong.
+*/
+func (decoder *Decoder) readRecord() (fields []string, tooLong bool, err error) {
+	for {
+		field, fieldTooLong, err := decoder.readField()
+		if err != nil && field == "" && !fieldTooLong {
+			return fields, tooLong, err
+		}
+
+		switch {
+		case fieldTooLong:
+			tooLong = true
+			fields = append(fields, "")
+		case field != "" || len(fields) > 0 && len(fields) < fieldsQty:
+			fields = append(fields, field)
+		case len(fields) >= fieldsQty:
+			// Two NULs in a row after the last field close the record
+			return fields, tooLong, nil
+		}
+
+		if err != nil {
+			return fields, tooLong, err
+		}
+	}
+}
+
+/*
+	Reads up to the next NUL, which is consumed but not returned.
+*/
+func (decoder *Decoder) readField() (string, bool, error) {
+	var field bytes.Buffer
+	tooLong := false
+
+	for {
+		chunk, err := decoder.reader.ReadSlice(separator)
+		decoder.offset += int64(len(chunk))
+
+		if !tooLong {
+			if field.Len()+len(chunk) > maxFieldLength+1 {
+				tooLong = true
+				field.Reset()
+			} else {
+				field.Write(chunk)
+			}
+		}
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+
+		return strings.TrimSuffix(field.String(), "\x00"), tooLong, err
+	}
+}
+
+/*
+	Validates @fields and builds a Record from them, or returns the
+	reason they aren't a valid record.
+*/
+func parseRecord(fields []string) (Record, string) {
+	if len(fields) != fieldsQty {
+		return Record{}, fmt.Sprintf("expected %d fields, found %d", fieldsQty, len(fields))
+	}
+
+	id := fields[0]
+	if !strings.HasPrefix(id, "#") || len(id) == 1 {
+		return Record{}, fmt.Sprintf("invalid transaction id '%s', expected '#' followed by the id", id)
+	}
+
+	products, reason := parseProducts(fields[4])
+	if reason != "" {
+		return Record{}, reason
+	}
+
+	return Record{
+		Id:       id[1:],
+		BuyerId:  fields[1],
+		Ip:       fields[2],
+		Device:   fields[3],
+		Products: products,
+	}, ""
+}
+
+func parseProducts(list string) ([]string, string) {
+	if !strings.HasPrefix(list, "(") || !strings.HasSuffix(list, ")") || len(list) < 2 {
+		return nil, fmt.Sprintf("invalid product list '%s', expected (a,b,c)", list)
+	}
+
+	inner := list[1 : len(list)-1]
+	if inner == "" {
+		return []string{}, ""
+	}
+
+	products := strings.Split(inner, ",")
+	for i, product := range products {
+		if product == "" {
+			return nil, fmt.Sprintf("empty product id at position %d of the product list", i+1)
+		}
+	}
+
+	return products, ""
+}
@@ -0,0 +1,149 @@
+package transactionfeed
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+/*
+	Decodes every record of @feed, returning each one in the format
+	of the feed or the reason it was rejected.
+*/
+func decodeAll(t *testing.T, feed string) []string {
+	t.Helper()
+
+	var outcomes []string
+	decoder := NewDecoder(strings.NewReader(feed))
+	for {
+		record, err := decoder.Next()
+		if err == io.EOF {
+			return outcomes
+		}
+
+		var recordErr *RecordError
+		if errors.As(err, &recordErr) {
+			outcomes = append(outcomes, fmt.Sprintf("%d error: %s", recordErr.Number, recordErr.Reason))
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error | %v", err)
+		}
+
+		outcomes = append(outcomes, fmt.Sprintf("%d %q", record.Number, record.String()))
+	}
+}
+
+/*
+	Returns the record of @fields, closed by the extra NUL.
+*/
+func feedRecord(fields ...string) string {
+	return strings.Join(fields, "\x00") + "\x00\x00"
+}
+
+func TestDecoder(t *testing.T) {
+	valid := feedRecord("#t1", "b1", "1.1.1.1", "mac", "(p1,p2)")
+	second := feedRecord("#t2", "b2", "2.2.2.2", "linux", "()")
+
+	cases := []struct {
+		name string
+		feed string
+		want []string
+	}{
+		{
+			name: "valid records",
+			feed: valid + second,
+			want: []string{
+				`1 "#t1\x00b1\x001.1.1.1\x00mac\x00(p1,p2)"`,
+				`2 "#t2\x00b2\x002.2.2.2\x00linux\x00()"`,
+			},
+		},
+		{
+			name: "empty middle fields",
+			feed: feedRecord("#t1", "b1", "", "", "(p1)") + second,
+			want: []string{
+				`1 "#t1\x00b1\x00\x00\x00(p1)"`,
+				`2 "#t2\x00b2\x002.2.2.2\x00linux\x00()"`,
+			},
+		},
+		{
+			name: "truncated last record",
+			feed: valid + "#t2\x00b2\x002.2",
+			want: []string{
+				`1 "#t1\x00b1\x001.1.1.1\x00mac\x00(p1,p2)"`,
+				"2 error: expected 5 fields, found 3",
+			},
+		},
+		{
+			name: "id without #",
+			feed: feedRecord("t1", "b1", "1.1.1.1", "mac", "(p1)") + second,
+			want: []string{
+				"1 error: invalid transaction id 't1', expected '#' followed by the id",
+				`2 "#t2\x00b2\x002.2.2.2\x00linux\x00()"`,
+			},
+		},
+		{
+			name: "malformed product lists",
+			feed: feedRecord("#t1", "b1", "1.1.1.1", "mac", "p1,p2") +
+				feedRecord("#t2", "b2", "2.2.2.2", "linux", "(p1,,p2)"),
+			want: []string{
+				"1 error: invalid product list 'p1,p2', expected (a,b,c)",
+				"2 error: empty product id at position 2 of the product list",
+			},
+		},
+		{
+			name: "field over the maximum length",
+			feed: feedRecord("#t1", "b1", "1.1.1.1", strings.Repeat("a", maxFieldLength+1), "(p1)") + second,
+			want: []string{
+				fmt.Sprintf("1 error: field longer than %d bytes", maxFieldLength),
+				`2 "#t2\x00b2\x002.2.2.2\x00linux\x00()"`,
+			},
+		},
+		{
+			name: "stray NULs between records",
+			feed: "\x00" + valid + "\x00\x00\x00" + second + "\x00",
+			want: []string{
+				`1 "#t1\x00b1\x001.1.1.1\x00mac\x00(p1,p2)"`,
+				`2 "#t2\x00b2\x002.2.2.2\x00linux\x00()"`,
+			},
+		},
+		{
+			name: "too many fields",
+			feed: feedRecord("#t1", "b1", "1.1.1.1", "mac", "(p1)", "extra") + second,
+			want: []string{
+				"1 error: expected 5 fields, found 6",
+				`2 "#t2\x00b2\x002.2.2.2\x00linux\x00()"`,
+			},
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := decodeAll(t, testCase.feed)
+			if strings.Join(got, "\n") != strings.Join(testCase.want, "\n") {
+				t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(testCase.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestDecoderReportsRecordOffsets(t *testing.T) {
+	valid := feedRecord("#t1", "b1", "1.1.1.1", "mac", "(p1)")
+	decoder := NewDecoder(strings.NewReader(valid + feedRecord("t2", "b2", "", "", "()")))
+
+	_, err := decoder.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = decoder.Next()
+	var recordErr *RecordError
+	if !errors.As(err, &recordErr) {
+		t.Fatalf("got %v, want a *RecordError", err)
+	}
+	if recordErr.Offset != int64(len(valid)) || recordErr.Text != "t2\x00b2\x00\x00\x00()" {
+		t.Errorf("got offset %d and text %q, want %d and the fields of the record", recordErr.Offset, recordErr.Text, len(valid))
+	}
+}
@@ -3,4 +3,6 @@ export enum Endpoints {
   ALL_BUYERS = "http://localhost:9000/buyer/all",
   BUYER = "http://localhost:9000/buyer",
   PRODUCTS = "http://localhost:9000/products",
+  JOBS = "http://localhost:9000/jobs",
+  GRAPHQL = "http://localhost:9000/graphql",
 }
@@ -1,4 +1,4 @@
-import { Transaction, CustomError } from "@/types";
+import { Transaction, CustomError, PageInfo, Problem } from "@/types";
 import { AxiosError } from "axios";
 
 export const currencyFormatter = Intl.NumberFormat("en-US", {
@@ -24,11 +24,46 @@ export const transaction: Transaction = {
   Products: ["cd3de2cc", "4bb66fdd"],
 };
 
+/**
+ * The API pages its collections with cursors, so a page can only be
+ * reached from the one before it. @cursors holds the 'after' cursor
+ * of each page reached so far, starting with "" for the first one.
+ */
+export function pageCursor(cursors: string[], page: number): string {
+  return cursors[page - 1] || "";
+}
+
+/**
+ * Records the cursor of the page after @page, dropping the cursors of
+ * the pages after it, which may have changed. Returns the number of
+ * pages that can be reached.
+ */
+export function updateCursors(
+  cursors: string[],
+  page: number,
+  pageInfo: PageInfo
+): number {
+  cursors.splice(page);
+  if (pageInfo.HasNextPage && pageInfo.EndCursor) {
+    cursors.push(pageInfo.EndCursor);
+  }
+
+  return cursors.length;
+}
+
 export function handleRequestError(error: AxiosError): CustomError {
   if (error.response) {
+    const problem: Problem | undefined =
+      error.response.data && error.response.data.code
+        ? error.response.data
+        : undefined;
+
     return {
-      message: error.response.data,
+      message: problem
+        ? problem.detail || problem.title
+        : String(error.response.data),
       status: String(error.response.status),
+      code: problem ? problem.code : undefined,
     };
   } else if (error.request) {
     return {
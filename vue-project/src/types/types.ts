@@ -21,7 +21,37 @@ export interface Buyer {
   Age: number;
 }
 
+/**
+ * Pagination of the collections of the API. EndCursor is passed as
+ * 'after' to get the next page.
+ */
+export interface PageInfo {
+  EndCursor?: string;
+  HasNextPage: boolean;
+}
+
 export interface CustomError {
   message: string;
   status: string;
+  code?: string;
+}
+
+/**
+ * Body of the error responses of the API (RFC 7807 problem details).
+ */
+export interface Problem {
+  type: string;
+  title: string;
+  status: number;
+  detail?: string;
+  code: string;
+}
+
+/**
+ * Body of the responses of the GraphQL endpoint. Failed fields are
+ * null in 'data' and described in 'errors'.
+ */
+export interface GraphqlResponse {
+  data: any;
+  errors?: { message: string }[];
 }